@@ -0,0 +1,217 @@
+package cmd
+
+import (
+	"bufio"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/redt1de/pnmap/internal/loader"
+)
+
+// absorbedNote starts the XML comments a combined file made with --append-to
+// lists the inputs it has absorbed in, one per input, as
+// "pnmap absorbed: <sha256> <path>".
+const absorbedNote = "pnmap absorbed: "
+
+// runNote starts the XML comments a combined file describes the run of each
+// of its inputs in, as "pnmap run: <path> is <run>".
+const runNote = "pnmap run: "
+
+// tempPrefix starts the names of the temporary files pnmap writes beside its
+// output. A file left behind by a run that was killed is never taken for an
+// input.
+const tempPrefix = ".pnmap-"
+
+// notePath returns a path, or any other text, as it is written in a note.
+// The XML writer escapes the second of two dashes within a comment as
+// "&#45;", ampersands are escaped here so that readNote can tell the two
+// apart and get back the exact text.
+func notePath(s string) string {
+	return strings.ReplaceAll(s, "&", "&amp;")
+}
+
+// readNote returns the text of a note written with notePath.
+func readNote(s string) string {
+	return strings.ReplaceAll(strings.ReplaceAll(s, "&#45;", "-"), "&amp;", "&")
+}
+
+// absorbedInput is an input a combined file has absorbed.
+type absorbedInput struct {
+	// sum is the SHA-256 of the input's content, hex encoded. Inputs are
+	// told apart by content, so an input that was moved or renamed is still
+	// known, and one that was rewritten is new.
+	sum  string
+	path string
+}
+
+// readAbsorbed returns the inputs a combined file has absorbed, in the order
+// they were absorbed, along with the notes on the runs it was combined from.
+// A file that does not exist has absorbed nothing.
+func readAbsorbed(pth string) (absorbed []absorbedInput, runs []string, err error) {
+	f, err := os.Open(pth)
+	if os.IsNotExist(err) {
+		return nil, nil, nil
+	}
+	if err != nil {
+		return nil, nil, err
+	}
+	defer f.Close()
+
+	// the notes follow the header, so there is no need to read on past the
+	// first host
+	scanner := bufio.NewScanner(f)
+	scanner.Buffer(make([]byte, 64*1024), 16*1024*1024)
+	for scanner.Scan() {
+		line := scanner.Text()
+		if strings.HasPrefix(line, "<host") || strings.HasPrefix(line, "<runstats") {
+			break
+		}
+		if run := strings.TrimPrefix(line, "<!-- "+runNote); run != line {
+			runs = append(runs, readNote(strings.TrimSuffix(run, " -->")))
+			continue
+		}
+		note := strings.TrimPrefix(line, "<!-- "+absorbedNote)
+		if note == line {
+			continue
+		}
+		sum, src, _ := strings.Cut(strings.TrimSuffix(note, " -->"), " ")
+		absorbed = append(absorbed, absorbedInput{sum: sum, path: readNote(src)})
+	}
+	return absorbed, runs, scanner.Err()
+}
+
+// detectFile returns the format of a file.
+func detectFile(pth string) (loader.Format, error) {
+	in, err := loader.Open(pth)
+	if err != nil {
+		return loader.Unknown, err
+	}
+	defer in.Close()
+	return in.Format, nil
+}
+
+// fileSum returns the SHA-256 of a file's content, hex encoded.
+func fileSum(pth string) (string, error) {
+	f, err := os.Open(pth)
+	if err != nil {
+		return "", err
+	}
+	defer f.Close()
+	h := sha256.New()
+	if _, err := io.Copy(h, f); err != nil {
+		return "", err
+	}
+	return hex.EncodeToString(h.Sum(nil)), nil
+}
+
+// newInputs expands args into the input files master has not yet absorbed,
+// and returns them along with those master has absorbed so far and the notes
+// on its runs. Inputs are compared by content, a file named twice or a copy
+// of an absorbed file is skipped.
+func newInputs(master string, args []string) (fresh, absorbed []absorbedInput, runs []string, err error) {
+	absorbed, runs, err = readAbsorbed(master)
+	if err != nil {
+		return nil, nil, nil, err
+	}
+	seen := make(map[string]string, len(absorbed))
+	for _, a := range absorbed {
+		seen[a.sum] = a.path
+	}
+
+	files, errs := loader.Expand(args)
+	for _, err := range errs {
+		fmt.Fprintln(os.Stderr, "[ERROR]", err)
+	}
+	for _, f := range files {
+		if f == loader.Stdin {
+			return nil, nil, nil, fmt.Errorf("standard input cannot be told apart from one run to the next, save it to a file first")
+		}
+		if sameFile(f, master) || strings.HasPrefix(filepath.Base(f), tempPrefix) {
+			continue
+		}
+		// files that are not scans, such as notes kept in a directory of
+		// scans, are skipped here as they would be when loading
+		if format, err := detectFile(f); err == nil && format == loader.Unknown {
+			if *verbose {
+				fmt.Fprintln(os.Stderr, "[*] skipping", f+": not a scan file")
+			}
+			continue
+		}
+		sum, err := fileSum(f)
+		if err != nil {
+			fmt.Fprintln(os.Stderr, "[ERROR]", err)
+			continue
+		}
+		if prev, ok := seen[sum]; ok {
+			if *verbose {
+				fmt.Fprintln(os.Stderr, "[*] skipping", f+": already absorbed as", prev)
+			}
+			continue
+		}
+		seen[sum] = f
+		fresh = append(fresh, absorbedInput{sum: sum, path: f})
+	}
+	return fresh, absorbed, runs, nil
+}
+
+// fromInput reports whether source, as named by the loader, was read from
+// the input file f, either as the file itself or as a member of it.
+func fromInput(source, f string) bool {
+	return source == f || strings.HasPrefix(source, f+":")
+}
+
+// sameFile reports whether two paths name the same existing file.
+func sameFile(a, b string) bool {
+	ai, err := os.Stat(a)
+	if err != nil {
+		return false
+	}
+	bi, err := os.Stat(b)
+	if err != nil {
+		return false
+	}
+	return os.SameFile(ai, bi)
+}
+
+// absorbInputs returns absorbed with the fresh inputs that were read added,
+// and how many there were. An input that could not be read is left out, so
+// that the next run tries it again. It fails if the master itself could not
+// be read, as writing the result back would lose what it held.
+func absorbInputs(l *loader.Loader, master string, absorbed, fresh []absorbedInput, loaded []string) ([]absorbedInput, int, error) {
+	failed := make(map[string]bool)
+	for _, err := range l.Errors() {
+		var fe *loader.FileError
+		if !errors.As(err, &fe) {
+			continue
+		}
+		if fromInput(fe.Path, master) {
+			return nil, 0, fmt.Errorf("%s could not be read, it was left as it is", master)
+		}
+		for _, in := range fresh {
+			if fromInput(fe.Path, in.path) {
+				failed[in.path] = true
+			}
+		}
+	}
+
+	n := 0
+	for _, in := range fresh {
+		if failed[in.path] {
+			continue
+		}
+		for _, src := range loaded {
+			if fromInput(src, in.path) {
+				absorbed = append(absorbed, in)
+				n++
+				break
+			}
+		}
+	}
+	return absorbed, n, nil
+}
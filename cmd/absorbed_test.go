@@ -0,0 +1,37 @@
+package cmd
+
+import (
+	"bytes"
+	"strings"
+	"testing"
+
+	"github.com/redt1de/pnmap/internal/nmapxml"
+)
+
+// TestNotePath writes paths as notes the way combine does and reads them back
+// the way readAbsorbed does.
+func TestNotePath(t *testing.T) {
+	tests := []string{
+		"scans/a.xml",
+		"scans/x--y.xml",
+		"scans/x---y.xml",
+		"scans/x-&#45;y.xml",
+		"scans/a&b-.xml",
+		"-",
+	}
+	for _, pth := range tests {
+		var out bytes.Buffer
+		w := nmapxml.NewWriter(&out)
+		if err := w.WriteComment(absorbedNote + notePath(pth)); err != nil {
+			t.Fatal(err)
+		}
+		if err := w.Flush(); err != nil {
+			t.Fatal(err)
+		}
+		line := strings.TrimSuffix(out.String(), "\n")
+		note := strings.TrimSuffix(strings.TrimPrefix(line, "<!-- "+absorbedNote), " -->")
+		if got := readNote(note); got != pth {
+			t.Errorf("%q was written as %q and read back as %q", pth, line, got)
+		}
+	}
+}
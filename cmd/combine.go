@@ -1,11 +1,9 @@
 package cmd
 
 import (
-	"bufio"
-	"encoding/xml"
 	"fmt"
+	"io"
 	"log"
-	"math"
 	"os"
 	"path/filepath"
 	"strconv"
@@ -13,6 +11,11 @@ import (
 	"time"
 
 	nmap "github.com/Ullaakut/nmap/v3"
+	"github.com/redt1de/pnmap/internal/hostid"
+	"github.com/redt1de/pnmap/internal/loader"
+	"github.com/redt1de/pnmap/internal/merge"
+	"github.com/redt1de/pnmap/internal/provenance"
+	"github.com/redt1de/pnmap/internal/targets"
 	"github.com/spf13/cobra"
 )
 
@@ -20,6 +23,10 @@ type hostMap map[string]nmap.Host
 
 var hostmap hostMap
 
+// hostorder holds the hostmap keys in the order they were first seen, so the
+// combined XML comes out the same on every run.
+var hostorder []string
+
 // type fileslice []string
 
 // // var daFiles fileslice
@@ -37,6 +44,11 @@ var outfile *string
 var onlyhosts *string
 var hasports *bool
 var onlyup *bool
+var mergeMode *string
+var portMerge *string
+var explain *bool
+var provFile *string
+var appendTo *string
 
 // combineCmd represents the combine command
 var combineCmd = &cobra.Command{
@@ -44,230 +56,370 @@ var combineCmd = &cobra.Command{
 	Short: "combine nmap XML files into one file.",
 	Long:  `combine can be used to combine multiple nmap XML files into a single XML.`,
 	Run: func(cmd *cobra.Command, args []string) {
+		if *appendTo != "" && cmd.Flags().Changed("out") {
+			fmt.Fprintln(os.Stderr, "[ERROR] --append-to writes back to the master file, it cannot be used with --out")
+			os.Exit(1)
+		}
+		if err := checkPortMerge(cmd, *mergeMode); err != nil {
+			fmt.Fprintln(os.Stderr, "[ERROR]", err)
+			os.Exit(1)
+		}
 		combine(args)
 	},
 }
 
 func init() {
 	rootCmd.AddCommand(combineCmd)
-	outfile = combineCmd.Flags().StringP("out", "o", "nmap-combined.xml", "output file")
+	outfile = combineCmd.Flags().StringP("out", "o", "nmap-combined.xml", "output file, - for stdout")
 	onlyhosts = combineCmd.Flags().StringP("only-hosts", "O", "", "specify a file containing IPs, and only include those in the new XML")
 	hasports = combineCmd.Flags().BoolP("has-ports", "p", false, "exclude hosts with no ports open, handy for -Pn scans.")
 	onlyup = combineCmd.Flags().BoolP("only-up", "u", false, "only include hosts that are marked up")
+	mergeMode = combineCmd.Flags().StringP("merge", "m", "most-ports", "how hosts found in more than one file are combined: most-ports keeps the up record with the most ports, latest the record from the scan that finished last, first the record seen first, union merges ports, hostnames, OS matches and scripts")
+	portMerge = combineCmd.Flags().String("port-merge", "richest", "with --merge union, how ports found in more than one file are combined: richest takes the best state and richest service, latest the port from the scan that finished last, confidence the most confident service detection, first the port seen first")
+	explain = combineCmd.Flags().Bool("explain", false, "print which strategy decided each conflict, per host")
+	provFile = combineCmd.Flags().String("provenance", "", "write a manifest of the input files and runs every host and port came from to this file, and note them in XML comments. The run each input holds is noted in XML comments either way")
+	appendTo = combineCmd.Flags().String("append-to", "", "merge only the inputs not yet absorbed into this combined XML and write the result back to it, in place of --out")
 
 }
 
 func combine(args []string) {
 
 	if len(args) < 1 {
-		fmt.Println("[ERROR ] no input files specified")
+		fmt.Fprintln(os.Stderr, "[ERROR ] no input files specified")
 		os.Exit(1)
 	}
-	//
-	// HasPorts = *hasports
-	hostmap = make(hostMap)
-	final := nmap.Run{}
-	final.Verbose.Level = 0
-	final.Debugging.Level = 0
-	var tmpArgs []string
-	var elapsed float32
-
-	for _, infile := range args {
-		matches, err := filepath.Glob(infile)
+	c, err := newCombiner(*mergeMode, *portMerge)
+	if err != nil {
+		fmt.Fprintln(os.Stderr, "[ERROR]", err)
+		os.Exit(1)
+	}
+	merger := c.merger
+	merger.Explain = *explain
+
+	// with --append-to, the master is read back in as the first input,
+	// followed by the inputs it has not absorbed yet, and the result is
+	// written beside it and then moved over it
+	out := *outfile
+	var fresh, absorbed []absorbedInput
+	var priorRuns []string
+	if *appendTo != "" {
+		if *appendTo == loader.Stdin {
+			fmt.Fprintln(os.Stderr, "[ERROR] --append-to writes back to the master file, it cannot be stdin")
+			os.Exit(1)
+		}
+		fresh, absorbed, priorRuns, err = newInputs(*appendTo, args)
 		if err != nil {
-			fmt.Println(err)
+			fmt.Fprintln(os.Stderr, "[ERROR]", err)
+			os.Exit(1)
 		}
-		for _, f := range matches {
-			fmt.Println("[+] parsing", f)
-
-			nRun := nmap.Run{}
-			err := nRun.FromFile(f)
-			if err != nil {
-				log.Fatal("Failed to parse XML:(", f, ") ", err)
-				continue
-			}
-			final.Scanner = nRun.Scanner
-			tmpArgs = append(tmpArgs, nRun.Args)
-
-			// versions are set to whichever version is newest
-			final.Version = strGreater(final.Version, nRun.Version)
-			final.XMLOutputVersion = strGreater(final.XMLOutputVersion, nRun.XMLOutputVersion)
-
-			// start time is set to the earliest/first scan run
-			te := timeEarlier(nRun.Start, final.Start)
-			if te == nRun.Start {
-				final.Start = te
-				final.StartStr = nRun.StartStr
-			}
-
-			final.ProfileName = nRun.ProfileName
-			final.ScanInfo = nRun.ScanInfo
-
-			// verbosity and debugging is set to whichever has the highest verbosity/dbugging level
-			if nRun.Verbose.Level > final.Verbose.Level {
-				final.Verbose.Level = nRun.Verbose.Level
-			}
-
-			if nRun.Debugging.Level > final.Debugging.Level {
-				final.Debugging.Level = nRun.Debugging.Level
-			}
+		if len(fresh) == 0 {
+			fmt.Fprintln(os.Stderr, "[+] nothing new to absorb into", *appendTo)
+			return
+		}
+		args = nil
+		if _, err := os.Stat(*appendTo); err == nil {
+			args = append(args, *appendTo)
+		}
+		for _, in := range fresh {
+			args = append(args, in.path)
+		}
+	}
 
-			final.TaskBegin = append(final.TaskBegin, nRun.TaskBegin...)
-			final.TaskProgress = append(final.TaskProgress, nRun.TaskProgress...)
-			final.TaskEnd = append(final.TaskEnd, nRun.TaskEnd...)
-			final.PreScripts = append(final.PreScripts, nRun.PreScripts...)
-			final.PostScripts = append(final.PostScripts, nRun.PostScripts...)
-			final.Targets = append(final.Targets, nRun.Targets...)
-
-			// elapsed time is combined from all scans
-			elapsed += nRun.Stats.Finished.Elapsed
-
-			// end time is the last time from all scans
-			tl := timeLater(nRun.Stats.Finished.Time, final.Stats.Finished.Time)
-			if tl == nRun.Stats.Finished.Time {
-				final.Stats.Finished.Time = nRun.Stats.Finished.Time
-				final.Stats.Finished.TimeStr = nRun.Stats.Finished.TimeStr
-			}
+	// the run of every input is recorded, sources keeps them in input order.
+	// With --provenance, where each host and port was taken from is recorded
+	// as well
+	manifest := provenance.New(outputName(out))
+	var prior *provenance.Manifest
+	var sources []string
+	if *appendTo != "" {
+		manifest.Output = *appendTo
+		if *provFile != "" {
+			prior = priorProvenance(manifest)
+		}
+	}
+	var loaded []string
 
-			for _, hst := range nRun.Hosts {
-				if len(hostmap[hst.Addresses[0].Addr].Addresses) == 0 {
-					hostmap[hst.Addresses[0].Addr] = hst
-				} else {
-					if hostmap[hst.Addresses[0].Addr].Status.State == "up" && hst.Status.State == "down" {
-						continue
-					}
-					if hostmap[hst.Addresses[0].Addr].Status.State == "down" && hst.Status.State == "up" {
-						hostmap[hst.Addresses[0].Addr] = hst
-					}
-					if hostmap[hst.Addresses[0].Addr].Status.State == "down" && hst.Status.State == "down" {
-						continue
-					}
-					if hostmap[hst.Addresses[0].Addr].Status.State == "up" && hst.Status.State == "up" {
-						if len(hostmap[hst.Addresses[0].Addr].Ports) > len(hst.Ports) {
-							continue
-						} else if len(hostmap[hst.Addresses[0].Addr].Ports) < len(hst.Ports) {
-							hostmap[hst.Addresses[0].Addr] = hst
-						} else {
-							continue
-						}
-
-					}
-				}
+	ldr := newMergingLoader()
+	ldr.Progress = func(f string) {
+		fmt.Fprintln(os.Stderr, "[+] parsing", f)
+	}
+	h := c.handler()
+	ldr.Load(args, loader.Handler{
+		Run: func(r loader.Run) {
+			loaded = append(loaded, r.Source)
+			if r.Source != *appendTo {
+				manifest.Runs[r.Source] = provenanceRun(r.Run)
+				sources = append(sources, r.Source)
 			}
+			h.Run(r)
+		},
+		Host: h.Host,
+	})
+	reportErrors(ldr)
+	var nfresh int
+	if *appendTo != "" {
+		absorbed, nfresh, err = absorbInputs(ldr, *appendTo, absorbed, fresh, loaded)
+		if err != nil {
+			fmt.Fprintln(os.Stderr, "[ERROR]", err)
+			os.Exit(1)
+		}
+		if nfresh == 0 {
+			fmt.Fprintln(os.Stderr, "[-] none of the new inputs could be read,", *appendTo, "was left as it is")
+			os.Exit(1)
 		}
 	}
+	var kept map[string]nmap.Host
+	hostorder, kept = c.hosts()
+	hostmap = hostMap(kept)
+	reportLists()
+
+	// the run stats count every host scanned, including those left out of
+	// the new XML by the filters below
+	final := c.result()
+	if *explain {
+		explainConflicts(merger, hostorder)
+	}
 	if *onlyhosts != "" {
 		hostmap = GetOnlyHosts(hostmap, *onlyhosts)
 	}
 
-	// iterate the hosts map and add to final hosts results
-	for k := range hostmap {
-		// if onlyup flag is preset, check for up status
-		if *onlyup && hostmap[k].Status.State != "up" {
-			continue
-		}
+	// the args of each run are recorded in the run notes below
+	final.Args = strings.Join(os.Args, " ")
+	// final.StartStr = time.Now().Format("Mon Jan 2 15:04:05 2006")
 
-		final.Hosts = append(final.Hosts, hostmap[k])
+	var f io.WriteCloser
+	if *appendTo != "" {
+		// the result is written beside the master, under a name that is
+		// never taken for an input should the run be killed
+		tmp, err := os.CreateTemp(filepath.Dir(*appendTo), tempPrefix+"append-*")
+		if err != nil {
+			log.Fatal("Failed to write the file", *appendTo+":", err)
+		}
+		// temporary files are private, the master keeps the mode it had
+		mode := os.FileMode(0644)
+		if fi, err := os.Stat(*appendTo); err == nil {
+			mode = fi.Mode().Perm()
+		}
+		if err := tmp.Chmod(mode); err != nil {
+			log.Fatal("Failed to write the file", *appendTo+":", err)
+		}
+		f, out = tmp, tmp.Name()
+	} else {
+		f, err = createOutput(out)
+		if err != nil {
+			log.Fatal("Failed to write the file", out+":", err)
+		}
+	}
+	defer f.Close()
+	cw := &countingWriter{w: f}
+
+	w := newXMLWriter(cw)
+	w.Comment = "Nmap scan results, parsed by brads tool"
+	w.ScanInfo = c.scanInfos
+	if err := w.WriteHeader(&final); err != nil {
+		log.Fatal("Failed to write the file", out+":", err)
 	}
 
-	final.Stats.Finished.Elapsed = elapsed
-	final.Stats.Finished.Summary = "Parsed by brads nmap tool"
-	final.Stats.Finished.Exit = "success"
-
-	// args are all combined so there is a record of each command run
-	final.Args = strings.Join(tmpArgs, " /-/ ")
+	for _, in := range absorbed {
+		if err := w.WriteComment(absorbedNote + in.sum + " " + notePath(in.path)); err != nil {
+			log.Fatal("Failed to write the file", out+":", err)
+		}
+	}
+	for _, run := range priorRuns {
+		if err := w.WriteComment(runNote + notePath(run)); err != nil {
+			log.Fatal("Failed to write the file", out+":", err)
+		}
+	}
+	for _, src := range sources {
+		if err := w.WriteComment(runNote + notePath(src+" is "+manifest.Runs[src].String())); err != nil {
+			log.Fatal("Failed to write the file", out+":", err)
+		}
+	}
 
-	// if hasports flag is present call func to remove hosts with no ports
 	if *hasports {
-		final.Hosts = withPorts(final.Hosts)
+		fmt.Fprintln(os.Stderr, "[+] Filtering hosts with no ports")
 	}
 
-	// count up,down,total hosts in final.hosts
-	var up, down, total int
-	for _, h := range final.Hosts {
-		if h.Status.State == "up" {
+	// stream the hosts map into the new XML, hosts are dropped from the map once
+	// written so they are never held twice.
+	var up, down int
+	for _, k := range hostorder {
+		hst, ok := hostmap[k]
+		if !ok {
+			continue
+		}
+		delete(hostmap, k)
+
+		// if onlyup flag is preset, check for up status
+		if *onlyup && hst.Status.State != "up" {
+			continue
+		}
+		// if hasports flag is present skip hosts with no ports
+		if *hasports && !hasOpenPorts(hst) {
+			fmt.Fprintln(os.Stderr, "[-] Skipping host with no ports:", k)
+			continue
+		}
+
+		if *provFile != "" {
+			if err := w.WriteComment(noteProvenance(manifest, prior, merger, k, hst)); err != nil {
+				log.Fatal("Failed to write the file", out+":", err)
+			}
+		}
+		if err := w.WriteHost(&hst); err != nil {
+			log.Fatal("Failed to write the file", out+":", err)
+		}
+
+		// count up,down hosts written
+		if hst.Status.State == "up" {
 			up++
 		} else {
 			down++
 		}
-		total++
 	}
 
-	final.Stats.Hosts.Up = up
-	final.Stats.Hosts.Down = down
-	final.Stats.Hosts.Total = total
+	if err := w.Close(&final); err != nil {
+		log.Fatal("Failed to write the file", out+":", err)
+	}
+	if err := f.Close(); err != nil {
+		log.Fatal("Failed to write the file", out+":", err)
+	}
+	if *appendTo != "" {
+		if err := os.Rename(out, *appendTo); err != nil {
+			log.Fatal("Failed to replace ", *appendTo, ": ", err)
+		}
+		out = *appendTo
+		fmt.Fprintln(os.Stderr, "[+] Absorbed", nfresh, "new inputs into", out)
+	}
 
-	final.Args = strings.Join(os.Args, " ")
-	// final.StartStr = time.Now().Format("Mon Jan 2 15:04:05 2006")
+	scanned := fmt.Sprint(final.Stats.Hosts.Total)
+	if _, exact := c.unlistedDown(); !exact {
+		// scans of different targets left out different down hosts
+		scanned = "at least " + scanned
+	}
+	fmt.Fprintln(os.Stderr, "[+]", up, "up and", down, "down hosts included in the new XML, out of", scanned, "scanned.")
 
-	fmt.Println("[+]", final.Stats.Hosts.Up, "included in the new XML.")
+	fmt.Fprintln(os.Stderr, "[+] Wrote ", cw.n, "bytes to", outputName(out))
 
-	// pencil whip the xml header
-	out := []byte(`<?xml version="1.0" encoding="UTF-8"?>
-<!DOCTYPE nmaprun>
-<?xml-stylesheet href="file:///usr/share/nmap/nmap.xsl" type="text/xsl"?>
-<!-- Nmap scan results, parsed by brads tool -->
+	if *provFile != "" {
+		if err := manifest.Save(*provFile); err != nil {
+			log.Fatal("Failed to write the provenance manifest ", *provFile+": ", err)
+		}
+		fmt.Fprintln(os.Stderr, "[+] Wrote provenance manifest to", *provFile)
+	}
+}
 
-`)
+// provenanceRun returns what the provenance manifest records of a run.
+func provenanceRun(r nmap.Run) provenance.Run {
+	run := provenance.Run{Scanner: r.Scanner, Version: r.Version, Args: r.Args}
+	if t := time.Time(r.Start); !t.IsZero() {
+		run.Start = t.Unix()
+	}
+	return run
+}
 
-	// marshall xml using +++++ as indent which is then stripped out, otherwise ingest doesnt like the format.
-	outbody, err := xml.MarshalIndent(final, "", "+++++")
+// priorProvenance loads the manifest an earlier combine into the --append-to
+// master wrote, and carries its runs over into m. It returns the manifest, nil
+// if there is none.
+func priorProvenance(m *provenance.Manifest) *provenance.Manifest {
+	if _, err := os.Stat(*provFile); err != nil {
+		return nil
+	}
+	prior, err := provenance.Load(*provFile)
 	if err != nil {
-		log.Fatal("Failed to marshall XML:", err)
+		fmt.Fprintln(os.Stderr, "[ERROR]", err)
+		return nil
+	}
+	for src, run := range prior.Runs {
+		m.Runs[src] = run
 	}
-	out = append(out, outbody...)
-	tmp := strings.ReplaceAll(string(out), "NmapRun", "nmaprun")
-	tmp = strings.ReplaceAll(tmp, "+++++", "")
+	return prior
+}
 
-	err = os.WriteFile(*outfile, []byte(tmp), 0655)
-	if err != nil {
-		log.Fatal("Failed to write the file", *outfile+":", err)
+// noteProvenance records where a host and its ports were taken from in the
+// manifest and returns the same as a single line for an XML comment. Records
+// taken from the --append-to master are traced back to the inputs prior
+// holds for them.
+func noteProvenance(m, prior *provenance.Manifest, merger *merge.Merger, key string, hst nmap.Host) string {
+	sources, ports := merger.Provenance(key)
+	if prior != nil {
+		was := prior.Hosts[key]
+		sources = priorSources(sources, was.Sources)
+		for pk, srcs := range ports {
+			ports[pk] = priorSources(srcs, was.Ports[pk])
+		}
 	}
+	ph := provenance.Host{Sources: sources, Ports: make(map[string][]string)}
+	for _, ip := range []string{hostid.IPv4(hst), hostid.IPv6(hst)} {
+		if ip != "" && ip != key {
+			ph.Addresses = append(ph.Addresses, ip)
+		}
+	}
+	note := "pnmap provenance: " + key + " from " + strings.Join(sources, ", ")
+	for _, p := range hst.Ports {
+		pk := merge.PortKey(p)
+		ph.Ports[pk] = ports[pk]
+		note += "; " + pk + " from " + strings.Join(ports[pk], ", ")
+	}
+	m.Hosts[key] = ph
+	return note
+}
 
-	fmt.Println("[+] Wrote ", len(out), "bytes to", *outfile)
+// priorSources replaces the --append-to master among sources with the inputs
+// it took the record from, if known.
+func priorSources(sources, prior []string) []string {
+	var out []string
+	for _, src := range sources {
+		if src == *appendTo && len(prior) > 0 {
+			out = append(out, prior...)
+		} else {
+			out = append(out, src)
+		}
+	}
+	return unique(out)
 }
 
+// GetOnlyHosts returns the hosts of hostmap on the list in onlyfile, which
+// may hold IPs, CIDRs, nmap ranges and hostnames, see targets.Read. Entries
+// that matched no host are reported.
 func GetOnlyHosts(hostmap hostMap, onlyfile string) hostMap {
-
-	ret := make(hostMap)
-	file, err := os.Open(onlyfile)
+	only, err := targets.Load(onlyfile)
 	if err != nil {
 		log.Fatal(err)
 	}
-	defer file.Close()
 
-	scanner := bufio.NewScanner(file)
-	for scanner.Scan() {
-		line := scanner.Text()
-		if val, ok := hostmap[line]; ok {
-			ret[line] = val
+	ret := make(hostMap)
+	for k, hst := range hostmap {
+		if only.Match(hst) {
+			ret[k] = hst
 		}
 	}
-
-	if err := scanner.Err(); err != nil {
-		log.Fatal(err)
-	}
+	reportUnused("--only-hosts", only)
 	return ret
 
 }
 
-// withPorts removes any hosts that do not have ports open.
-func withPorts(hosts []nmap.Host) []nmap.Host {
-	fmt.Println("[+] Filtering hosts with no ports")
-	ret := []nmap.Host{}
-	for _, h := range hosts {
-		flg := false
-		for _, p := range h.Ports {
-			if p.State.State == "open" {
-				flg = true
-				ret = append(ret, h)
+// explainConflicts prints how the conflicts of the hosts in keys, those found
+// in more than one file, were resolved.
+func explainConflicts(m *merge.Merger, keys []string) {
+	for _, k := range keys {
+		for _, c := range m.Conflicts(k) {
+			if c.Port == "" {
+				fmt.Fprintln(os.Stderr, "[*]", k+":", c)
+			} else {
+				fmt.Fprintln(os.Stderr, "[*]", k, c.Port+":", c)
 			}
 		}
-		if !flg {
-			fmt.Println("[-] Skipping host with no ports:", h.Addresses[0].Addr)
+	}
+}
+
+// hasOpenPorts reports whether a host has at least one open port.
+func hasOpenPorts(h nmap.Host) bool {
+	for _, p := range h.Ports {
+		if p.State.State == "open" {
+			return true
 		}
 	}
-	return ret
+	return false
 }
 
 // timeEarlier compares two timestamps and returns whichever is earlier
@@ -275,12 +427,12 @@ func timeEarlier(a, b nmap.Timestamp) nmap.Timestamp {
 	as := time2str(a)
 	ai, err := strconv.ParseInt(as, 10, 64)
 	if err != nil {
-		fmt.Println("[ERROR] timeEarlier A:", err)
+		fmt.Fprintln(os.Stderr, "[ERROR] timeEarlier A:", err)
 	}
 	bs := time2str(b)
 	bi, err := strconv.ParseInt(bs, 10, 64)
 	if err != nil {
-		fmt.Println("[ERROR] timeEarlier B:", err)
+		fmt.Fprintln(os.Stderr, "[ERROR] timeEarlier B:", err)
 	}
 	if ai < 0 && bi > 0 {
 		return b
@@ -300,12 +452,12 @@ func timeLater(a, b nmap.Timestamp) nmap.Timestamp {
 	as := time2str(a)
 	ai, err := strconv.ParseInt(as, 10, 64)
 	if err != nil {
-		fmt.Println("[ERROR] timeEarlier A:", err)
+		fmt.Fprintln(os.Stderr, "[ERROR] timeEarlier A:", err)
 	}
 	bs := time2str(b)
 	bi, err := strconv.ParseInt(bs, 10, 64)
 	if err != nil {
-		fmt.Println("[ERROR] timeEarlier B:", err)
+		fmt.Fprintln(os.Stderr, "[ERROR] timeEarlier B:", err)
 	}
 	if ai < 0 && bi > 0 {
 		return b
@@ -320,20 +472,6 @@ func timeLater(a, b nmap.Timestamp) nmap.Timestamp {
 
 }
 
-// strGreater is a util function that compares two float strings, and returns whichever is greater.
-func strGreater(a, b string) string {
-	ai, err := strconv.ParseFloat(a, 32)
-	if err != nil {
-		ai = 0
-	}
-	bi, err := strconv.ParseFloat(b, 32)
-	if err != nil {
-		bi = 0
-	}
-	return fmt.Sprintf("%.2f", math.Max(ai, bi))
-
-}
-
 // str2time converts a string containing a UNIX timestamp to to a time.Time.
 func str2time(s string) (nmap.Timestamp, error) {
 	ts, err := strconv.ParseInt(s, 10, 64)
@@ -0,0 +1,257 @@
+package cmd
+
+import (
+	"fmt"
+	"strings"
+
+	nmap "github.com/Ullaakut/nmap/v3"
+	"github.com/redt1de/pnmap/internal/hostid"
+	"github.com/redt1de/pnmap/internal/loader"
+	"github.com/redt1de/pnmap/internal/merge"
+	"github.com/spf13/cobra"
+)
+
+// combiner merges the hosts and run metadata of every input handed to it
+// into a single run, the way combine does.
+type combiner struct {
+	merger    *merge.Merger
+	keyer     *hostid.Keyer
+	run       nmap.Run
+	scanInfos []nmap.ScanInfo
+
+	// nmap leaves hosts that are down out of the XML unless run verbose, they
+	// only show in the run stats. reading counts the hosts each input lists
+	// while it is read, and read holds the counts of the inputs read in full,
+	// so the hosts they left out can be added to the totals, see result.
+	reading map[string]*inputHosts
+	read    map[string]inputHosts
+
+	// keptKeys and kept cache the hosts that are kept, see hosts. kept is
+	// nil until they are worked out again.
+	keptKeys []string
+	kept     map[string]nmap.Host
+}
+
+// newCombiner returns a combiner merging hosts with the named host and port
+// strategies, see merge.NewMerger.
+func newCombiner(host, port string) (*combiner, error) {
+	merger, err := merge.NewMerger(host, port)
+	if err != nil {
+		return nil, err
+	}
+	return &combiner{
+		merger:  merger,
+		keyer:   newKeyer(),
+		reading: make(map[string]*inputHosts),
+		read:    make(map[string]inputHosts),
+	}, nil
+}
+
+// inputHosts counts the hosts of an input: those it listed, merged or not,
+// the down hosts among them, and the down hosts it left out. args is the
+// command line of the scan without its output options, see scanArgs.
+type inputHosts struct {
+	listed, down, unlisted int
+	args                   string
+}
+
+// checkPortMerge returns an error when --port-merge was given to cmd with a
+// host strategy other than union, the only one that merges ports.
+func checkPortMerge(cmd *cobra.Command, host string) error {
+	if cmd.Flags().Changed("port-merge") && host != "union" {
+		return fmt.Errorf("--port-merge only applies with --merge union, not --merge %s", host)
+	}
+	return nil
+}
+
+// addHost merges a host into the hosts seen so far and returns the key it is
+// known by, or "" if it was skipped for having no IP address.
+func (c *combiner) addHost(h loader.Host) string {
+	in := c.reading[h.Source]
+	if in == nil {
+		in = &inputHosts{}
+		c.reading[h.Source] = in
+	}
+	if h.Status.State != "up" {
+		in.down++
+	}
+	key := c.keyer.Key(h.Host)
+	if key == "" {
+		skipNoIP(h.Host)
+		return ""
+	}
+	in.listed++
+	c.merger.Add(key, h.Host, h.Source)
+	c.kept = nil
+	return key
+}
+
+// hosts returns the keys of the merged hosts that are kept, in the order they
+// were first added, and the hosts by key, trimmed to what is in scope. The
+// --include and --exclude lists and the scope are applied here rather than
+// to each record, so a host is judged by every address and hostname any of
+// its records had, and flagged once however many records it had. The map is
+// the merger's own when there are no lists and no scope.
+func (c *combiner) hosts() ([]string, map[string]nmap.Host) {
+	if !listsGiven() && *scopeFile == "" {
+		return c.merger.Keys(), c.merger.Hosts()
+	}
+	if c.kept == nil {
+		c.keptKeys = nil
+		c.kept = make(map[string]nmap.Host)
+		all := c.merger.Hosts()
+		for _, k := range c.merger.Keys() {
+			if h := all[k]; keepHost(&h) {
+				c.keptKeys = append(c.keptKeys, k)
+				c.kept[k] = h
+			}
+		}
+	}
+	return c.keptKeys, c.kept
+}
+
+// addRun merges the run level metadata of an input, once all of its hosts
+// have been added.
+func (c *combiner) addRun(r loader.Run) {
+	final := &c.run
+	nRun := r.Run
+	final.Scanner = nRun.Scanner
+
+	// versions are set to whichever version is newest
+	final.Version = merge.NewerVersion(final.Version, nRun.Version)
+	final.XMLOutputVersion = merge.NewerVersion(final.XMLOutputVersion, nRun.XMLOutputVersion)
+
+	// start time is set to the earliest/first scan run
+	te := timeEarlier(nRun.Start, final.Start)
+	if te == nRun.Start {
+		final.Start = te
+		final.StartStr = nRun.StartStr
+	}
+
+	final.ProfileName = nRun.ProfileName
+	// every type of scan run is kept, with the ports of each merged
+	c.scanInfos = merge.ScanInfos(append(c.scanInfos, r.ScanInfos...))
+
+	// verbosity and debugging is set to whichever has the highest verbosity/dbugging level
+	if nRun.Verbose.Level > final.Verbose.Level {
+		final.Verbose.Level = nRun.Verbose.Level
+	}
+
+	if nRun.Debugging.Level > final.Debugging.Level {
+		final.Debugging.Level = nRun.Debugging.Level
+	}
+
+	final.TaskBegin = append(final.TaskBegin, nRun.TaskBegin...)
+	final.TaskProgress = append(final.TaskProgress, nRun.TaskProgress...)
+	final.TaskEnd = append(final.TaskEnd, nRun.TaskEnd...)
+	final.PreScripts = merge.RunScripts(final.PreScripts, nRun.PreScripts)
+	final.PostScripts = merge.RunScripts(final.PostScripts, nRun.PostScripts)
+	final.Targets = merge.Targets(final.Targets, nRun.Targets)
+
+	// an input read again, such as the same file given twice, counts as
+	// read the last time
+	var in inputHosts
+	if reading := c.reading[r.Source]; reading != nil {
+		in = *reading
+	}
+	in.unlisted = nRun.Stats.Hosts.Down - in.down
+	in.args = scanArgs(nRun.Args)
+	c.read[r.Source] = in
+	delete(c.reading, r.Source)
+
+	// elapsed time is combined from all scans
+	final.Stats.Finished.Elapsed += nRun.Stats.Finished.Elapsed
+
+	// end time is the last time from all scans
+	tl := timeLater(nRun.Stats.Finished.Time, final.Stats.Finished.Time)
+	if tl == nRun.Stats.Finished.Time {
+		final.Stats.Finished.Time = nRun.Stats.Finished.Time
+		final.Stats.Finished.TimeStr = nRun.Stats.Finished.TimeStr
+	}
+}
+
+// handler returns a loader handler adding every host and run to c.
+func (c *combiner) handler() loader.Handler {
+	return loader.Handler{
+		Run: c.addRun,
+		Host: func(h loader.Host) {
+			c.addHost(h)
+		},
+	}
+}
+
+// unlistedDown returns how many hosts that are down the inputs left out of
+// their XML and are not among the merged hosts, and whether that is exact. A
+// host one input left out may be listed by another, so the merged hosts an
+// input did not list are taken off what it left out. Adding up what each
+// input left out would count a host once per scan of it, so the most any
+// single input left out is taken. This is exact for scans of the same
+// targets, but only a lower bound for scans of different targets, which
+// cannot be told apart from the stats alone: it is taken as exact when every
+// input that left hosts out was run with the same arguments.
+//
+// Hosts left out cannot be matched against the --include and --exclude lists
+// or the scope, so none are counted when those are given.
+func (c *combiner) unlistedDown() (int, bool) {
+	if listsGiven() || *scopeFile != "" {
+		return 0, true
+	}
+	merged := len(c.merger.Hosts())
+	most := 0
+	exact := true
+	args := ""
+	for _, in := range c.read {
+		if in.unlisted <= 0 {
+			continue
+		}
+		if args == "" {
+			args = in.args
+		} else if in.args != args {
+			exact = false
+		}
+		if n := in.unlisted - (merged - in.listed); n > most {
+			most = n
+		}
+	}
+	return most, exact
+}
+
+// scanArgs returns the command line of a scan without its output options,
+// which name a different file for every scan of the same targets.
+func scanArgs(args string) string {
+	var kept []string
+	fields := strings.Fields(args)
+	for i := 0; i < len(fields); i++ {
+		f := fields[i]
+		if strings.HasPrefix(f, "-o") && len(f) == 3 {
+			i++
+			continue
+		}
+		kept = append(kept, f)
+	}
+	return strings.Join(kept, " ")
+}
+
+// result returns the combined run, without hosts, its stats counting every
+// host kept so far.
+func (c *combiner) result() nmap.Run {
+	final := c.run
+	final.Stats.Hosts = nmap.HostStats{}
+	_, hosts := c.hosts()
+	for _, hst := range hosts {
+		if hst.Status.State == "up" {
+			final.Stats.Hosts.Up++
+		} else {
+			final.Stats.Hosts.Down++
+		}
+	}
+	unlisted, exact := c.unlistedDown()
+	final.Stats.Hosts.Down += unlisted
+	final.Stats.Hosts.Total = final.Stats.Hosts.Up + final.Stats.Hosts.Down
+	final.Stats.Finished.Summary = "Parsed by brads nmap tool"
+	if !exact {
+		final.Stats.Finished.Summary += "; the inputs may be of different targets, so the hosts down are a lower bound"
+	}
+	final.Stats.Finished.Exit = "success"
+	return final
+}
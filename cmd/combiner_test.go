@@ -0,0 +1,119 @@
+package cmd
+
+import (
+	"testing"
+
+	nmap "github.com/Ullaakut/nmap/v3"
+	"github.com/redt1de/pnmap/internal/loader"
+)
+
+// TestCombinerStats counts the down hosts scans left out of their XML once,
+// however many scans left them out or listed them. down is the true count,
+// which for scans of different targets the stats only give a lower bound of.
+func TestCombinerStats(t *testing.T) {
+	host := func(addr, state string) nmap.Host {
+		return nmap.Host{
+			Addresses: []nmap.Address{{Addr: addr, AddrType: "ipv4"}},
+			Status:    nmap.Status{State: state},
+		}
+	}
+	type input struct {
+		args  string
+		hosts []nmap.Host
+		up    int
+		down  int
+	}
+	tests := []struct {
+		name   string
+		inputs []input
+		up     int
+		down   int
+		exact  bool
+	}{
+		{
+			name: "one scan",
+			inputs: []input{
+				{"nmap -oX a.xml 10.0.0.0/24", []nmap.Host{host("10.0.0.1", "up")}, 1, 255},
+			},
+			up: 1, down: 255, exact: true,
+		},
+		{
+			name: "same hosts up",
+			inputs: []input{
+				{"nmap -oX a.xml 10.0.0.0/24", []nmap.Host{host("10.0.0.1", "up")}, 1, 255},
+				{"nmap -oX b.xml 10.0.0.0/24", []nmap.Host{host("10.0.0.1", "up")}, 1, 255},
+			},
+			up: 1, down: 255, exact: true,
+		},
+		{
+			name: "different hosts up",
+			inputs: []input{
+				{"nmap -oX a.xml 10.0.0.0/24", []nmap.Host{host("10.0.0.1", "up"), host("10.0.0.2", "up")}, 2, 254},
+				{"nmap -oX b.xml 10.0.0.0/24", []nmap.Host{host("10.0.0.3", "up")}, 1, 255},
+			},
+			up: 3, down: 253, exact: true,
+		},
+		{
+			// the host the first scan lists as down is one the second left out
+			name: "down host listed by one scan",
+			inputs: []input{
+				{"nmap -v -oX a.xml 10.0.0.0/24", []nmap.Host{host("10.0.0.1", "up"), host("10.0.0.9", "down")}, 1, 255},
+				{"nmap -v -oX b.xml 10.0.0.0/24", []nmap.Host{host("10.0.0.1", "up")}, 1, 255},
+			},
+			up: 1, down: 255, exact: true,
+		},
+		{
+			name: "scans of different targets",
+			inputs: []input{
+				{"nmap -oX a.xml 10.0.0.0/24", []nmap.Host{host("10.0.0.1", "up")}, 1, 255},
+				{"nmap -oX b.xml 10.0.1.0/28", []nmap.Host{host("10.0.1.1", "up")}, 1, 15},
+			},
+			up: 2, down: 270, exact: false,
+		},
+	}
+	for _, tt := range tests {
+		c, err := newCombiner("most-ports", "richest")
+		if err != nil {
+			t.Fatal(err)
+		}
+		for i, in := range tt.inputs {
+			src := string(rune('a'+i)) + ".xml"
+			for _, h := range in.hosts {
+				c.addHost(loader.Host{Host: h, Source: src})
+			}
+			var run nmap.Run
+			run.Args = in.args
+			run.Stats.Hosts = nmap.HostStats{Up: in.up, Down: in.down, Total: in.up + in.down}
+			c.addRun(loader.Run{Run: run, Source: src})
+		}
+		s := c.result().Stats.Hosts
+		if _, exact := c.unlistedDown(); exact != tt.exact {
+			t.Errorf("%s: exact is %v", tt.name, exact)
+		}
+		if s.Up != tt.up || s.Total != s.Up+s.Down {
+			t.Errorf("%s: got %d up of %d, want %d up", tt.name, s.Up, s.Total, tt.up)
+		}
+		if tt.exact && s.Down != tt.down {
+			t.Errorf("%s: got %d down, want %d", tt.name, s.Down, tt.down)
+		}
+		if !tt.exact && s.Down > tt.down {
+			t.Errorf("%s: got %d down, above the %d there are", tt.name, s.Down, tt.down)
+		}
+	}
+}
+
+func TestScanArgs(t *testing.T) {
+	tests := []struct {
+		args string
+		want string
+	}{
+		{"nmap -sV -oX a.xml 10.0.0.0/24", "nmap -sV 10.0.0.0/24"},
+		{"nmap -oA scans/a -p 22,80 10.0.0.0/24", "nmap -p 22,80 10.0.0.0/24"},
+		{"nmap --open -O 10.0.0.1", "nmap --open -O 10.0.0.1"},
+	}
+	for _, tt := range tests {
+		if got := scanArgs(tt.args); got != tt.want {
+			t.Errorf("scanArgs(%q) = %q, want %q", tt.args, got, tt.want)
+		}
+	}
+}
@@ -0,0 +1,238 @@
+package cmd
+
+import (
+	"bufio"
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"strings"
+
+	nmap "github.com/Ullaakut/nmap/v3"
+	"github.com/redt1de/pnmap/internal/hostid"
+	"github.com/redt1de/pnmap/internal/loader"
+	"github.com/redt1de/pnmap/internal/scandiff"
+	"github.com/spf13/cobra"
+)
+
+var diffFormat *string
+var diffOut *string
+var diffMerge *string
+var diffPortMerge *string
+
+// diffCmd represents the diff command
+var diffCmd = &cobra.Command{
+	Use:   "diff [options] <baseline> <new scan>",
+	Short: "compare a scan against a baseline and list what changed",
+	Long: `diff compares a new scan against a baseline and lists the hosts that appeared
+or disappeared, the ports that were opened or closed and the services whose
+name, product or version changed.
+
+  pnmap diff 'nightly/2023-10-01/*.xml' 'nightly/2023-10-02/*.xml'
+
+Either side may be a scan file, a directory or a quoted glob of scan files,
+which are combined first, the way combine does. Only hosts that are up and
+ports that are open are compared, so a host that went down disappeared and a
+port that became filtered closed. A service only changed if a field of it is
+known on both sides, a scan run without -sV does not change every service.
+
+diff exits with status 0 if the scans are the same, 1 if they differ and 2 if
+it failed, such as when an input could not be read or a flag is wrong, so it
+can be run from cron.`,
+	SilenceUsage:  true,
+	SilenceErrors: true,
+	Run: func(cmd *cobra.Command, args []string) {
+		failStatus = 2
+		if err := checkPortMerge(cmd, *diffMerge); err != nil {
+			diffFailed(err)
+		}
+		diff(args)
+	},
+}
+
+func init() {
+	rootCmd.AddCommand(diffCmd)
+	diffFormat = diffCmd.Flags().StringP("format", "f", "text", "output format: text, json, or xml, the format of nmap's ndiff --xml")
+	diffOut = diffCmd.Flags().StringP("out", "o", "-", "output file, - for stdout")
+	diffMerge = diffCmd.Flags().StringP("merge", "m", "most-ports", "how hosts found in more than one file of a side are combined, see combine --merge")
+	diffPortMerge = diffCmd.Flags().String("port-merge", "richest", "with --merge union, how ports found in more than one file of a side are combined, see combine --port-merge")
+	// a mistyped flag is a failure, not a difference
+	diffCmd.SetFlagErrorFunc(func(cmd *cobra.Command, err error) error {
+		diffFailed(err)
+		return nil
+	})
+}
+
+// diffFailed reports an error diffing, with the exit status that tells it
+// apart from scans that differ.
+func diffFailed(a ...interface{}) {
+	fmt.Fprintln(os.Stderr, append([]interface{}{"[ERROR]"}, a...)...)
+	os.Exit(2)
+}
+
+func diff(args []string) {
+	if len(args) != 2 {
+		diffFailed("a baseline and a new scan are required, quote globs so that each is a single argument")
+	}
+	switch *diffFormat {
+	case "text", "json", "xml":
+	default:
+		diffFailed("unknown --format", *diffFormat+", use text, json or xml")
+	}
+
+	// both sides share a keyer, so that with --merge-mac a host is known by
+	// the same key in both whichever of its addresses each saw first
+	keyer := newKeyer()
+	hostsA, runA := diffSide(args[0], keyer)
+	hostsB, runB := diffSide(args[1], keyer)
+	reportLists()
+	hosts := scandiff.Compare(hostsA, hostsB)
+
+	f, err := createOutput(*diffOut)
+	if err != nil {
+		diffFailed("failed to write", *diffOut+":", err)
+	}
+	w := bufio.NewWriter(f)
+	switch *diffFormat {
+	case "json":
+		err = writeDiffJSON(w, args, hosts)
+	case "xml":
+		err = scandiff.WriteNdiff(w, runA, runB, hosts)
+	default:
+		writeDiffText(w, hosts)
+	}
+	if err == nil {
+		err = w.Flush()
+	}
+	if err == nil {
+		err = f.Close()
+	}
+	if err != nil {
+		diffFailed("failed to write", outputName(*diffOut)+":", err)
+	}
+
+	if len(hosts) == 0 {
+		fmt.Fprintln(os.Stderr, "[+] No differences")
+		return
+	}
+	s := scandiff.Summarize(hosts)
+	fmt.Fprintf(os.Stderr, "[+] %d %s appeared, %d disappeared and %d changed\n", s.Appeared, plural(s.Appeared, "host", "hosts"), s.Disappeared, s.Changed)
+	os.Exit(1)
+}
+
+// diffSide loads and combines the scans of one side of a diff, keying hosts
+// with keyer. Any input that cannot be read ends the diff, as the hosts it
+// holds would show as changed.
+func diffSide(arg string, keyer *hostid.Keyer) (map[string]nmap.Host, nmap.Run) {
+	c, err := newCombiner(*diffMerge, *diffPortMerge)
+	if err != nil {
+		diffFailed(err)
+	}
+	c.keyer = keyer
+	var runs []loader.Run
+	ldr := newMergingLoader()
+	ldr.Load([]string{arg}, loader.Handler{
+		Run: func(r loader.Run) {
+			c.addRun(r)
+			runs = append(runs, r)
+		},
+		Host: func(h loader.Host) {
+			c.addHost(h)
+		},
+	})
+	reportErrors(ldr)
+	if len(ldr.Errors()) > 0 {
+		os.Exit(2)
+	}
+	if len(runs) == 0 {
+		diffFailed("no scans found in", arg)
+	}
+	run := c.result()
+	if len(runs) == 1 {
+		run.Args = runs[0].Args
+	}
+	_, hosts := c.hosts()
+	return hosts, run
+}
+
+// writeDiffText writes a diff for reading, the way ndiff does: + marks what
+// is new, - what is gone and ~ what changed.
+func writeDiffText(w io.Writer, hosts []scandiff.Host) {
+	for _, h := range hosts {
+		name := h.Key
+		if len(h.Hostnames) > 0 {
+			name += " (" + strings.Join(h.Hostnames, ", ") + ")"
+		}
+		switch h.Change {
+		case scandiff.Appeared:
+			fmt.Fprintf(w, "+%s: appeared\n", name)
+		case scandiff.Disappeared:
+			fmt.Fprintf(w, "-%s: disappeared\n", name)
+		default:
+			fmt.Fprintf(w, " %s:\n", name)
+		}
+		for _, p := range h.Ports {
+			switch p.Change {
+			case scandiff.Opened:
+				fmt.Fprintf(w, "    +%s opened %s\n", p.Name(), serviceText(p.After))
+			case scandiff.Closed:
+				line := fmt.Sprintf("    -%s closed %s", p.Name(), serviceText(p.Before))
+				if p.After != nil {
+					line += ", now " + p.After.State
+				}
+				fmt.Fprintln(w, line)
+			default:
+				fmt.Fprintf(w, "    ~%s %s -> %s\n", p.Name(), serviceText(p.Before), serviceText(p.After))
+			}
+		}
+	}
+}
+
+// serviceText describes a service the way nmap lists it, such as
+// "ssl/http nginx 1.18.0 (Ubuntu)".
+func serviceText(s *scandiff.Service) string {
+	name := s.Name
+	if name == "" {
+		name = "unknown"
+	}
+	if s.Tunnel != "" {
+		name = s.Tunnel + "/" + name
+	}
+	parts := []string{name}
+	for _, v := range []string{s.Product, s.Version} {
+		if v != "" {
+			parts = append(parts, v)
+		}
+	}
+	if s.ExtraInfo != "" {
+		parts = append(parts, "("+s.ExtraInfo+")")
+	}
+	return strings.Join(parts, " ")
+}
+
+// writeDiffJSON writes a diff as JSON.
+func writeDiffJSON(w io.Writer, args []string, hosts []scandiff.Host) error {
+	if hosts == nil {
+		hosts = []scandiff.Host{}
+	}
+	out := struct {
+		Baseline string           `json:"baseline"`
+		New      string           `json:"new"`
+		Summary  scandiff.Summary `json:"summary"`
+		Hosts    []scandiff.Host  `json:"hosts"`
+	}{args[0], args[1], scandiff.Summarize(hosts), hosts}
+	b, err := json.MarshalIndent(out, "", "  ")
+	if err != nil {
+		return err
+	}
+	_, err = w.Write(append(b, '\n'))
+	return err
+}
+
+// plural returns one or many as n calls for.
+func plural(n int, one, many string) string {
+	if n == 1 {
+		return one
+	}
+	return many
+}
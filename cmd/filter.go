@@ -0,0 +1,108 @@
+package cmd
+
+import (
+	"fmt"
+	"log"
+	"os"
+	"strings"
+
+	"github.com/redt1de/pnmap/internal/query"
+	"github.com/spf13/cobra"
+)
+
+var filterOut *string
+var filterPorts *bool
+var filterMerge *string
+var filterPortMerge *string
+
+// filterCmd represents the filter command
+var filterCmd = &cobra.Command{
+	Use:   "filter [options] <expression> <input file/s or *.xml> [more input file/s]",
+	Short: "write the hosts matching an expression to a new XML",
+	Long: `filter writes the hosts matching an expression to a new nmap XML. Inputs are
+combined first, the way combine does.
+
+  pnmap filter 'port in (80,443) and service ~ "http" and state = open and not os ~ "Windows"' *.xml
+
+An expression compares fields with values and joins the comparisons with and,
+or, not and parentheses. The comparisons are = and != (ignoring case), ~ and
+!~ (regular expressions, ignoring case), <, <=, > and >= for numbers, and
+in (a, b, ...). An ip equals a CIDR holding it. A host matches when the
+expression holds for any one of its ports, so 'port = 80 and port = 443'
+matches no host, while 'port = 80 or port = 443' matches hosts with either.
+hosts, group and urls take the same expressions with --where.
+
+Fields:
+`,
+	Run: func(cmd *cobra.Command, args []string) {
+		if err := checkPortMerge(cmd, *filterMerge); err != nil {
+			fmt.Fprintln(os.Stderr, "[ERROR]", err)
+			os.Exit(1)
+		}
+		filter(args)
+	},
+}
+
+func init() {
+	rootCmd.AddCommand(filterCmd)
+	filterCmd.Long += "  " + strings.Join(query.Fields(), "\n  ")
+	filterOut = filterCmd.Flags().StringP("out", "o", "-", "output file, - for stdout")
+	filterPorts = filterCmd.Flags().BoolP("only-matching", "P", false, "keep only the ports of each host the expression holds for")
+	filterMerge = filterCmd.Flags().StringP("merge", "m", "most-ports", "how hosts found in more than one file are combined, see combine --merge")
+	filterPortMerge = filterCmd.Flags().String("port-merge", "richest", "with --merge union, how ports found in more than one file are combined, see combine --port-merge")
+}
+
+func filter(args []string) {
+	if len(args) < 2 {
+		fmt.Fprintln(os.Stderr, "[ERROR] an expression and at least one input file are required")
+		os.Exit(1)
+	}
+	q := parseWhere(args[0])
+	c, err := newCombiner(*filterMerge, *filterPortMerge)
+	if err != nil {
+		fmt.Fprintln(os.Stderr, "[ERROR]", err)
+		os.Exit(1)
+	}
+
+	ldr := newMergingLoader()
+	ldr.Load(args[1:], c.handler())
+	reportErrors(ldr)
+
+	// as with combine, the run stats count every host scanned, not just
+	// those written
+	final := c.result()
+	reportLists()
+	final.Args = strings.Join(os.Args, " ")
+
+	f, err := createOutput(*filterOut)
+	if err != nil {
+		log.Fatal("Failed to write the file ", *filterOut+": ", err)
+	}
+	defer f.Close()
+	w := newXMLWriter(f)
+	w.Comment = "Nmap scan results, filtered by pnmap: " + q.String()
+	w.ScanInfo = c.scanInfos
+	if err := w.WriteHeader(&final); err != nil {
+		log.Fatal("Failed to write the file ", *filterOut+": ", err)
+	}
+
+	n := 0
+	keys, hosts := c.hosts()
+	for _, k := range keys {
+		hst, ok := where(q, hosts[k])
+		if !ok {
+			continue
+		}
+		if !*filterPorts {
+			hst = hosts[k]
+		}
+		if err := w.WriteHost(&hst); err != nil {
+			log.Fatal("Failed to write the file ", *filterOut+": ", err)
+		}
+		n++
+	}
+	if err := w.Close(&final); err != nil {
+		log.Fatal("Failed to write the file ", *filterOut+": ", err)
+	}
+	fmt.Fprintln(os.Stderr, "[+]", n, "of", len(hosts), "hosts matched")
+}
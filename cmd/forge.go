@@ -14,16 +14,10 @@ import (
 	"time"
 
 	nmap2 "github.com/Ullaakut/nmap/v3"
+	"github.com/redt1de/pnmap/internal/loader"
 	"github.com/spf13/cobra"
 )
 
-const fileHeader = `<?xml version="1.0" encoding="UTF-8"?>
-<!DOCTYPE nmaprun>
-<?xml-stylesheet href="file:///usr/bin/../share/nmap/nmap.xsl" type="text/xsl"?>
-<!-- Generated by Pnmap (github.com/redt1de/pnmap) -->
-
-`
-
 // forgeCmd represents the forge command
 var forgeCmd = &cobra.Command{
 	Use:   "forge",
@@ -36,41 +30,40 @@ ip,hostnames,tcp_ports,udp_ports
 	`,
 	Run: func(cmd *cobra.Command, args []string) {
 
-		var prtTrack, hostTrack []string
+		var tcpTrack, udpTrack, hostTrack []string
 		outpath, _ := cmd.Flags().GetString("out")
 		fpath, _ := cmd.Flags().GetString("in")
 		if fpath == "" {
 			log.Fatal("Please provide a file path")
 		}
 
-		csvFile, err := os.Open(fpath)
+		csvFile, err := loader.Open(fpath)
 		if err != nil {
 			log.Fatal("failed to open the CSV:", err)
 		}
+		if csvFile.Format != loader.CSV && csvFile.Format != loader.Unknown {
+			log.Fatal("failed to open the CSV: ", fpath, " looks like ", csvFile.Format)
+		}
 
 		defer csvFile.Close()
 
 		csvLines, err := csv.NewReader(csvFile).ReadAll()
 		if err != nil {
-			fmt.Println(err)
+			fmt.Fprintln(os.Stderr, err)
 		}
 		// out := nmap.NmapRun{}
+		now := time.Now()
 		out := &nmap2.Run{
 			XMLName:          xml.Name{Space: "nmaprun", Local: "nmaprun"},
 			Scanner:          "pnmap forge",
 			Args:             strings.Join(os.Args, " "),
 			Verbose:          nmap2.Verbose{Level: 0},
 			Version:          "7.91",
-			Start:            nmap2.Timestamp{},
-			StartStr:         time.Now().Format("Mon Jan 2 15:04:05 2006"),
-			XMLOutputVersion: "1.06",
-			ScanInfo:         nmap2.ScanInfo{Type: "connect", Protocol: "tcp", NumServices: 0, Services: "-"},
+			Start:            nmap2.Timestamp(now),
+			StartStr:         now.Format(time.ANSIC),
+			XMLOutputVersion: "1.05",
 			Debugging:        nmap2.Debugging{Level: 0},
-			Stats:            nmap2.Stats{Finished: nmap2.Finished{Time: nmap2.Timestamp{}, Elapsed: 1.0, TimeStr: time.Now().Format("Mon Jan 2 15:04:05 2006")}, Hosts: nmap2.HostStats{Up: 1, Down: 0, Total: 1}},
-			Targets:          []nmap2.Target{{Specification: ""}},
-			TaskBegin:        []nmap2.Task{{Time: nmap2.Timestamp{}, ExtraInfo: ""}},
-			TaskEnd:          []nmap2.Task{{Time: nmap2.Timestamp{}, ExtraInfo: ""}},
-			TaskProgress:     []nmap2.TaskProgress{{Time: nmap2.Timestamp{}, Percent: 0, Remaining: 0}},
+			Stats:            nmap2.Stats{Finished: nmap2.Finished{Time: nmap2.Timestamp(now), Elapsed: 1.0, TimeStr: now.Format(time.ANSIC), Exit: "success"}, Hosts: nmap2.HostStats{Up: 1, Down: 0, Total: 1}},
 		}
 		for _, line := range csvLines {
 			if line[0] == "ip" {
@@ -80,8 +73,18 @@ ip,hostnames,tcp_ports,udp_ports
 			ip := line[0]
 			hostnamestmp := strings.Split(line[1], ",")
 			for _, h := range hostnamestmp {
+				if h == "" {
+					continue
+				}
 				hostnames = append(hostnames, nmap2.Hostname{Name: h, Type: "PTR"})
 			}
+
+			addrType := "ipv4"
+			if !IsIPv4(ip) && IsIPv6(ip) {
+				addrType = "ipv6"
+			}
+			addr := nmap2.Address{Addr: ip, AddrType: addrType}
+
 			portsT := strings.Split(line[2], ",")
 
 			var portlistT []nmap2.Port
@@ -89,12 +92,11 @@ ip,hostnames,tcp_ports,udp_ports
 				if p == "" {
 					continue
 				}
-				prtTrack = append(prtTrack, p)
 				prtnum, _ := strconv.Atoi(p)
 				if prtnum == 0 {
 					continue
 				}
-				portlistT = append(portlistT, nmap2.Port{ID: uint16(prtnum), Protocol: "tcp", State: nmap2.State{State: "open", Reason: "syn-ack"}, Service: nmap2.Service{Name: getService(p + "/tcp")}})
+				portlistT = append(portlistT, nmap2.Port{ID: uint16(prtnum), Protocol: "tcp", State: nmap2.State{State: "open", Reason: "syn-ack"}, Service: tableService(p + "/tcp")})
 			}
 
 			portsU := strings.Split(line[3], ",")
@@ -103,76 +105,96 @@ ip,hostnames,tcp_ports,udp_ports
 				if p == "" {
 					continue
 				}
-				prtTrack = append(prtTrack, "U:"+p)
 				prtnum, _ := strconv.Atoi(p)
 				if prtnum == 0 {
 					continue
 				}
-				portlistU = append(portlistU, nmap2.Port{ID: uint16(prtnum), Protocol: "udp", State: nmap2.State{State: "open"}, Service: nmap2.Service{Name: getService(p + "/udp")}})
+				portlistU = append(portlistU, nmap2.Port{ID: uint16(prtnum), Protocol: "udp", State: nmap2.State{State: "open"}, Service: tableService(p + "/udp")})
 			}
 
-			addrType := "ipv4"
-			if !IsIPv4(ip) && IsIPv6(ip) {
-				addrType = "ipv6"
-			}
-			addr := nmap2.Address{Addr: ip, AddrType: addrType}
-
 			host := nmap2.Host{
 				Status:    nmap2.Status{State: "up", Reason: "user-set", ReasonTTL: 0},
 				Addresses: []nmap2.Address{addr},
 				Hostnames: hostnames,
 				Ports:     append(portlistT, portlistU...),
-				StartTime: nmap2.Timestamp{},
-				EndTime:   nmap2.Timestamp{},
+				StartTime: nmap2.Timestamp(now),
+				EndTime:   nmap2.Timestamp(now),
+			}
+			if !keepHost(&host) {
+				continue
+			}
+			// ports are tracked once the host is kept, as it may have been
+			// trimmed to those in scope
+			for _, p := range host.Ports {
+				if p.Protocol == "tcp" {
+					tcpTrack = append(tcpTrack, strconv.Itoa(int(p.ID)))
+				} else {
+					udpTrack = append(udpTrack, strconv.Itoa(int(p.ID)))
+				}
 			}
 			out.Hosts = append(out.Hosts, host)
 			hostTrack = append(hostTrack, ip)
 
 		}
+		reportLists()
 		uhosts := unique(hostTrack)
-		uprts := unique(prtTrack)
-		out.ScanInfo.Services = strings.Join(uprts, ",")
-		out.ScanInfo.NumServices = len(uprts)
+		// a scaninfo per protocol, the way nmap writes a TCP and a UDP scan
+		var infos []nmap2.ScanInfo
+		if prts := unique(tcpTrack); len(prts) > 0 {
+			infos = append(infos, nmap2.ScanInfo{Type: "connect", Protocol: "tcp", NumServices: len(prts), Services: strings.Join(prts, ",")})
+		}
+		if prts := unique(udpTrack); len(prts) > 0 {
+			infos = append(infos, nmap2.ScanInfo{Type: "udp", Protocol: "udp", NumServices: len(prts), Services: strings.Join(prts, ",")})
+		}
 		out.Stats.Hosts.Up = len(uhosts)
 		out.Stats.Hosts.Total = len(uhosts)
 
-		WriteXML(out, outpath)
+		if err := WriteXML(out, infos, outpath); err != nil {
+			log.Fatal("failed to write ", outputName(outpath), ": ", err)
+		}
 
 	},
 }
 
 func init() {
 	rootCmd.AddCommand(forgeCmd)
-	forgeCmd.Flags().StringP("in", "i", "", "csv file to be processed")
-	forgeCmd.Flags().StringP("out", "o", "./pnamp-forged.xml", "output file")
+	forgeCmd.Flags().StringP("in", "i", "", "csv file to be processed, - for stdin")
+	forgeCmd.Flags().StringP("out", "o", "./pnamp-forged.xml", "output file, - for stdout")
 }
 
-// takes 80/tcp, 443/tcp, 5000/udp
-func getService(prt string) string {
-	servDb, err := ReadLines("/usr/share/nmap/nmap-services")
-	if err != nil {
-		log.Println("failed to read /usr/share/nmap/nmap-services")
-	}
-	for _, line := range servDb {
-		if strings.HasPrefix(line, "#") {
-			continue
-		}
-		if strings.Contains(line, fmt.Sprintf("\t%s", prt)) {
-			return strings.Split(line, "\t")[0]
-		}
+// tableService returns the service nmap names port after, written as
+// 80/tcp, when it has not probed it.
+func tableService(port string) nmap2.Service {
+	name := loader.ServiceName(port)
+	if name == "" {
+		return nmap2.Service{}
 	}
-
-	return "tcpwrapped"
+	return nmap2.Service{Name: name, Method: "table", Confidence: 3}
 }
 
-func WriteXML(n *nmap2.Run, fpath string) error {
-
-	x, err := xml.MarshalIndent(n, "", "  ")
+// WriteXML writes a run and its hosts as nmap XML to fpath, - for stdout.
+// infos, if set, are written in place of the run's own scaninfo.
+func WriteXML(n *nmap2.Run, infos []nmap2.ScanInfo, fpath string) error {
+	f, err := createOutput(fpath)
 	if err != nil {
 		return err
 	}
-
-	out := append([]byte(fileHeader), x...)
-	os.WriteFile(fpath, out, 0644)
-	return nil
+	w := newXMLWriter(f)
+	w.Comment = "Generated by Pnmap (github.com/redt1de/pnmap)"
+	w.ScanInfo = infos
+	if err := w.WriteHeader(n); err != nil {
+		f.Close()
+		return err
+	}
+	for i := range n.Hosts {
+		if err := w.WriteHost(&n.Hosts[i]); err != nil {
+			f.Close()
+			return err
+		}
+	}
+	if err := w.Close(n); err != nil {
+		f.Close()
+		return err
+	}
+	return f.Close()
 }
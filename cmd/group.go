@@ -2,17 +2,18 @@ package cmd
 
 import (
 	"fmt"
-	"log"
 	"os"
-	"path/filepath"
 	"strconv"
 
 	nmap "github.com/Ullaakut/nmap/v3"
+	"github.com/redt1de/pnmap/internal/hostid"
+	"github.com/redt1de/pnmap/internal/loader"
 	"github.com/spf13/cobra"
 )
 
 var outpath *string
 var byport *bool
+var groupWhere *string
 var portnumMap map[int][]string
 var serviceMap map[string][]string
 
@@ -30,57 +31,87 @@ func init() {
 	rootCmd.AddCommand(groupCmd)
 	outpath = groupCmd.Flags().StringP("out-path", "o", "./out", "output directory")
 	byport = groupCmd.Flags().BoolP("portnum", "p", false, "group by port number instead of service name")
+	groupWhere = groupCmd.Flags().StringP("where", "w", "", "only group the ports matching this expression, see filter")
 }
 
 func group(args []string) {
 	if len(args) < 1 {
-		fmt.Println("[ERROR ] no input files specified")
+		fmt.Fprintln(os.Stderr, "[ERROR ] no input files specified")
 		os.Exit(1)
 	}
 	portnumMap = make(map[int][]string)
 	serviceMap = make(map[string][]string)
 
-	for _, infile := range args {
-		matches, err := filepath.Glob(infile)
-		if err != nil {
-			fmt.Println(err)
+	q := parseWhere(*groupWhere)
+	keyer := newKeyer()
+	ldr := newLoader()
+	ldr.Each(args, func(hst loader.Host) {
+		key := keyer.Key(hst.Host)
+		if key == "" {
+			skipNoIP(hst.Host)
+			return
+		}
+		if h, ok := where(q, hst.Host); ok {
+			groupHost(key, h)
 		}
-		for _, f := range matches {
+	})
+	reportErrors(ldr)
+	reportLists()
 
-			nRun := nmap.Run{}
-			err := nRun.FromFile(f)
-			if err != nil {
-				log.Fatal("Failed to parse XML:", err)
-				continue
-			}
-			for _, hst := range nRun.Hosts {
-				for _, prt := range hst.Ports {
-					serviceMap[prt.Service.Name] = append(serviceMap[prt.Service.Name], hst.Addresses[0].Addr+":"+strconv.Itoa(int(prt.ID)))
-					portnumMap[int(prt.ID)] = append(portnumMap[int(prt.ID)], hst.Addresses[0].Addr+":"+strconv.Itoa(int(prt.ID)))
-				}
-			}
+	if _, err := writeGroups(*outpath, *byport, true); err != nil {
+		fmt.Fprintln(os.Stderr, "[ERROR]", err)
+		os.Exit(1)
+	}
+}
 
-		}
+// groupHost adds every port of a host, known by key, to the groups.
+func groupHost(key string, h nmap.Host) {
+	for _, prt := range h.Ports {
+		svc := groupService(prt)
+		serviceMap[svc] = append(serviceMap[svc], hostid.HostPort(key, prt.ID))
+		portnumMap[int(prt.ID)] = append(portnumMap[int(prt.ID)], hostid.HostPort(key, prt.ID))
 	}
-	if !DirExist(*outpath) {
-		err := CreatePathAll(*outpath)
+}
+
+// groupService returns the service group a port is listed in.
+func groupService(prt nmap.Port) string {
+	// ports found by a plain port scanner such as masscan carry no service name
+	if prt.Service.Name == "" {
+		return "unknown"
+	}
+	return prt.Service.Name
+}
+
+// writeGroups writes a list of ip:port to dir for every service, or every
+// port number with byPort, creating dir if need be. With report set, the
+// number of hosts in each group is printed. It returns the files written.
+func writeGroups(dir string, byPort, report bool) ([]string, error) {
+	if !DirExist(dir) {
+		err := CreatePathAll(dir)
 		if err != nil {
-			fmt.Println("[ERROR] failed to create output directory:", *outpath)
-			os.Exit(1)
+			return nil, fmt.Errorf("failed to create output directory: %s", dir)
 		}
 	}
 
-	if *byport {
+	var written []string
+	if byPort {
 		for pnum, ips := range portnumMap {
 			i := unique(ips)
-			fmt.Println("port number", strconv.Itoa(pnum)+":", len(i), "hosts")
-			WriteLines(i, *outpath+"/"+strconv.Itoa(pnum)+".ips")
+			if report {
+				fmt.Fprintln(os.Stderr, "port number", strconv.Itoa(pnum)+":", len(i), "hosts")
+			}
+			WriteLines(i, dir+"/"+strconv.Itoa(pnum)+".ips")
+			written = append(written, dir+"/"+strconv.Itoa(pnum)+".ips")
 		}
 	} else {
 		for serv, ips := range serviceMap {
 			i := unique(ips)
-			fmt.Println("service", serv+":", len(i), "hosts")
-			WriteLines(i, *outpath+"/"+serv+".ips")
+			if report {
+				fmt.Fprintln(os.Stderr, "service", serv+":", len(i), "hosts")
+			}
+			WriteLines(i, dir+"/"+serv+".ips")
+			written = append(written, dir+"/"+serv+".ips")
 		}
 	}
+	return written, nil
 }
@@ -17,15 +17,14 @@ package cmd
 
 import (
 	"fmt"
-	"log"
 	"os"
-	"path/filepath"
 
-	nmap "github.com/Ullaakut/nmap/v3"
+	"github.com/redt1de/pnmap/internal/loader"
 	"github.com/spf13/cobra"
 )
 
 var hasports2 *bool
+var hostsWhere *string
 
 // hostsCmd represents the hosts command
 var hostsCmd = &cobra.Command{
@@ -40,41 +39,39 @@ var hostsCmd = &cobra.Command{
 func init() {
 	rootCmd.AddCommand(hostsCmd)
 	hasports2 = hostsCmd.Flags().BoolP("has-ports", "p", false, "exclude hosts with no ports open, handy for -Pn scans.")
+	hostsWhere = hostsCmd.Flags().StringP("where", "w", "", "only list hosts matching this expression, see filter")
 
 }
 
 func hosts(args []string) {
 	if len(args) < 1 {
-		fmt.Println("[ERROR ] no input files specified")
+		fmt.Fprintln(os.Stderr, "[ERROR ] no input files specified")
 		os.Exit(1)
 	}
 
 	// HasPorts = *hasports
+	q := parseWhere(*hostsWhere)
 	var out []string
-	for _, infile := range args {
-		matches, err := filepath.Glob(infile)
-		if err != nil {
-			fmt.Println(err)
+	keyer := newKeyer()
+	ldr := newLoader()
+	ldr.Each(args, func(hst loader.Host) {
+		key := keyer.Key(hst.Host)
+		if key == "" {
+			skipNoIP(hst.Host)
+			return
 		}
-		for _, f := range matches {
-
-			nRun := nmap.Run{}
-			err := nRun.FromFile(f)
-			if err != nil {
-				log.Fatal("Failed to parse XML:", err)
-				continue
-			}
-			for _, hst := range nRun.Hosts {
-				if hst.Status.State == "up" {
-					if *hasports2 && len(hst.Ports) < 1 {
-						continue
-					}
-				}
-				out = append(out, hst.Addresses[0].Addr)
+		if _, ok := where(q, hst.Host); !ok {
+			return
+		}
+		if hst.Status.State == "up" {
+			if *hasports2 && len(hst.Ports) < 1 {
+				return
 			}
-
 		}
-	}
+		out = append(out, key)
+	})
+	reportErrors(ldr)
+	reportLists()
 	for _, ip := range unique(out) {
 		fmt.Println(ip)
 	}
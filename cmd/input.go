@@ -0,0 +1,81 @@
+package cmd
+
+import (
+	"fmt"
+	"os"
+
+	nmap "github.com/Ullaakut/nmap/v3"
+	"github.com/redt1de/pnmap/internal/hostid"
+	"github.com/redt1de/pnmap/internal/loader"
+)
+
+// newLoader returns a loader for the input files given on the command line.
+func newLoader() *loader.Loader {
+	l := &loader.Loader{
+		Recover: *recoverInput,
+		Jobs:    *jobs,
+	}
+	if len(*includeFiles) > 0 || len(*excludeFiles) > 0 || *scopeFile != "" {
+		l.Keep = keepHost
+	}
+	if *verbose {
+		l.Detected = func(src string, format loader.Format) {
+			if format == loader.Unknown {
+				fmt.Fprintln(os.Stderr, "[*] skipping", src+": not a scan file")
+				return
+			}
+			fmt.Fprintln(os.Stderr, "[*]", src+":", format)
+		}
+	}
+	return l
+}
+
+// newMergingLoader returns a loader for commands that merge the records of
+// each host before looking at them. The --include and --exclude lists and the
+// scope are applied to the merged hosts instead, see combiner.hosts.
+func newMergingLoader() *loader.Loader {
+	l := newLoader()
+	l.Keep = nil
+	return l
+}
+
+// newKeyer returns the keyer that tells which records are of the same host.
+func newKeyer() *hostid.Keyer {
+	return &hostid.Keyer{MergeMAC: *mergeMAC}
+}
+
+// hostName returns how a host is referred to in messages, its IP address or,
+// lacking one, whatever address it has.
+func hostName(h nmap.Host) string {
+	if addr := hostid.Addr(h); addr != "" {
+		return addr
+	}
+	if len(h.Addresses) > 0 {
+		return h.Addresses[0].Addr
+	}
+	return "(no address)"
+}
+
+// skipNoIP reports a host that is left out for having no IP address.
+func skipNoIP(h nmap.Host) {
+	if len(h.Addresses) == 0 {
+		fmt.Fprintln(os.Stderr, "[-] Skipping host with no address")
+		return
+	}
+	fmt.Fprintln(os.Stderr, "[-] Skipping host with no IP address:", h.Addresses[0].Addr)
+}
+
+// reportErrors prints every input error collected by l, the files themselves
+// are read up to the error. Inputs salvaged in recover mode are listed too,
+// along with any hosts that were only partially written.
+func reportErrors(l *loader.Loader) {
+	for _, err := range l.Errors() {
+		fmt.Fprintln(os.Stderr, "[ERROR]", err)
+	}
+	for _, r := range l.Recovered() {
+		fmt.Fprintf(os.Stderr, "[-] recovered %d hosts from truncated %s (%v)\n", r.Hosts, r.Path, r.Err)
+		for _, h := range r.Partial {
+			fmt.Fprintln(os.Stderr, "[-] partially written host dropped:", hostName(h))
+		}
+	}
+}
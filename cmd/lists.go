@@ -0,0 +1,82 @@
+package cmd
+
+import (
+	"fmt"
+	"os"
+
+	nmap "github.com/Ullaakut/nmap/v3"
+	"github.com/redt1de/pnmap/internal/targets"
+)
+
+// includeList and excludeList hold the lists given with --include and
+// --exclude, nil if none were.
+var includeList, excludeList *targets.List
+var listsLoaded bool
+
+// loadLists loads the lists given with --include and --exclude, once.
+func loadLists() {
+	if listsLoaded {
+		return
+	}
+	listsLoaded = true
+	includeList = loadList("include", *includeFiles)
+	excludeList = loadList("exclude", *excludeFiles)
+}
+
+// loadList loads the files of a list into one, nil if there are none.
+func loadList(name string, files []string) *targets.List {
+	if len(files) == 0 {
+		return nil
+	}
+	list := &targets.List{}
+	for _, f := range files {
+		l, err := targets.Load(f)
+		if err != nil {
+			fmt.Fprintln(os.Stderr, "[ERROR] failed to read the "+name+" list:", err)
+			os.Exit(failStatus)
+		}
+		list.Entries = append(list.Entries, l.Entries...)
+	}
+	return list
+}
+
+// listsGiven reports whether --include or --exclude was given.
+func listsGiven() bool {
+	return len(*includeFiles) > 0 || len(*excludeFiles) > 0
+}
+
+// keepHost reports whether a host is kept, being on the lists and in scope,
+// and trims it to what is in scope.
+func keepHost(h *nmap.Host) bool {
+	return onLists(*h) && inScope(h)
+}
+
+// onLists reports whether a host is on the --include list, if there is one,
+// and not on the --exclude list.
+func onLists(h nmap.Host) bool {
+	loadLists()
+	// both lists are always matched against, so that entries matching
+	// hosts that are dropped by the other still count as used
+	in := includeList == nil || includeList.Match(h)
+	out := excludeList != nil && excludeList.Match(h)
+	return in && !out
+}
+
+// reportLists reports on the --include, --exclude and --scope lists once the
+// inputs are read: the entries that matched no host, as they are likely
+// mistyped, and what was left out as out of scope.
+func reportLists() {
+	reportUnused("include list", includeList)
+	reportUnused("exclude list", excludeList)
+	reportScope()
+}
+
+// reportUnused prints the entries of a list that matched no host.
+func reportUnused(name string, l *targets.List) {
+	if l == nil {
+		return
+	}
+	for _, e := range l.Unmatched() {
+		fmt.Fprintln(os.Stderr, "[-]", name, "entry matched no host:", e)
+	}
+}
@@ -0,0 +1,61 @@
+package cmd
+
+import (
+	"fmt"
+	"os"
+	"strings"
+
+	"github.com/redt1de/pnmap/internal/provenance"
+	"github.com/spf13/cobra"
+)
+
+// provenanceCmd represents the provenance command
+var provenanceCmd = &cobra.Command{
+	Use:   "provenance <manifest> <ip[:port[/proto]]> [more ip:port]",
+	Short: "show which input files and scans a host or port in a combined XML came from",
+	Long: `provenance looks hosts and ports up in the manifest written by combine --provenance,
+and lists the input files they were taken from along with the scan each file holds.`,
+	Args: cobra.MinimumNArgs(2),
+	Run: func(cmd *cobra.Command, args []string) {
+		showProvenance(args)
+	},
+}
+
+func init() {
+	rootCmd.AddCommand(provenanceCmd)
+}
+
+func showProvenance(args []string) {
+	m, err := provenance.Load(args[0])
+	if err != nil {
+		fmt.Fprintln(os.Stderr, "[ERROR]", err)
+		os.Exit(1)
+	}
+
+	failed := false
+	for _, q := range args[1:] {
+		matches, err := m.Lookup(q)
+		if err != nil {
+			fmt.Fprintln(os.Stderr, "[ERROR]", err)
+			failed = true
+			continue
+		}
+		for _, match := range matches {
+			if match.Port == "" {
+				fmt.Println(match.Host)
+			} else {
+				addr := match.Host
+				if strings.Contains(addr, ":") {
+					addr = "[" + addr + "]"
+				}
+				fmt.Println(addr + ":" + match.Port)
+			}
+			for _, src := range match.Sources {
+				fmt.Println("   ", src+":", m.Runs[src])
+			}
+		}
+	}
+	if failed {
+		os.Exit(1)
+	}
+}
@@ -1,10 +1,28 @@
 package cmd
 
 import (
+	"os"
+	"runtime"
+
+	"github.com/redt1de/pnmap/internal/nmapxml"
 	"github.com/spf13/cobra"
 )
 
 var cfgFile string
+var recoverInput *bool
+var jobs *int
+var verbose *bool
+var mergeMAC *bool
+var stylesheet *string
+var docType *string
+var includeFiles *[]string
+var excludeFiles *[]string
+var scopeFile *string
+var scopeMode *string
+
+// failStatus is the exit status of a command that fails part way through,
+// diff raises it to tell a failure apart from scans that differ.
+var failStatus = 1
 
 // rootCmd represents the base command when called without any subcommands
 var rootCmd = &cobra.Command{
@@ -35,6 +53,16 @@ func init() {
 	// will be global for your application.
 
 	// rootCmd.PersistentFlags().StringVar(&cfgFile, "config", "", "config file (default is $HOME/.pmap.yaml)")
+	jobs = rootCmd.PersistentFlags().IntP("jobs", "j", runtime.NumCPU(), "number of input files to parse concurrently")
+	verbose = rootCmd.PersistentFlags().BoolP("verbose", "v", false, "verbose output, including the format detected for every input file")
+	recoverInput = rootCmd.PersistentFlags().Bool("recover", false, "salvage the complete hosts of truncated or interrupted XML files")
+	mergeMAC = rootCmd.PersistentFlags().Bool("merge-mac", false, "treat IPv4 and IPv6 records that share a MAC address as one host")
+	stylesheet = rootCmd.PersistentFlags().String("stylesheet", nmapxml.DefaultStylesheet, "stylesheet referenced by XML output, empty to leave it out")
+	docType = rootCmd.PersistentFlags().String("doctype", nmapxml.DefaultDocType, "document type declared by XML output, empty to leave it out")
+	includeFiles = rootCmd.PersistentFlags().StringArray("include", nil, "only process hosts on this list of IPs, CIDRs, nmap ranges and hostnames, may be given more than once")
+	excludeFiles = rootCmd.PersistentFlags().StringArray("exclude", nil, "leave out hosts on this list of IPs, CIDRs, nmap ranges and hostnames, may be given more than once")
+	scopeFile = rootCmd.PersistentFlags().String("scope", os.Getenv("PNMAP_SCOPE"), "scope file of the engagement, defaults to $PNMAP_SCOPE, see pnmap scope --help")
+	scopeMode = rootCmd.PersistentFlags().String("scope-mode", "drop", "what is done with hosts, hostnames and ports out of --scope: drop, or flag to keep them and warn")
 
 	// Cobra also supports local flags, which will only run
 	// when this action is called directly.
@@ -0,0 +1,240 @@
+package cmd
+
+import (
+	"fmt"
+	"os"
+	"strings"
+
+	nmap "github.com/Ullaakut/nmap/v3"
+	"github.com/redt1de/pnmap/internal/loader"
+	"github.com/redt1de/pnmap/internal/scope"
+	"github.com/spf13/cobra"
+)
+
+// scopeCmd represents the scope command
+var scopeCmd = &cobra.Command{
+	Use:   "scope",
+	Short: "check scan results against the scope of an engagement",
+	Long: `The scope of an engagement is given with --scope, or $PNMAP_SCOPE, and applies
+to every command. A scope file lists one rule per line, with comments starting
+at #:
+
+  10.0.0.0/24                 # in scope
+  10.1.0.1-50   T:80,443      # in scope, but only these ports
+  *.example.com               # hostnames below example.com
+  !10.0.0.1                   # excluded
+  !vpn.example.com
+
+Targets are written the way nmap takes them: addresses, CIDRs, ranges such as
+10.1.0.1-50 or 10.1.0.1-10.1.0.50, and hostnames. A rule starting with ! is
+an exclusion. Ports are written the way nmap's -p takes them, ports before any
+T:, U: or S: are of every protocol.
+
+A host is in scope if a rule matches its address or a hostname it was scanned
+as that is in scope, and no exclusion matches its address. Reverse DNS names
+never bring a host into scope. When the scope lists
+hostnames, the hostnames it does not match are out of scope. Hosts matched by
+rules limiting ports have only those ports in scope.
+
+With --scope-mode drop, the default, what is out of scope is left out of the
+output of every command. With --scope-mode flag it is kept, and a warning is
+printed for each.`,
+	Run: func(cmd *cobra.Command, args []string) {
+		cmd.Help()
+	},
+}
+
+// scopeCheckCmd represents the scope check command
+var scopeCheckCmd = &cobra.Command{
+	Use:   "check [options] <input file/s or *.xml> [more input file/s]",
+	Short: "list every host, hostname and port of the inputs that is out of scope",
+	Long: `check lists every host, hostname and open port of the inputs that is out of
+--scope, along with the input it was found in. It exits with status 1 if
+anything is out of scope.`,
+	Run: func(cmd *cobra.Command, args []string) {
+		scopeCheck(args)
+	},
+}
+
+func init() {
+	rootCmd.AddCommand(scopeCmd)
+	scopeCmd.AddCommand(scopeCheckCmd)
+}
+
+// engagement holds the scope given with --scope, nil if none was.
+var engagement *scope.Scope
+var scopeLoaded bool
+
+// scopeSeen holds what was found out of scope so far, each is only reported
+// once. scopeCounts counts them by kind.
+var scopeSeen = make(map[string]bool)
+var scopeCounts = make(map[string]int)
+
+// loadScope loads the scope given with --scope, once.
+func loadScope() *scope.Scope {
+	if scopeLoaded {
+		return engagement
+	}
+	scopeLoaded = true
+	if *scopeMode != "drop" && *scopeMode != "flag" {
+		fmt.Fprintln(os.Stderr, "[ERROR] unknown --scope-mode", *scopeMode+", use drop or flag")
+		os.Exit(failStatus)
+	}
+	if *scopeFile == "" {
+		return nil
+	}
+	s, err := scope.Load(*scopeFile)
+	if err != nil {
+		fmt.Fprintln(os.Stderr, "[ERROR] failed to read the scope:", err)
+		os.Exit(failStatus)
+	}
+	engagement = s
+	return s
+}
+
+// inScope reports whether a host is in scope. With --scope-mode drop it trims
+// the hostnames and ports out of scope from the host, with flag it keeps the
+// host as is and warns about them.
+func inScope(h *nmap.Host) bool {
+	s := loadScope()
+	if s == nil {
+		return true
+	}
+	v := s.Check(*h)
+	if v.Clean() {
+		return true
+	}
+	for _, f := range scopeFindings(*h, v) {
+		if scopeSeen[f.text] {
+			continue
+		}
+		scopeSeen[f.text] = true
+		scopeCounts[f.kind]++
+		if *scopeMode == "flag" {
+			fmt.Fprintln(os.Stderr, "[-] out of scope:", f.text)
+		}
+	}
+	if *scopeMode == "flag" {
+		return true
+	}
+	if !v.In {
+		return false
+	}
+	*h = scope.Trim(*h, v)
+	return true
+}
+
+// reportScope prints how much was left out as out of scope.
+func reportScope() {
+	if *scopeMode != "drop" || len(scopeSeen) == 0 {
+		return
+	}
+	fmt.Fprintf(os.Stderr, "[-] Left out %s out of scope\n", scopeSummary())
+}
+
+// scopeSummary counts what was found out of scope, such as "2 hosts and 1 port".
+func scopeSummary() string {
+	var parts []string
+	for _, kind := range []string{"host", "hostname", "port"} {
+		n := scopeCounts[kind]
+		if n == 0 {
+			continue
+		}
+		if n > 1 {
+			parts = append(parts, fmt.Sprint(n, " ", kind, "s"))
+		} else {
+			parts = append(parts, fmt.Sprint(n, " ", kind))
+		}
+	}
+	if len(parts) > 1 {
+		return strings.Join(parts[:len(parts)-1], ", ") + " and " + parts[len(parts)-1]
+	}
+	return strings.Join(parts, "")
+}
+
+// scopeFinding is a host, hostname or port found out of scope.
+type scopeFinding struct {
+	kind string
+	text string
+}
+
+// scopeFindings lists what of a host is out of scope. The hostnames and ports
+// of a host that is out of scope are not listed on their own, and only open
+// ports are listed.
+func scopeFindings(h nmap.Host, v scope.Verdict) []scopeFinding {
+	name := hostName(h)
+	if !v.In {
+		label := name
+		if names := hostnames(h); len(names) > 0 {
+			label += " (" + strings.Join(names, ", ") + ")"
+		}
+		return []scopeFinding{{"host", "host " + label + ": " + v.Why}}
+	}
+	var out []scopeFinding
+	for _, n := range v.Hostnames {
+		out = append(out, scopeFinding{"hostname", "hostname " + n + " of " + name})
+	}
+	for _, p := range v.Ports {
+		if !strings.HasPrefix(p.State.State, "open") {
+			continue
+		}
+		out = append(out, scopeFinding{"port", fmt.Sprintf("port %d/%s of %s", p.ID, p.Protocol, name)})
+	}
+	return out
+}
+
+// hostnames returns the hostnames of a host.
+func hostnames(h nmap.Host) []string {
+	var names []string
+	for _, hn := range h.Hostnames {
+		if hn.Name != "" {
+			names = append(names, hn.Name)
+		}
+	}
+	return unique(names)
+}
+
+func scopeCheck(args []string) {
+	if len(args) == 0 {
+		fmt.Fprintln(os.Stderr, "[ERROR] at least one input file is required")
+		os.Exit(1)
+	}
+	s := loadScope()
+	if s == nil {
+		fmt.Fprintln(os.Stderr, "[ERROR] no scope given, use --scope or set $PNMAP_SCOPE")
+		os.Exit(1)
+	}
+
+	// the scope is checked here rather than applied by the loader, the
+	// include and exclude lists still are
+	ldr := newLoader()
+	ldr.Keep = func(h *nmap.Host) bool {
+		return onLists(*h)
+	}
+	ldr.Load(args, loader.Handler{
+		Host: func(h loader.Host) {
+			v := s.Check(h.Host)
+			if v.Clean() {
+				return
+			}
+			for _, f := range scopeFindings(h.Host, v) {
+				if scopeSeen[f.text] {
+					continue
+				}
+				scopeSeen[f.text] = true
+				scopeCounts[f.kind]++
+				fmt.Println(f.text, "("+h.Source+")")
+			}
+		},
+	})
+	reportErrors(ldr)
+	reportUnused("include list", includeList)
+	reportUnused("exclude list", excludeList)
+
+	if len(scopeSeen) == 0 {
+		fmt.Fprintln(os.Stderr, "[+] Everything is in scope")
+		return
+	}
+	fmt.Fprintf(os.Stderr, "[-] Found %s out of scope\n", scopeSummary())
+	os.Exit(1)
+}
@@ -0,0 +1,537 @@
+package cmd
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"log"
+	"net"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	nmap "github.com/Ullaakut/nmap/v3"
+	"github.com/redt1de/pnmap/internal/hostid"
+	"github.com/redt1de/pnmap/internal/loader"
+	"github.com/redt1de/pnmap/internal/merge"
+	"github.com/redt1de/pnmap/internal/targets"
+	"github.com/spf13/cobra"
+)
+
+var setPorts *bool
+var setXML *bool
+var setOut *string
+var setMerge *string
+var setPortMerge *string
+
+// setCmd represents the set command
+var setCmd = &cobra.Command{
+	Use:   "set [options] <operation> <set> <set> [more sets]",
+	Short: "union, intersection and difference of the hosts or ports of scans and lists",
+	Long: `set lists the hosts, or with --ports the open ports, that are in a union,
+intersection or difference of sets.
+
+  pnmap set difference new.xml old.xml        # hosts in the new scan only
+  pnmap set intersection external/ internal/  # hosts seen from both sides
+  pnmap hosts -p a.xml | pnmap set union - 'b-*.xml'
+
+Every argument after the operation is one set: a scan file, a directory or a
+quoted glob of scan files, or - for stdin. Files that are not scans are read
+as lists of IP addresses, one per line, the way hosts writes them, or of
+ip:port and ip:port/proto, the way set --ports writes them. Bare ports are
+tcp. Only hosts that are up and ports that are open are members of a set. set
+stops if any input cannot be read, as a set missing some of its members would
+give a wrong result.
+
+Operations:
+  union                  members of any set
+  intersection           members of every set
+  difference             members of the first set and none of the others
+  symmetric-difference   members of exactly one set
+
+With --xml the hosts are written as nmap XML, combined the way combine does,
+and with --ports trimmed to the ports in the result. Hosts only found in lists
+are written with what the list tells of them.`,
+	Run: func(cmd *cobra.Command, args []string) {
+		if err := checkPortMerge(cmd, *setMerge); err != nil {
+			fmt.Fprintln(os.Stderr, "[ERROR]", err)
+			os.Exit(1)
+		}
+		setAlgebra(args)
+	},
+}
+
+func init() {
+	rootCmd.AddCommand(setCmd)
+	setPorts = setCmd.Flags().BoolP("ports", "p", false, "compare open ports, as ip:port/proto, rather than hosts")
+	setXML = setCmd.Flags().BoolP("xml", "x", false, "write the result as nmap XML rather than a list")
+	setOut = setCmd.Flags().StringP("out", "o", "-", "output file, - for stdout")
+	setMerge = setCmd.Flags().StringP("merge", "m", "most-ports", "with --xml, how hosts found in more than one file are combined, see combine --merge")
+	setPortMerge = setCmd.Flags().String("port-merge", "richest", "with --merge union, how ports found in more than one file are combined, see combine --port-merge")
+}
+
+// setOperations maps the names of the operations, and their short forms, to
+// whether an element found in n of total sets, among them the first if first
+// is set, is in the result.
+var setOperations = map[string]func(n, total int, first bool) bool{
+	"union": func(n, total int, first bool) bool {
+		return n > 0
+	},
+	"intersection": func(n, total int, first bool) bool {
+		return n == total
+	},
+	"difference": func(n, total int, first bool) bool {
+		return first && n == 1
+	},
+	"symmetric-difference": func(n, total int, first bool) bool {
+		return n == 1
+	},
+}
+
+var setAliases = map[string]string{
+	"or":      "union",
+	"and":     "intersection",
+	"inter":   "intersection",
+	"diff":    "difference",
+	"minus":   "difference",
+	"symdiff": "symmetric-difference",
+	"xor":     "symmetric-difference",
+}
+
+// setElem is a member of a set, a host or one of its ports.
+type setElem struct {
+	ip    string
+	port  uint16
+	proto string
+}
+
+func (e setElem) String() string {
+	if e.proto == "" {
+		return e.ip
+	}
+	return hostid.HostPort(e.ip, e.port) + "/" + e.proto
+}
+
+// setState collects the members of every set, in the order they were first
+// seen.
+type setState struct {
+	order   []setElem
+	members map[setElem][]int
+
+	// addrs maps every address a scanned host was seen with to the ip of
+	// its members, and keys maps that ip to the key the host is merged by.
+	addrs map[string]string
+	keys  map[string]string
+}
+
+// scanned records a scan record of the host merged by key, and returns the
+// ip of its members.
+func (s *setState) scanned(key string, h nmap.Host) string {
+	ip := setIP(key)
+	s.keys[ip] = key
+	for _, a := range targets.IPs(h) {
+		if addr := a.String(); s.addrs[addr] == "" {
+			s.addrs[addr] = ip
+		}
+	}
+	return ip
+}
+
+// add makes e a member of set i.
+func (s *setState) add(e setElem, i int) {
+	sets, seen := s.members[e]
+	if !seen {
+		s.order = append(s.order, e)
+	}
+	if len(sets) == 0 || sets[len(sets)-1] != i {
+		s.members[e] = append(sets, i)
+	}
+}
+
+// resolve makes members found in lists by an address of a scanned host
+// members of that host, which may be known by another address, such as the
+// IPv4 address of a host also seen by its IPv6 address with --merge-mac.
+func (s *setState) resolve() {
+	order := s.order[:0]
+	for _, e := range s.order {
+		ip, ok := s.addrs[e.ip]
+		if !ok || ip == e.ip {
+			order = append(order, e)
+			continue
+		}
+		in := s.members[e]
+		delete(s.members, e)
+		e.ip = ip
+		had, seen := s.members[e]
+		if !seen {
+			order = append(order, e)
+		}
+		s.members[e] = unionSets(had, in)
+	}
+	s.order = order
+}
+
+// unionSets returns the sets of both a and b, in order.
+func unionSets(a, b []int) []int {
+	all := append(append([]int(nil), a...), b...)
+	sort.Ints(all)
+	out := all[:0]
+	for i, n := range all {
+		if i == 0 || n != all[i-1] {
+			out = append(out, n)
+		}
+	}
+	return out
+}
+
+// keep drops the members that are not on the --include and --exclude lists,
+// or out of scope. Members of a scanned host are judged by the host merged
+// from all of its records, see combiner.hosts, members only found in lists
+// by what the list tells of them.
+func (s *setState) keep(c *combiner) {
+	if !listsGiven() && *scopeFile == "" {
+		return
+	}
+	_, kept := c.hosts()
+	order := s.order[:0]
+	for _, e := range s.order {
+		key, found := s.keys[e.ip]
+		h, ok := kept[key]
+		if !found {
+			h = setHost(e)
+			ok = keepHost(&h)
+		} else if ok && e.proto != "" {
+			// the port is judged on its own, as it may only be in a list
+			h.Ports = setHost(e).Ports
+			ok = inScope(&h)
+		}
+		if ok && e.proto != "" && len(h.Ports) == 0 {
+			// the port is out of scope
+			ok = false
+		}
+		if ok {
+			order = append(order, e)
+		} else {
+			delete(s.members, e)
+		}
+	}
+	s.order = order
+}
+
+func setAlgebra(args []string) {
+	if len(args) < 3 {
+		fmt.Fprintln(os.Stderr, "[ERROR] an operation and at least two sets are required")
+		os.Exit(1)
+	}
+	opName := strings.ToLower(args[0])
+	if alias, ok := setAliases[opName]; ok {
+		opName = alias
+	}
+	op, ok := setOperations[opName]
+	if !ok {
+		fmt.Fprintln(os.Stderr, "[ERROR] unknown operation", args[0]+", use union, intersection, difference or symmetric-difference")
+		os.Exit(1)
+	}
+	c, err := newCombiner(*setMerge, *setPortMerge)
+	if err != nil {
+		fmt.Fprintln(os.Stderr, "[ERROR]", err)
+		os.Exit(1)
+	}
+
+	state := &setState{
+		members: make(map[setElem][]int),
+		addrs:   make(map[string]string),
+		keys:    make(map[string]string),
+	}
+	sets := args[1:]
+	stdin := false
+	failed := false
+	for i, arg := range sets {
+		if arg == loader.Stdin {
+			if stdin {
+				fmt.Fprintln(os.Stderr, "[ERROR] stdin can only be one of the sets")
+				os.Exit(1)
+			}
+			stdin = true
+		}
+		if !loadSet(arg, i, c, state) {
+			failed = true
+		}
+	}
+	state.resolve()
+	state.keep(c)
+	reportLists()
+	if failed {
+		os.Exit(1)
+	}
+
+	var result []setElem
+	for _, e := range state.order {
+		in := state.members[e]
+		if op(len(in), len(sets), in[0] == 0) {
+			result = append(result, e)
+		}
+	}
+
+	what := "hosts"
+	if *setPorts {
+		what = "ports"
+	}
+	if *setXML {
+		writeSetXML(result, c, state, opName, sets)
+	} else {
+		f, err := createOutput(*setOut)
+		if err != nil {
+			log.Fatal("Failed to write the file ", *setOut+": ", err)
+		}
+		w := bufio.NewWriter(f)
+		for _, e := range result {
+			fmt.Fprintln(w, e)
+		}
+		if err := w.Flush(); err != nil {
+			log.Fatal("Failed to write the file ", *setOut+": ", err)
+		}
+		f.Close()
+	}
+	fmt.Fprintf(os.Stderr, "[+] %d of %d %s are in the %s\n", len(result), len(state.order), what, opName)
+}
+
+// loadSet reads the members of set i from arg, a scan file, a list, a
+// directory or glob of them, or stdin. It reports whether every input was
+// read.
+func loadSet(arg string, i int, c *combiner, state *setState) bool {
+	ldr := newMergingLoader()
+	handler := loader.Handler{
+		Run: c.addRun,
+		Host: func(h loader.Host) {
+			key := c.addHost(h)
+			if key == "" {
+				return
+			}
+			ip := state.scanned(key, h.Host)
+			if h.Status.State != "up" {
+				return
+			}
+			if !*setPorts {
+				state.add(setElem{ip: ip}, i)
+				return
+			}
+			for _, p := range h.Ports {
+				if p.State.State == "open" {
+					state.add(setElem{ip: ip, port: p.ID, proto: p.Protocol}, i)
+				}
+			}
+		},
+	}
+
+	paths, errs := loader.Expand([]string{arg})
+	ok := len(errs) == 0
+	for _, err := range errs {
+		fmt.Fprintln(os.Stderr, "[ERROR]", err)
+	}
+	var scans []string
+	for _, pth := range paths {
+		in, err := loader.Open(pth)
+		if err != nil {
+			fmt.Fprintln(os.Stderr, "[ERROR]", pth+":", err)
+			ok = false
+			continue
+		}
+		switch {
+		case in.Format != loader.Unknown && pth == loader.Stdin:
+			// stdin cannot be opened again by the loader
+			ldr.LoadInput(in, pth, handler)
+		case in.Format != loader.Unknown:
+			scans = append(scans, pth)
+		case namedInput(arg, pth):
+			if err := readSetList(in, pth, i, state); err != nil {
+				fmt.Fprintln(os.Stderr, "[ERROR]", err)
+				ok = false
+			}
+		case *verbose:
+			fmt.Fprintln(os.Stderr, "[*] skipping", pth+": not a scan file")
+		}
+		in.Close()
+	}
+	ldr.Load(scans, handler)
+	reportErrors(ldr)
+	return ok && len(ldr.Errors()) == 0
+}
+
+// namedInput reports whether pth was named by arg itself, or matched by it as
+// a glob, rather than found by searching a directory.
+func namedInput(arg, pth string) bool {
+	if arg == loader.Stdin {
+		return pth == loader.Stdin
+	}
+	if filepath.Clean(arg) == pth {
+		return true
+	}
+	matched, _ := filepath.Match(arg, pth)
+	return matched
+}
+
+// readSetList reads the members of set i from a list of IP addresses or
+// ip:port, one per line.
+func readSetList(r io.Reader, name string, i int, state *setState) error {
+	scanner := bufio.NewScanner(r)
+	n := 0
+	for scanner.Scan() {
+		n++
+		line := scanner.Text()
+		if j := strings.IndexByte(line, '#'); j >= 0 {
+			line = line[:j]
+		}
+		line = strings.TrimSpace(line)
+		if line == "" {
+			continue
+		}
+		e, err := parseSetElem(line)
+		if err != nil {
+			return fmt.Errorf("%s:%d: %v", name, n, err)
+		}
+		if !*setPorts {
+			e.port, e.proto = 0, ""
+		} else if e.proto == "" {
+			return fmt.Errorf("%s:%d: %q has no port, --ports compares ip:port", name, n, line)
+		}
+		state.add(e, i)
+	}
+	return scanner.Err()
+}
+
+// parseSetElem parses an IP address, ip:port or ip:port/proto, IPv6
+// addresses with a port being in brackets.
+func parseSetElem(s string) (setElem, error) {
+	var e setElem
+	addr := s
+	if rest, proto, ok := strings.Cut(s, "/"); ok {
+		addr, e.proto = rest, strings.ToLower(proto)
+		if e.proto != "tcp" && e.proto != "udp" && e.proto != "sctp" {
+			return e, fmt.Errorf("unknown protocol %q in %q", proto, s)
+		}
+	}
+	if strings.HasPrefix(addr, "[") || strings.Count(addr, ":") == 1 {
+		host, port, err := net.SplitHostPort(addr)
+		if err != nil {
+			return e, err
+		}
+		id, err := strconv.Atoi(port)
+		if err != nil || id < 1 || id > 65535 {
+			return e, fmt.Errorf("bad port %q in %q", port, s)
+		}
+		addr, e.port = host, uint16(id)
+		if e.proto == "" {
+			e.proto = "tcp"
+		}
+	} else if e.proto != "" {
+		return e, fmt.Errorf("%q has a protocol but no port", s)
+	}
+	if net.ParseIP(addr) == nil {
+		return e, fmt.Errorf("%q is not an IP address", addr)
+	}
+	e.ip = setIP(addr)
+	return e, nil
+}
+
+// setIP returns an address the way members are listed, so that an address
+// is the same member however a scan or a list writes it.
+func setIP(addr string) string {
+	if ip := net.ParseIP(addr); ip != nil {
+		return ip.String()
+	}
+	return addr
+}
+
+// setHost returns the host a list tells of: its address and the port, if
+// any, open.
+func setHost(e setElem) nmap.Host {
+	addrType := "ipv4"
+	if IsIPv6(e.ip) {
+		addrType = "ipv6"
+	}
+	h := nmap.Host{
+		Status:    nmap.Status{State: "up", Reason: "user-set"},
+		Addresses: []nmap.Address{{Addr: e.ip, AddrType: addrType}},
+	}
+	if e.proto != "" {
+		h.Ports = []nmap.Port{{ID: e.port, Protocol: e.proto, State: nmap.State{State: "open", Reason: "user-set"}}}
+	}
+	return h
+}
+
+// writeSetXML writes the hosts of the result as nmap XML.
+func writeSetXML(result []setElem, c *combiner, state *setState, opName string, sets []string) {
+	_, hosts := c.hosts()
+	var order []string
+	ports := make(map[string]map[string]bool)
+	for _, e := range result {
+		if _, ok := ports[e.ip]; !ok {
+			order = append(order, e.ip)
+			ports[e.ip] = make(map[string]bool)
+		}
+		if e.proto != "" {
+			ports[e.ip][strconv.Itoa(int(e.port))+"/"+e.proto] = true
+		}
+	}
+
+	final := c.result()
+	if final.Scanner == "" {
+		// every set was a list
+		now := time.Now()
+		final.Scanner = "pnmap set"
+		final.XMLOutputVersion = "1.05"
+		final.Start = nmap.Timestamp(now)
+		final.StartStr = now.Format(time.ANSIC)
+		final.Stats.Finished.Time = nmap.Timestamp(now)
+		final.Stats.Finished.TimeStr = now.Format(time.ANSIC)
+	}
+	final.Args = strings.Join(os.Args, " ")
+	// unlike filter, the stats count the hosts written, as a set is a new
+	// list of targets rather than a view of the scans
+	final.Stats.Hosts = nmap.HostStats{Up: len(order), Total: len(order)}
+
+	f, err := createOutput(*setOut)
+	if err != nil {
+		log.Fatal("Failed to write the file ", *setOut+": ", err)
+	}
+	defer f.Close()
+	w := newXMLWriter(f)
+	w.Comment = fmt.Sprintf("Nmap scan results, the %s of %s by pnmap", opName, strings.Join(sets, ", "))
+	w.ScanInfo = c.scanInfos
+	if err := w.WriteHeader(&final); err != nil {
+		log.Fatal("Failed to write the file ", *setOut+": ", err)
+	}
+	for _, ip := range order {
+		hst, ok := hosts[state.keys[ip]]
+		if !ok {
+			hst = setHost(setElem{ip: ip})
+		}
+		if *setPorts {
+			var kept []nmap.Port
+			for _, p := range hst.Ports {
+				if ports[ip][merge.PortKey(p)] {
+					kept = append(kept, p)
+					delete(ports[ip], merge.PortKey(p))
+				}
+			}
+			// ports only found in lists
+			for pk := range ports[ip] {
+				id, proto, _ := strings.Cut(pk, "/")
+				n, _ := strconv.Atoi(id)
+				kept = append(kept, setHost(setElem{ip: ip, port: uint16(n), proto: proto}).Ports...)
+			}
+			merge.SortPorts(kept)
+			hst.Ports = kept
+		}
+		if err := w.WriteHost(&hst); err != nil {
+			log.Fatal("Failed to write the file ", *setOut+": ", err)
+		}
+	}
+	if err := w.Close(&final); err != nil {
+		log.Fatal("Failed to write the file ", *setOut+": ", err)
+	}
+}
@@ -0,0 +1,67 @@
+package cmd
+
+import (
+	"fmt"
+	"strings"
+	"testing"
+
+	nmap "github.com/Ullaakut/nmap/v3"
+)
+
+// TestSetResolve makes members read from lists members of the scanned host
+// with their address, however either writes it.
+func TestSetResolve(t *testing.T) {
+	state := &setState{
+		members: make(map[setElem][]int),
+		addrs:   make(map[string]string),
+		keys:    make(map[string]string),
+	}
+	// a host merged from an IPv4 and an IPv6 record sharing a MAC address
+	v4 := nmap.Host{Addresses: []nmap.Address{{Addr: "10.0.0.1", AddrType: "ipv4"}, {Addr: "00:50:56:AA:BB:CC", AddrType: "mac"}}}
+	v6 := nmap.Host{Addresses: []nmap.Address{{Addr: "2001:DB8:0::0001", AddrType: "ipv6"}, {Addr: "00:50:56:AA:BB:CC", AddrType: "mac"}}}
+	state.add(setElem{ip: state.scanned("10.0.0.1", v4)}, 0)
+	state.add(setElem{ip: state.scanned("10.0.0.1", v6)}, 0)
+	for _, line := range []string{"2001:0db8::1", "10.0.0.9", "2001:db8::2"} {
+		e, err := parseSetElem(line)
+		if err != nil {
+			t.Fatal(err)
+		}
+		state.add(e, 1)
+	}
+	// a host only seen by its IPv6 address
+	other := nmap.Host{Addresses: []nmap.Address{{Addr: "2001:DB8::2", AddrType: "ipv6"}}}
+	state.add(setElem{ip: state.scanned("2001:DB8::2", other)}, 2)
+	state.resolve()
+
+	var got []string
+	for _, e := range state.order {
+		got = append(got, fmt.Sprint(e.ip, state.members[e]))
+	}
+	want := "[10.0.0.1[0 1] 10.0.0.9[1] 2001:db8::2[1 2]]"
+	if fmt.Sprint(got) != want {
+		t.Errorf("members %v, want %s", got, want)
+	}
+	if key := state.keys["2001:db8::2"]; key != "2001:DB8::2" {
+		t.Errorf("2001:db8::2 is merged by %q", key)
+	}
+}
+
+// TestSetHostsList reads the output of pnmap hosts -p piped into set, as in
+// the example of set --help. It is a list of hosts, which --ports rejects.
+func TestSetHostsList(t *testing.T) {
+	in := "10.0.0.1\n2001:DB8::1\n"
+	state := &setState{members: make(map[setElem][]int)}
+	if err := readSetList(strings.NewReader(in), "-", 0, state); err != nil {
+		t.Fatal(err)
+	}
+	if got := fmt.Sprint(state.order); got != "[10.0.0.1 2001:db8::1]" {
+		t.Errorf("members %s", got)
+	}
+
+	*setPorts = true
+	defer func() { *setPorts = false }()
+	err := readSetList(strings.NewReader(in), "-", 0, &setState{members: make(map[setElem][]int)})
+	if err == nil || err.Error() != `-:1: "10.0.0.1" has no port, --ports compares ip:port` {
+		t.Errorf("got error %v with --ports", err)
+	}
+}
@@ -0,0 +1,309 @@
+package cmd
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"log"
+	"net"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+
+	nmap "github.com/Ullaakut/nmap/v3"
+	"github.com/redt1de/pnmap/internal/loader"
+	"github.com/redt1de/pnmap/internal/nmapxml"
+	"github.com/spf13/cobra"
+)
+
+var splitOut *string
+var splitPrefix *string
+var splitHosts *int
+var splitSubnet *int
+var splitSubnet6 *int
+var splitService *bool
+var splitPerHost *bool
+
+// splitCmd represents the split command
+var splitCmd = &cobra.Command{
+	Use:   "split [options] <input file>",
+	Short: "split an nmap XML file into smaller files",
+	Long: `split divides an nmap XML file into smaller files, by host count, by subnet,
+by service or one file per host. Every file is a complete nmap XML of its own,
+with the run metadata of the original and stats counting the hosts it holds.`,
+	Run: func(cmd *cobra.Command, args []string) {
+		split(args)
+	},
+}
+
+func init() {
+	rootCmd.AddCommand(splitCmd)
+	splitOut = splitCmd.Flags().StringP("out-path", "o", "./split", "output directory")
+	splitPrefix = splitCmd.Flags().String("prefix", "", "file name prefix, defaults to the name of the input file")
+	splitHosts = splitCmd.Flags().IntP("hosts", "n", 0, "write this many hosts per file")
+	splitSubnet = splitCmd.Flags().Int("subnet", 0, "write one file per IPv4 subnet of this prefix length, such as 24")
+	splitSubnet6 = splitCmd.Flags().Int("subnet6", 64, "with --subnet, the prefix length IPv6 hosts are split by")
+	splitService = splitCmd.Flags().BoolP("service", "s", false, "write one file per open service, holding every host that runs it")
+	splitPerHost = splitCmd.Flags().Bool("per-host", false, "write one file per host")
+}
+
+// splitFile collects the hosts of a single output file. The run metadata
+// the file starts with is only known once the whole input has been read, so
+// hosts are spooled to a temporary file as they are encoded, and the file
+// keeps where its hosts are in the spool.
+type splitFile struct {
+	name     string
+	hosts    []span
+	up, down int
+}
+
+// span is a run of bytes in the spool.
+type span struct {
+	off, n int64
+}
+
+// add records that the next host of the file is at s, extending the last
+// span if the host follows right on from it.
+func (sf *splitFile) add(s span) {
+	if n := len(sf.hosts); n > 0 && sf.hosts[n-1].off+sf.hosts[n-1].n == s.off {
+		sf.hosts[n-1].n += s.n
+		return
+	}
+	sf.hosts = append(sf.hosts, s)
+}
+
+func split(args []string) {
+	if len(args) != 1 {
+		fmt.Fprintln(os.Stderr, "[ERROR] split takes a single input file")
+		os.Exit(1)
+	}
+	modes := 0
+	for _, set := range []bool{*splitHosts > 0, *splitSubnet > 0, *splitService, *splitPerHost} {
+		if set {
+			modes++
+		}
+	}
+	if modes != 1 {
+		fmt.Fprintln(os.Stderr, "[ERROR] give exactly one of --hosts, --subnet, --service or --per-host")
+		os.Exit(1)
+	}
+	if *splitSubnet > 32 || *splitSubnet6 < 0 || *splitSubnet6 > 128 {
+		fmt.Fprintln(os.Stderr, "[ERROR] subnet prefix lengths must be within 0-32 for IPv4 and 0-128 for IPv6")
+		os.Exit(1)
+	}
+
+	prefix := *splitPrefix
+	if prefix == "" {
+		prefix = splitName(args[0])
+	}
+
+	if !DirExist(*splitOut) {
+		if err := CreatePathAll(*splitOut); err != nil {
+			fmt.Fprintln(os.Stderr, "[ERROR] failed to create output directory:", *splitOut)
+			os.Exit(1)
+		}
+	}
+
+	// hosts are encoded through a single writer and appended to the spool,
+	// which is kept beside the output as it grows as large as the input
+	spool, err := os.CreateTemp(*splitOut, tempPrefix+"split-*")
+	if err != nil {
+		fmt.Fprintln(os.Stderr, "[ERROR] failed to create a spool file:", err)
+		os.Exit(1)
+	}
+	defer os.Remove(spool.Name())
+	defer spool.Close()
+	var scratch bytes.Buffer
+	var spooled int64
+	enc := nmapxml.NewWriter(&scratch)
+
+	files := make(map[string]*splitFile)
+	var order []string
+	var runs []loader.Run
+	nhosts := 0
+
+	keyer := newKeyer()
+	ldr := newLoader()
+	ldr.Load(args, loader.Handler{
+		Run: func(r loader.Run) {
+			runs = append(runs, r)
+		},
+		Host: func(h loader.Host) {
+			key := keyer.Key(h.Host)
+			if key == "" {
+				skipNoIP(h.Host)
+				return
+			}
+			scratch.Reset()
+			if err := enc.WriteHost(&h.Host); err != nil {
+				log.Fatal("failed to encode host ", key, ": ", err)
+			}
+			if err := enc.Flush(); err != nil {
+				log.Fatal("failed to encode host ", key, ": ", err)
+			}
+			if _, err := spool.Write(scratch.Bytes()); err != nil {
+				os.Remove(spool.Name())
+				log.Fatal("failed to spool host ", key, ": ", err)
+			}
+			at := span{off: spooled, n: int64(scratch.Len())}
+			spooled += at.n
+			for _, b := range splitBuckets(h.Host, key, nhosts) {
+				sf, ok := files[b]
+				if !ok {
+					sf = &splitFile{name: b}
+					files[b] = sf
+					order = append(order, b)
+				}
+				sf.add(at)
+				if h.Status.State == "up" {
+					sf.up++
+				} else {
+					sf.down++
+				}
+			}
+			nhosts++
+		},
+	})
+	reportErrors(ldr)
+	reportLists()
+
+	if len(runs) != 1 {
+		os.Remove(spool.Name())
+		if len(runs) == 0 {
+			fmt.Fprintln(os.Stderr, "[ERROR] nothing to split")
+		} else {
+			fmt.Fprintln(os.Stderr, "[ERROR]", args[0], "holds", len(runs), "scans, combine them first")
+		}
+		os.Exit(1)
+	}
+	run := runs[0]
+
+	// host count chunks are numbered, padded so the files sort in order
+	width := len(strconv.Itoa(len(order)))
+	for i, b := range order {
+		sf := files[b]
+		name := prefix + "-" + sf.name + ".xml"
+		if *splitHosts > 0 {
+			name = fmt.Sprintf("%s-%0*d.xml", prefix, width, i+1)
+		}
+		pth := filepath.Join(*splitOut, name)
+		if err := writeSplit(pth, &run, sf, spool); err != nil {
+			os.Remove(spool.Name())
+			log.Fatal("failed to write ", pth, ": ", err)
+		}
+		fmt.Fprintln(os.Stderr, "[+] Wrote", sf.up+sf.down, "hosts to", pth)
+	}
+	fmt.Fprintln(os.Stderr, "[+] Split", nhosts, "hosts into", len(order), "files in", *splitOut)
+}
+
+// splitBuckets returns the names of the files a host goes into. Only with
+// --service may a host go into more than one.
+func splitBuckets(h nmap.Host, key string, n int) []string {
+	switch {
+	case *splitHosts > 0:
+		return []string{strconv.Itoa(n / *splitHosts)}
+	case *splitSubnet > 0:
+		ip := net.ParseIP(key)
+		mask := net.CIDRMask(*splitSubnet, 32)
+		bits := *splitSubnet
+		if ip.To4() == nil {
+			mask = net.CIDRMask(*splitSubnet6, 128)
+			bits = *splitSubnet6
+		} else {
+			ip = ip.To4()
+		}
+		return []string{fileSafe(ip.Mask(mask).String() + "/" + strconv.Itoa(bits))}
+	case *splitService:
+		var svcs []string
+		for _, p := range h.Ports {
+			if p.State.State != "open" {
+				continue
+			}
+			svc := p.Service.Name
+			if svc == "" {
+				svc = "unknown"
+			}
+			svcs = append(svcs, fileSafe(svc))
+		}
+		if len(svcs) == 0 {
+			return []string{"none"}
+		}
+		return unique(svcs)
+	default:
+		return []string{fileSafe(key)}
+	}
+}
+
+// writeSplit writes a single output file: the header of the original run,
+// the hosts of the file copied out of the spool, and stats counting just
+// those hosts.
+func writeSplit(pth string, r *loader.Run, sf *splitFile, spool io.ReaderAt) error {
+	f, err := os.Create(pth)
+	if err != nil {
+		return err
+	}
+	run := r.Run
+	run.Stats.Hosts = nmap.HostStats{Up: sf.up, Down: sf.down, Total: sf.up + sf.down}
+	if strings.HasPrefix(run.Stats.Finished.Summary, "Nmap done") {
+		run.Stats.Finished.Summary = nmapSummary(run.Stats.Finished, sf.up, sf.up+sf.down)
+	}
+
+	w := newXMLWriter(f)
+	w.ScanInfo = r.ScanInfos
+	if run.Scanner == "nmap" {
+		w.Comment = fmt.Sprintf("Nmap %s scan initiated %s as: %s", run.Version, run.StartStr, run.Args)
+	}
+	if err := w.WriteHeader(&run); err != nil {
+		f.Close()
+		return err
+	}
+	if err := w.Flush(); err != nil {
+		f.Close()
+		return err
+	}
+	for _, s := range sf.hosts {
+		if _, err := io.Copy(f, io.NewSectionReader(spool, s.off, s.n)); err != nil {
+			f.Close()
+			return err
+		}
+	}
+	if err := w.Close(&run); err != nil {
+		f.Close()
+		return err
+	}
+	return f.Close()
+}
+
+// nmapSummary returns the runstats summary nmap would have written for a
+// scan of total hosts, up of which were up.
+func nmapSummary(f nmap.Finished, up, total int) string {
+	addrs := "IP addresses"
+	if total == 1 {
+		addrs = "IP address"
+	}
+	hosts := "hosts"
+	if up == 1 {
+		hosts = "host"
+	}
+	return fmt.Sprintf("Nmap done at %s; %d %s (%d %s up) scanned in %.2f seconds", f.TimeStr, total, addrs, up, hosts, f.Elapsed)
+}
+
+// splitName returns the name of an input file without its directory and
+// extensions, used to name the files it is split into.
+func splitName(pth string) string {
+	if pth == loader.Stdin {
+		return "nmap"
+	}
+	name := filepath.Base(pth)
+	if i := strings.Index(name, "."); i > 0 {
+		name = name[:i]
+	}
+	return name
+}
+
+// fileSafe replaces the characters that are not allowed in file names on
+// some systems, such as the colons of IPv6 addresses.
+func fileSafe(s string) string {
+	return strings.NewReplacer("/", "_", ":", "-", "\\", "_", "*", "_", "?", "_", "\"", "_", "<", "_", ">", "_", "|", "_").Replace(s)
+}
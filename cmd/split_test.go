@@ -0,0 +1,76 @@
+package cmd
+
+import (
+	"fmt"
+	"testing"
+
+	nmap "github.com/Ullaakut/nmap/v3"
+)
+
+// TestSplitBuckets picks the files hosts are split into, for each way of
+// splitting.
+func TestSplitBuckets(t *testing.T) {
+	port := func(id uint16, state, svc string) nmap.Port {
+		return nmap.Port{ID: id, Protocol: "tcp", State: nmap.State{State: state}, Service: nmap.Service{Name: svc}}
+	}
+	hosts := []struct {
+		key   string
+		ports []nmap.Port
+	}{
+		{"10.0.0.5", []nmap.Port{port(22, "open", "ssh"), port(80, "open", "http"), port(8080, "open", "http")}},
+		{"10.0.0.200", []nmap.Port{port(22, "filtered", "ssh")}},
+		{"10.0.1.7", []nmap.Port{port(9999, "open", "")}},
+		{"2001:db8::5", []nmap.Port{port(443, "open", "ssl/http")}},
+		{"2001:db8:0:1::5", nil},
+	}
+	tests := []struct {
+		name                   string
+		hosts, subnet, subnet6 int
+		service, perHost       bool
+		want                   string
+	}{
+		{name: "two per file", hosts: 2, want: "[[0] [0] [1] [1] [2]]"},
+		{name: "subnet 24", subnet: 24, subnet6: 64, want: "[[10.0.0.0_24] [10.0.0.0_24] [10.0.1.0_24] [2001-db8--_64] [2001-db8-0-1--_64]]"},
+		{name: "subnet 16 and 32", subnet: 16, subnet6: 32, want: "[[10.0.0.0_16] [10.0.0.0_16] [10.0.0.0_16] [2001-db8--_32] [2001-db8--_32]]"},
+		// a host goes into the file of every service it has open
+		{name: "service", service: true, want: "[[ssh http] [none] [unknown] [ssl_http] [none]]"},
+		{name: "per host", perHost: true, want: "[[10.0.0.5] [10.0.0.200] [10.0.1.7] [2001-db8--5] [2001-db8-0-1--5]]"},
+	}
+	defer func(n, s, s6 int, svc, per bool) {
+		*splitHosts, *splitSubnet, *splitSubnet6, *splitService, *splitPerHost = n, s, s6, svc, per
+	}(*splitHosts, *splitSubnet, *splitSubnet6, *splitService, *splitPerHost)
+	for _, tt := range tests {
+		*splitHosts, *splitSubnet, *splitSubnet6, *splitService, *splitPerHost = tt.hosts, tt.subnet, tt.subnet6, tt.service, tt.perHost
+		var got [][]string
+		for i, h := range hosts {
+			got = append(got, splitBuckets(nmap.Host{Ports: h.ports}, h.key, i))
+		}
+		if fmt.Sprint(got) != tt.want {
+			t.Errorf("%s:\n got: %v\nwant: %s", tt.name, got, tt.want)
+		}
+	}
+}
+
+func TestSplitName(t *testing.T) {
+	tests := map[string]string{
+		"scans/web.xml":    "web",
+		"scans/web.xml.gz": "web",
+		".hidden.xml":      ".hidden.xml",
+		"-":                "nmap",
+	}
+	for pth, want := range tests {
+		if got := splitName(pth); got != want {
+			t.Errorf("splitName(%q) = %q, want %q", pth, got, want)
+		}
+	}
+}
+
+func TestNmapSummary(t *testing.T) {
+	f := nmap.Finished{TimeStr: "Mon Oct  2 10:00:30 2023", Elapsed: 30.12}
+	if got := nmapSummary(f, 1, 1); got != "Nmap done at Mon Oct  2 10:00:30 2023; 1 IP address (1 host up) scanned in 30.12 seconds" {
+		t.Errorf("one host: %s", got)
+	}
+	if got := nmapSummary(f, 0, 16); got != "Nmap done at Mon Oct  2 10:00:30 2023; 16 IP addresses (0 hosts up) scanned in 30.12 seconds" {
+		t.Errorf("16 hosts: %s", got)
+	}
+}
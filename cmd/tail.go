@@ -0,0 +1,213 @@
+package cmd
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"strconv"
+	"time"
+
+	nmap "github.com/Ullaakut/nmap/v3"
+	"github.com/redt1de/pnmap/internal/hostid"
+	"github.com/redt1de/pnmap/internal/loader"
+	"github.com/redt1de/pnmap/internal/nmapxml"
+	"github.com/spf13/cobra"
+)
+
+var tailType *string
+var tailHasPorts *bool
+var tailGroups *string
+var tailPortnum *bool
+var tailInterval *time.Duration
+var tailIdle *time.Duration
+
+// tailCmd represents the tail command
+var tailCmd = &cobra.Command{
+	Use:   "tail [options] <nmap -oX file or - for stdin>",
+	Short: "follow the XML of a running nmap scan and list hosts, ports or urls as they are found",
+	Long: `tail follows the XML output of an nmap scan that is still running, either a file
+given to -oX or nmap's own output with -oX - piped in, and lists every host as
+soon as nmap has written it, until the scan finishes. Group lists can be kept
+up to date alongside, see group.
+
+  nmap -sV -oX - 10.0.0.0/24 | pnmap tail -t urls -`,
+	Run: func(cmd *cobra.Command, args []string) {
+		tail(args)
+	},
+}
+
+func init() {
+	rootCmd.AddCommand(tailCmd)
+	tailType = tailCmd.Flags().StringP("type", "t", "hosts", "what to list: hosts, the IP of every host that is up, ports, every open ip:port, or urls, see urls")
+	tailHasPorts = tailCmd.Flags().BoolP("has-ports", "p", false, "with --type hosts, leave out hosts with no ports open")
+	tailGroups = tailCmd.Flags().StringP("out-path", "o", "", "also keep lists of ip:port grouped by service in this directory, see group")
+	tailPortnum = tailCmd.Flags().Bool("portnum", false, "group by port number instead of service name")
+	tailInterval = tailCmd.Flags().DurationP("interval", "i", time.Second, "how often a file is checked for more output")
+	tailIdle = tailCmd.Flags().Duration("idle", 0, "give up on a file that has not grown for this long, such as when nmap was killed, 0 to wait for the scan to finish")
+}
+
+func tail(args []string) {
+	if len(args) != 1 {
+		fmt.Fprintln(os.Stderr, "[ERROR] tail takes a single file, or - for stdin")
+		os.Exit(1)
+	}
+	switch *tailType {
+	case "hosts", "ports", "urls":
+	default:
+		fmt.Fprintln(os.Stderr, "[ERROR] unknown --type", *tailType+", use one of hosts, ports, urls")
+		os.Exit(1)
+	}
+	if *tailGroups != "" {
+		portnumMap = make(map[int][]string)
+		serviceMap = make(map[string][]string)
+		if !DirExist(*tailGroups) {
+			if err := CreatePathAll(*tailGroups); err != nil {
+				fmt.Fprintln(os.Stderr, "[ERROR] failed to create output directory:", *tailGroups)
+				os.Exit(1)
+			}
+		}
+	}
+
+	name := "stdin"
+	var in io.Reader = os.Stdin
+	if args[0] != loader.Stdin {
+		name = args[0]
+		f := waitForFile(args[0])
+		defer f.Close()
+		in = &followReader{f: f, interval: *tailInterval, idle: *tailIdle}
+	}
+
+	dec := nmapxml.NewDecoder(in)
+	dec.Recover = *recoverInput
+	keyer := newKeyer()
+	seen := make(map[string]bool)
+	up := 0
+	for {
+		h, err := dec.Next()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			fmt.Fprintln(os.Stderr, "[ERROR]", name+":", err)
+			os.Exit(1)
+		}
+		if !keepHost(&h) {
+			continue
+		}
+		key := keyer.Key(h)
+		if key == "" {
+			skipNoIP(h)
+			continue
+		}
+		if h.Status.State == "up" {
+			up++
+		}
+		for _, line := range tailLines(key, h) {
+			if !seen[line] {
+				seen[line] = true
+				fmt.Println(line)
+			}
+		}
+		if *tailGroups != "" {
+			groupHost(key, h)
+			if err := writeHostGroups(*tailGroups, *tailPortnum, h); err != nil {
+				fmt.Fprintln(os.Stderr, "[ERROR]", err)
+				os.Exit(1)
+			}
+		}
+	}
+	if dec.Truncated != nil {
+		fmt.Fprintln(os.Stderr, "[-] scan output ended early:", dec.Truncated)
+	}
+	reportLists()
+	fmt.Fprintln(os.Stderr, "[+] scan finished,", up, "hosts up")
+}
+
+// tailLines returns what tail lists for a host known by key.
+func tailLines(key string, h nmap.Host) []string {
+	switch *tailType {
+	case "ports":
+		var out []string
+		for _, p := range h.Ports {
+			if p.State.State == "open" {
+				out = append(out, hostid.HostPort(key, p.ID))
+			}
+		}
+		return out
+	case "urls":
+		return hostURLs(key, h, nil)
+	default:
+		if h.Status.State != "up" || (*tailHasPorts && !hasOpenPorts(h)) {
+			return nil
+		}
+		return []string{key}
+	}
+}
+
+// writeHostGroups rewrites the group lists in dir that a host was just added
+// to, leaving the rest as they are.
+func writeHostGroups(dir string, byPort bool, h nmap.Host) error {
+	written := make(map[string]bool)
+	for _, prt := range h.Ports {
+		name := groupService(prt)
+		ips := serviceMap[name]
+		if byPort {
+			name = strconv.Itoa(int(prt.ID))
+			ips = portnumMap[int(prt.ID)]
+		}
+		if written[name] {
+			continue
+		}
+		written[name] = true
+		if err := WriteLines(unique(ips), dir+"/"+name+".ips"); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// waitForFile opens pth, waiting for it to be created if nmap has not got
+// that far yet.
+func waitForFile(pth string) *os.File {
+	waiting := false
+	for {
+		f, err := os.Open(pth)
+		if err == nil {
+			return f
+		}
+		if !errors.Is(err, os.ErrNotExist) {
+			fmt.Fprintln(os.Stderr, "[ERROR]", err)
+			os.Exit(1)
+		}
+		if !waiting {
+			fmt.Fprintln(os.Stderr, "[*] waiting for", pth, "to be created")
+			waiting = true
+		}
+		time.Sleep(*tailInterval)
+	}
+}
+
+// followReader reads a file that is still being written. At the end of the
+// file it waits for more to be written rather than returning io.EOF, unless
+// the file has not grown for idle, if set.
+type followReader struct {
+	f        *os.File
+	interval time.Duration
+	idle     time.Duration
+}
+
+func (r *followReader) Read(p []byte) (int, error) {
+	var waited time.Duration
+	for {
+		n, err := r.f.Read(p)
+		if n > 0 || err != io.EOF {
+			return n, err
+		}
+		if r.idle > 0 && waited >= r.idle {
+			return 0, io.EOF
+		}
+		time.Sleep(r.interval)
+		waited += r.interval
+	}
+}
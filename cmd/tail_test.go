@@ -0,0 +1,135 @@
+package cmd
+
+import (
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	nmap "github.com/Ullaakut/nmap/v3"
+)
+
+func TestTailLines(t *testing.T) {
+	web := nmap.Host{
+		Addresses: []nmap.Address{{Addr: "10.0.0.5", AddrType: "ipv4"}},
+		Status:    nmap.Status{State: "up"},
+		Ports: []nmap.Port{
+			{ID: 22, Protocol: "tcp", State: nmap.State{State: "filtered"}, Service: nmap.Service{Name: "ssh"}},
+			{ID: 80, Protocol: "tcp", State: nmap.State{State: "open"}, Service: nmap.Service{Name: "http"}},
+		},
+	}
+	bare := nmap.Host{
+		Addresses: []nmap.Address{{Addr: "2001:db8::7", AddrType: "ipv6"}},
+		Status:    nmap.Status{State: "up"},
+	}
+	down := nmap.Host{
+		Addresses: []nmap.Address{{Addr: "10.0.0.9", AddrType: "ipv4"}},
+		Status:    nmap.Status{State: "down"},
+	}
+	tests := []struct {
+		typ      string
+		hasPorts bool
+		host     nmap.Host
+		want     string
+	}{
+		{"hosts", false, web, "[10.0.0.5]"},
+		{"hosts", false, bare, "[2001:db8::7]"},
+		{"hosts", true, bare, "[]"},
+		{"hosts", false, down, "[]"},
+		{"ports", false, web, "[10.0.0.5:80]"},
+		{"ports", false, bare, "[]"},
+		{"urls", false, web, "[http://10.0.0.5:80]"},
+	}
+	defer func(typ string, hasPorts bool) { *tailType, *tailHasPorts = typ, hasPorts }(*tailType, *tailHasPorts)
+	for _, tt := range tests {
+		*tailType, *tailHasPorts = tt.typ, tt.hasPorts
+		key := tt.host.Addresses[0].Addr
+		if got := fmt.Sprint(tailLines(key, tt.host)); got != tt.want {
+			t.Errorf("--type %s --has-ports=%v %s: %s, want %s", tt.typ, tt.hasPorts, key, got, tt.want)
+		}
+	}
+}
+
+// TestWriteHostGroups keeps the group lists up to date as hosts are read.
+func TestWriteHostGroups(t *testing.T) {
+	host := func(ip string, ports ...nmap.Port) nmap.Host {
+		return nmap.Host{Addresses: []nmap.Address{{Addr: ip, AddrType: "ipv4"}}, Status: nmap.Status{State: "up"}, Ports: ports}
+	}
+	open := func(id uint16, svc string) nmap.Port {
+		return nmap.Port{ID: id, Protocol: "tcp", State: nmap.State{State: "open"}, Service: nmap.Service{Name: svc}}
+	}
+	for _, byPort := range []bool{false, true} {
+		dir := t.TempDir()
+		portnumMap = make(map[int][]string)
+		serviceMap = make(map[string][]string)
+		for _, h := range []nmap.Host{
+			host("10.0.0.5", open(22, "ssh"), open(80, "http")),
+			host("10.0.0.6", open(8080, "http"), open(9999, "")),
+		} {
+			groupHost(h.Addresses[0].Addr, h)
+			if err := writeHostGroups(dir, byPort, h); err != nil {
+				t.Fatal(err)
+			}
+		}
+
+		want := map[string]string{
+			"ssh.ips":     "10.0.0.5:22\n",
+			"http.ips":    "10.0.0.5:80\n10.0.0.6:8080\n",
+			"unknown.ips": "10.0.0.6:9999\n",
+		}
+		if byPort {
+			want = map[string]string{
+				"22.ips":   "10.0.0.5:22\n",
+				"80.ips":   "10.0.0.5:80\n",
+				"8080.ips": "10.0.0.6:8080\n",
+				"9999.ips": "10.0.0.6:9999\n",
+			}
+		}
+		files, _ := filepath.Glob(filepath.Join(dir, "*"))
+		if len(files) != len(want) {
+			t.Errorf("byPort %v: wrote %v", byPort, files)
+		}
+		for name, content := range want {
+			b, err := os.ReadFile(filepath.Join(dir, name))
+			if err != nil {
+				t.Errorf("byPort %v: %v", byPort, err)
+				continue
+			}
+			if string(b) != content {
+				t.Errorf("byPort %v: %s holds %q, want %q", byPort, name, b, content)
+			}
+		}
+	}
+}
+
+// TestFollowReader reads what is written to a file after its end was
+// reached, until it stays idle.
+func TestFollowReader(t *testing.T) {
+	pth := filepath.Join(t.TempDir(), "scan.xml")
+	writeFile(t, pth, "<nmaprun>")
+	f, err := os.Open(pth)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+	r := &followReader{f: f, interval: time.Millisecond, idle: 50 * time.Millisecond}
+
+	done := make(chan []byte)
+	go func() {
+		b, _ := io.ReadAll(r)
+		done <- b
+	}()
+	time.Sleep(10 * time.Millisecond)
+	w, err := os.OpenFile(pth, os.O_APPEND|os.O_WRONLY, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	w.WriteString("</nmaprun>")
+	w.Close()
+
+	if got := string(<-done); got != "<nmaprun></nmaprun>" {
+		t.Errorf("read %q", got)
+	}
+}
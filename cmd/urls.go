@@ -8,15 +8,17 @@ import (
 	"fmt"
 	"log"
 	"os"
-	"path/filepath"
 	"strconv"
 	"strings"
 
 	nmap "github.com/Ullaakut/nmap/v3"
+	"github.com/redt1de/pnmap/internal/hostid"
+	"github.com/redt1de/pnmap/internal/loader"
 	"github.com/spf13/cobra"
 )
 
 var extraDNS *string
+var urlsWhere *string
 
 // urlsCmd represents the urls command
 var urlsCmd = &cobra.Command{
@@ -31,75 +33,83 @@ var urlsCmd = &cobra.Command{
 func init() {
 	rootCmd.AddCommand(urlsCmd)
 	extraDNS = urlsCmd.Flags().StringP("dns", "d", "", "specify a file containing DNS info in the format IP:domain1,domain2")
+	urlsWhere = urlsCmd.Flags().StringP("where", "w", "", "only list URLs of the ports matching this expression, see filter")
 }
 
 func urls(args []string) {
 
 	if len(args) < 1 {
-		fmt.Println("[ERROR ] no input files specified")
+		fmt.Fprintln(os.Stderr, "[ERROR ] no input files specified")
 		os.Exit(1)
 	}
 
-	var out []string
-	for _, infile := range args {
-		matches, err := filepath.Glob(infile)
+	var dns []string
+	if *extraDNS != "" {
+		lst, err := ReadLines(*extraDNS)
 		if err != nil {
-			fmt.Println(err)
+			log.Fatal(err)
 		}
-		for _, f := range matches {
-
-			nRun := nmap.Run{}
-			err := nRun.FromFile(f)
-			if err != nil {
-				log.Fatal("Failed to parse XML:", err)
-				continue
-			}
-			for _, hst := range nRun.Hosts {
-				for _, p := range hst.Ports {
-					test, _ := json.Marshal(p.Service)
-					chk := strings.ToLower(string(test))
-					if strings.Contains(chk, "http") || strings.Contains(chk, "tls") {
-						scheme := "http://"
-						if strings.Contains(chk, "https") || strings.Contains(chk, "ssl") || strings.Contains(chk, "tls") || strings.Contains(chk, "tls") {
-							scheme = "https://"
-						}
-						out = append(out, scheme+hst.Addresses[0].Addr+":"+strconv.Itoa(int(p.ID)))
+		dns = lst
+	}
 
-						for _, hn := range hst.Hostnames {
-							out = append(out, scheme+hn.Name+":"+strconv.Itoa(int(p.ID)))
-						}
+	q := parseWhere(*urlsWhere)
+	var out []string
+	keyer := newKeyer()
+	ldr := newLoader()
+	ldr.Each(args, func(hst loader.Host) {
+		key := keyer.Key(hst.Host)
+		if key == "" {
+			skipNoIP(hst.Host)
+			return
+		}
+		if h, ok := where(q, hst.Host); ok {
+			out = append(out, hostURLs(key, h, dns)...)
+		}
+	})
+	reportErrors(ldr)
+	reportLists()
+	for _, ip := range unique(out) {
+		fmt.Println(ip)
+	}
 
-						if *extraDNS != "" {
-							lst, err := ReadLines(*extraDNS)
-							if err != nil {
-								log.Fatal(err)
-							}
-							for _, l := range lst {
-								tmp := strings.Split(l, ":")
-								if len(tmp) < 2 {
-									continue
-								}
-								ip := tmp[0]
-								if ip != hst.Addresses[0].Addr {
-									continue
-								}
-								doms := tmp[1]
-								for _, dom := range strings.Split(doms, ",") {
-									out = append(out, scheme+dom+":"+strconv.Itoa(int(p.ID)))
-								}
+}
 
-							}
+// hostURLs returns the URLs of the web servers of a host known by key, by
+// address and by each of its hostnames. dns holds extra hostnames, as lines
+// of IP:domain1,domain2.
+func hostURLs(key string, hst nmap.Host, dns []string) []string {
+	var out []string
+	for _, p := range hst.Ports {
+		test, _ := json.Marshal(p.Service)
+		chk := strings.ToLower(string(test))
+		if strings.Contains(chk, "http") || strings.Contains(chk, "tls") {
+			scheme := "http://"
+			if strings.Contains(chk, "https") || strings.Contains(chk, "ssl") || strings.Contains(chk, "tls") || strings.Contains(chk, "tls") {
+				scheme = "https://"
+			}
+			out = append(out, scheme+hostid.HostPort(key, p.ID))
 
-						}
+			for _, hn := range hst.Hostnames {
+				out = append(out, scheme+hn.Name+":"+strconv.Itoa(int(p.ID)))
+			}
 
-					}
+			for _, l := range dns {
+				tmp := strings.Split(l, ":")
+				if len(tmp) < 2 {
+					continue
+				}
+				ip := tmp[0]
+				if ip != key {
+					continue
+				}
+				doms := tmp[1]
+				for _, dom := range strings.Split(doms, ",") {
+					out = append(out, scheme+dom+":"+strconv.Itoa(int(p.ID)))
 				}
+
 			}
 
 		}
 	}
-	for _, ip := range unique(out) {
-		fmt.Println(ip)
-	}
-
+	return out
 }
@@ -3,8 +3,11 @@ package cmd
 import (
 	"bufio"
 	"fmt"
+	"io"
 	"os"
 	"strings"
+
+	"github.com/redt1de/pnmap/internal/nmapxml"
 )
 
 func DirExist(pth string) bool {
@@ -86,3 +89,46 @@ func IsIPv4(address string) bool {
 func IsIPv6(address string) bool {
 	return strings.Count(address, ":") >= 2
 }
+
+// createOutput creates the output file at pth, or returns stdout if pth is "-".
+func createOutput(pth string) (io.WriteCloser, error) {
+	if pth == "-" {
+		return nopCloser{os.Stdout}, nil
+	}
+	return os.Create(pth)
+}
+
+// outputName returns a printable name for an output path.
+func outputName(pth string) string {
+	if pth == "-" {
+		return "stdout"
+	}
+	return pth
+}
+
+// newXMLWriter returns an nmap XML writer using the stylesheet and document
+// type given on the command line.
+func newXMLWriter(w io.Writer) *nmapxml.Writer {
+	x := nmapxml.NewWriter(w)
+	x.Stylesheet = *stylesheet
+	x.DocType = *docType
+	return x
+}
+
+type nopCloser struct {
+	io.Writer
+}
+
+func (nopCloser) Close() error { return nil }
+
+// countingWriter counts the bytes written through it.
+type countingWriter struct {
+	w io.Writer
+	n int64
+}
+
+func (c *countingWriter) Write(p []byte) (int, error) {
+	n, err := c.w.Write(p)
+	c.n += int64(n)
+	return n, err
+}
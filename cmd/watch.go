@@ -0,0 +1,401 @@
+package cmd
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	nmap "github.com/Ullaakut/nmap/v3"
+	"github.com/redt1de/pnmap/internal/hostid"
+	"github.com/redt1de/pnmap/internal/loader"
+	"github.com/spf13/cobra"
+)
+
+var watchOut *string
+var watchGroups *string
+var watchPortnum *bool
+var watchURLs *string
+var watchInterval *time.Duration
+var watchMerge *string
+var watchPortMerge *string
+
+// watchCmd represents the watch command
+var watchCmd = &cobra.Command{
+	Use:   "watch [options] <directory>",
+	Short: "keep a combined XML, group lists and urls up to date as scans land in a directory",
+	Long: `watch polls a directory for new or modified scan files and merges them into a
+combined XML as they land, the way combine does. The group lists and url list
+are rewritten along with it, and a line is printed for every host and open port
+not seen before. XML files still being written are picked up once nmap has
+written </nmaprun>, other formats once they stop changing.`,
+	Run: func(cmd *cobra.Command, args []string) {
+		if err := checkPortMerge(cmd, *watchMerge); err != nil {
+			fmt.Fprintln(os.Stderr, "[ERROR]", err)
+			os.Exit(1)
+		}
+		watch(args)
+	},
+}
+
+func init() {
+	rootCmd.AddCommand(watchCmd)
+	watchOut = watchCmd.Flags().StringP("out", "o", "nmap-combined.xml", "combined output file")
+	watchGroups = watchCmd.Flags().StringP("out-path", "g", "", "also keep lists of ip:port grouped by service in this directory, see group")
+	watchPortnum = watchCmd.Flags().BoolP("portnum", "p", false, "group by port number instead of service name")
+	watchURLs = watchCmd.Flags().StringP("urls", "u", "", "also keep a list of URLs in this file, see urls")
+	watchInterval = watchCmd.Flags().DurationP("interval", "i", 5*time.Second, "how often the directory is checked")
+	watchMerge = watchCmd.Flags().StringP("merge", "m", "most-ports", "how hosts found in more than one file are combined, see combine --merge")
+	watchPortMerge = watchCmd.Flags().String("port-merge", "richest", "with --merge union, how ports found in more than one file are combined, see combine --port-merge")
+}
+
+// fileState is what a file looked like when it was last checked.
+type fileState struct {
+	size int64
+	mod  time.Time
+}
+
+// watcher holds what has been merged from a watched directory so far.
+type watcher struct {
+	dir string
+	c   *combiner
+	// last is every file as seen on the previous check, done as it was
+	// when it was last read. A file is read again once it changes.
+	last, done map[string]fileState
+	// merged lists the files merged into c, in the order they were first
+	// read.
+	merged []string
+	// stale is set once a file merged into c changed or was removed. c then
+	// holds records the file no longer does, so it is built again.
+	stale bool
+	// hosts and ports are the hosts and open ports announced so far.
+	hosts, ports map[string]bool
+	// groupFiles are the group lists written on the last update.
+	groupFiles []string
+}
+
+func watch(args []string) {
+	if len(args) != 1 {
+		fmt.Fprintln(os.Stderr, "[ERROR] watch takes a single directory")
+		os.Exit(1)
+	}
+	if fi, err := os.Stat(args[0]); err != nil || !fi.IsDir() {
+		fmt.Fprintln(os.Stderr, "[ERROR]", args[0], "is not a directory")
+		os.Exit(1)
+	}
+	if *watchOut == loader.Stdin {
+		fmt.Fprintln(os.Stderr, "[ERROR] watch rewrites its output as scans land, it cannot write to stdout")
+		os.Exit(1)
+	}
+	c, err := newCombiner(*watchMerge, *watchPortMerge)
+	if err != nil {
+		fmt.Fprintln(os.Stderr, "[ERROR]", err)
+		os.Exit(1)
+	}
+
+	w := &watcher{
+		dir:   args[0],
+		c:     c,
+		last:  make(map[string]fileState),
+		done:  make(map[string]fileState),
+		hosts: make(map[string]bool),
+		ports: make(map[string]bool),
+	}
+	fmt.Fprintln(os.Stderr, "[*] watching", w.dir, "every", *watchInterval)
+	for {
+		if ready := w.poll(); len(ready) > 0 || w.stale {
+			w.update(ready)
+		}
+		time.Sleep(*watchInterval)
+	}
+}
+
+// poll returns the files that are new or changed since they were last read
+// and are now complete. It marks the watcher stale when a file that was
+// merged changed or is gone.
+func (w *watcher) poll() []string {
+	files, errs := loader.Expand([]string{w.dir})
+	for _, err := range errs {
+		fmt.Fprintln(os.Stderr, "[ERROR]", err)
+	}
+	merged := make(map[string]bool, len(w.merged))
+	for _, f := range w.merged {
+		merged[f] = true
+	}
+	present := make(map[string]bool, len(files))
+
+	var ready []string
+	for _, f := range files {
+		if w.isOutput(f) || strings.HasPrefix(filepath.Base(f), tempPrefix) {
+			continue
+		}
+		present[f] = true
+		fi, err := os.Stat(f)
+		if err != nil {
+			continue
+		}
+		st := fileState{size: fi.Size(), mod: fi.ModTime()}
+		if done, ok := w.done[f]; ok && done == st {
+			continue
+		}
+		prev, ok := w.last[f]
+		w.last[f] = st
+		stable := ok && prev == st
+
+		format, complete, err := scanComplete(f, stable)
+		if err != nil {
+			continue
+		}
+		if format == loader.Unknown {
+			// too little of it may have been written to tell yet
+			if stable {
+				w.done[f] = st
+			}
+			continue
+		}
+		if !complete {
+			continue
+		}
+		w.done[f] = st
+		ready = append(ready, f)
+		if merged[f] {
+			w.stale = true
+		}
+	}
+
+	kept := w.merged[:0]
+	for _, f := range w.merged {
+		if present[f] {
+			kept = append(kept, f)
+			continue
+		}
+		fmt.Fprintln(os.Stderr, "[-]", f, "is gone")
+		delete(w.last, f)
+		delete(w.done, f)
+		w.stale = true
+	}
+	w.merged = kept
+	return ready
+}
+
+// isOutput reports whether a file is one watch writes itself.
+func (w *watcher) isOutput(f string) bool {
+	if sameFile(f, *watchOut) || (*watchURLs != "" && sameFile(f, *watchURLs)) {
+		return true
+	}
+	if *watchGroups != "" {
+		if rel, err := filepath.Rel(*watchGroups, f); err == nil && !strings.HasPrefix(rel, "..") {
+			return true
+		}
+	}
+	return false
+}
+
+// update merges ready files into what was merged so far, announces the
+// hosts and open ports not seen before, and rewrites the outputs. Once stale,
+// every file is merged again from scratch, as records cannot be taken back
+// out of the combiner.
+func (w *watcher) update(ready []string) {
+	merged := make(map[string]bool, len(w.merged))
+	for _, f := range w.merged {
+		merged[f] = true
+	}
+	for _, f := range ready {
+		if !merged[f] {
+			w.merged = append(w.merged, f)
+		}
+	}
+	load := ready
+	if w.stale {
+		c, err := newCombiner(*watchMerge, *watchPortMerge)
+		if err != nil {
+			fmt.Fprintln(os.Stderr, "[ERROR]", err)
+			return
+		}
+		fmt.Fprintln(os.Stderr, "[*] a scan file changed or was removed, merging every file again")
+		w.c = c
+		w.stale = false
+		load = w.merged
+	}
+
+	ldr := newMergingLoader()
+	ldr.Progress = func(f string) {
+		fmt.Fprintln(os.Stderr, "[+] parsing", f)
+	}
+	ldr.Load(load, w.c.handler())
+	reportErrors(ldr)
+	keys, hosts := w.c.hosts()
+	for _, k := range keys {
+		w.announce(k, hosts[k])
+	}
+
+	if err := w.writeXML(); err != nil {
+		fmt.Fprintln(os.Stderr, "[ERROR] failed to write", *watchOut+":", err)
+	}
+	if *watchGroups != "" {
+		if err := w.writeGroups(); err != nil {
+			fmt.Fprintln(os.Stderr, "[ERROR]", err)
+		}
+	}
+	if *watchURLs != "" {
+		var out []string
+		for _, k := range keys {
+			out = append(out, hostURLs(k, hosts[k], nil)...)
+		}
+		if err := WriteLines(unique(out), *watchURLs); err != nil {
+			fmt.Fprintln(os.Stderr, "[ERROR] failed to write", *watchURLs+":", err)
+		}
+	}
+}
+
+// announce prints a host that is up, and each of its open ports, the first
+// time they are seen.
+func (w *watcher) announce(key string, h nmap.Host) {
+	if h.Status.State == "up" && !w.hosts[key] {
+		w.hosts[key] = true
+		var names []string
+		for _, hn := range h.Hostnames {
+			names = append(names, hn.Name)
+		}
+		if len(names) > 0 {
+			fmt.Println("[+] new host:", key, "("+strings.Join(unique(names), ", ")+")")
+		} else {
+			fmt.Println("[+] new host:", key)
+		}
+	}
+	for _, p := range h.Ports {
+		if p.State.State != "open" {
+			continue
+		}
+		pk := hostid.HostPort(key, p.ID) + "/" + p.Protocol
+		if w.ports[pk] {
+			continue
+		}
+		w.ports[pk] = true
+		if p.Service.Name != "" {
+			fmt.Println("[+] new open port:", pk, p.Service.Name)
+		} else {
+			fmt.Println("[+] new open port:", pk)
+		}
+	}
+}
+
+// writeXML rewrites the combined XML. It is written beside the output and
+// then moved over it, so readers never see it half written.
+func (w *watcher) writeXML() error {
+	final := w.c.result()
+	final.Args = strings.Join(os.Args, " ")
+
+	f, err := os.CreateTemp(filepath.Dir(*watchOut), tempPrefix+"watch-*")
+	if err != nil {
+		return err
+	}
+	tmp := f.Name()
+	defer os.Remove(tmp)
+	x := newXMLWriter(f)
+	x.Comment = "Nmap scan results, parsed by brads tool"
+	x.ScanInfo = w.c.scanInfos
+	if err := x.WriteHeader(&final); err != nil {
+		f.Close()
+		return err
+	}
+	keys, hosts := w.c.hosts()
+	for _, k := range keys {
+		hst := hosts[k]
+		if err := x.WriteHost(&hst); err != nil {
+			f.Close()
+			return err
+		}
+	}
+	if err := x.Close(&final); err != nil {
+		f.Close()
+		return err
+	}
+	if err := f.Close(); err != nil {
+		return err
+	}
+	if err := os.Chmod(tmp, 0644); err != nil {
+		return err
+	}
+	return os.Rename(tmp, *watchOut)
+}
+
+// writeGroups rewrites the group lists from every host merged so far, and
+// removes the lists of groups that no longer exist, such as a port that was
+// first seen without a service name.
+func (w *watcher) writeGroups() error {
+	portnumMap = make(map[int][]string)
+	serviceMap = make(map[string][]string)
+	keys, hosts := w.c.hosts()
+	for _, k := range keys {
+		groupHost(k, hosts[k])
+	}
+	written, err := writeGroups(*watchGroups, *watchPortnum, false)
+	if err != nil {
+		return err
+	}
+	now := make(map[string]bool, len(written))
+	for _, f := range written {
+		now[f] = true
+	}
+	for _, f := range w.groupFiles {
+		if !now[f] {
+			os.Remove(f)
+		}
+	}
+	w.groupFiles = written
+	return nil
+}
+
+// scanComplete reports the format of a scan file and whether it has been
+// written in full. XML is complete once the closing </nmaprun> has been
+// written. Other formats have no such marker, they are taken to be complete
+// once stable, when they did not change since the last check.
+func scanComplete(pth string, stable bool) (loader.Format, bool, error) {
+	in, err := loader.Open(pth)
+	if err != nil {
+		return loader.Unknown, false, err
+	}
+	defer in.Close()
+	if in.Format != loader.NmapXML && in.Format != loader.MasscanXML {
+		return in.Format, stable, nil
+	}
+
+	const marker = "</nmaprun>"
+	var tail []byte
+	if in.Compression == "" {
+		// only the end of a plain file needs reading
+		f, err := os.Open(pth)
+		if err != nil {
+			return in.Format, false, err
+		}
+		defer f.Close()
+		if fi, err := f.Stat(); err == nil && fi.Size() > 4096 {
+			f.Seek(fi.Size()-4096, io.SeekStart)
+		}
+		tail, err = io.ReadAll(f)
+		if err != nil {
+			return in.Format, false, err
+		}
+	} else {
+		buf := make([]byte, 32*1024)
+		for {
+			n, err := in.Read(buf)
+			tail = append(tail, buf[:n]...)
+			if len(tail) > 4096 {
+				tail = tail[len(tail)-4096:]
+			}
+			if err == io.EOF {
+				break
+			}
+			if err != nil {
+				// a compressed file cut short is still being written
+				return in.Format, false, nil
+			}
+		}
+	}
+	return in.Format, bytes.Contains(tail, []byte(marker)), nil
+}
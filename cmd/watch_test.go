@@ -0,0 +1,144 @@
+package cmd
+
+import (
+	"bytes"
+	"compress/gzip"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/redt1de/pnmap/internal/loader"
+)
+
+// scanXML returns the nmap XML of a scan finding ips up, cut off before
+// </nmaprun> unless complete.
+func scanXML(complete bool, ips ...string) string {
+	var b strings.Builder
+	b.WriteString(`<?xml version="1.0" encoding="UTF-8"?>` + "\n")
+	b.WriteString(`<nmaprun scanner="nmap" args="nmap -oX scan.xml 10.0.0.0/24" start="1696240800" version="7.94" xmloutputversion="1.05">` + "\n")
+	for _, ip := range ips {
+		fmt.Fprintf(&b, `<host><status state="up"/><address addr="%s" addrtype="ipv4"/><ports><port protocol="tcp" portid="22"><state state="open"/></port></ports></host>`+"\n", ip)
+	}
+	if complete {
+		fmt.Fprintf(&b, `<runstats><finished time="1696240830" elapsed="30" exit="success"/><hosts up="%d" down="0" total="%d"/></runstats>`+"\n", len(ips), len(ips))
+		b.WriteString("</nmaprun>\n")
+	}
+	return b.String()
+}
+
+func writeFile(t *testing.T, pth, content string) {
+	t.Helper()
+	if err := os.WriteFile(pth, []byte(content), 0644); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func gzipped(t *testing.T, content string) string {
+	t.Helper()
+	var b bytes.Buffer
+	zw := gzip.NewWriter(&b)
+	zw.Write([]byte(content))
+	if err := zw.Close(); err != nil {
+		t.Fatal(err)
+	}
+	return b.String()
+}
+
+func TestScanComplete(t *testing.T) {
+	dir := t.TempDir()
+	var ips []string
+	for i := 0; i < 200; i++ {
+		ips = append(ips, fmt.Sprintf("10.0.%d.%d", i/250, i%250))
+	}
+	gz := gzipped(t, scanXML(true, ips...))
+	tests := []struct {
+		name     string
+		content  string
+		stable   bool
+		format   loader.Format
+		complete bool
+	}{
+		{"done.xml", scanXML(true, "10.0.0.1"), false, loader.NmapXML, true},
+		{"running.xml", scanXML(false, "10.0.0.1"), true, loader.NmapXML, false},
+		// a large scan only has its end read
+		{"large.xml", scanXML(true, ips...), false, loader.NmapXML, true},
+		{"done.xml.gz", gz, false, loader.NmapXML, true},
+		{"running.xml.gz", gz[:len(gz)/2], true, loader.NmapXML, false},
+		// other formats are complete once they stop changing
+		{"scan.gnmap", "Host: 10.0.0.1 ()\tStatus: Up\n", false, loader.Gnmap, false},
+		{"scan2.gnmap", "Host: 10.0.0.1 ()\tStatus: Up\n", true, loader.Gnmap, true},
+	}
+	for _, tt := range tests {
+		pth := filepath.Join(dir, tt.name)
+		writeFile(t, pth, tt.content)
+		format, complete, err := scanComplete(pth, tt.stable)
+		if err != nil {
+			t.Errorf("%s: %v", tt.name, err)
+			continue
+		}
+		if format != tt.format || complete != tt.complete {
+			t.Errorf("%s: format %v complete %v, want %v and %v", tt.name, format, complete, tt.format, tt.complete)
+		}
+	}
+}
+
+// TestWatcherPoll picks up scans as they land, and merges every file again
+// once one that was merged changes or is removed.
+func TestWatcherPoll(t *testing.T) {
+	dir := t.TempDir()
+	defer func(o string) { *watchOut = o }(*watchOut)
+	*watchOut = filepath.Join(dir, "combined.xml")
+	c, err := newCombiner("most-ports", "richest")
+	if err != nil {
+		t.Fatal(err)
+	}
+	w := &watcher{
+		dir:   dir,
+		c:     c,
+		last:  make(map[string]fileState),
+		done:  make(map[string]fileState),
+		hosts: make(map[string]bool),
+		ports: make(map[string]bool),
+	}
+	a, b := filepath.Join(dir, "a.xml"), filepath.Join(dir, "b.xml")
+	step := func(name string, ready []string, stale bool, keys string) {
+		t.Helper()
+		got := w.poll()
+		if fmt.Sprint(got) != fmt.Sprint(ready) || w.stale != stale {
+			t.Fatalf("%s: ready %v stale %v, want %v and %v", name, got, w.stale, ready, stale)
+		}
+		if len(got) > 0 || w.stale {
+			w.update(got)
+		}
+		if k, _ := w.c.hosts(); fmt.Sprint(k) != keys {
+			t.Fatalf("%s: hosts %v, want %s", name, k, keys)
+		}
+	}
+
+	writeFile(t, a, scanXML(true, "10.0.0.1"))
+	step("a lands", []string{a}, false, "[10.0.0.1]")
+	step("nothing changed", nil, false, "[10.0.0.1]")
+
+	writeFile(t, b, scanXML(false, "10.0.0.2"))
+	step("b is still running", nil, false, "[10.0.0.1]")
+	writeFile(t, b, scanXML(true, "10.0.0.2"))
+	step("b is done", []string{b}, false, "[10.0.0.1 10.0.0.2]")
+
+	// the combined XML written to the directory is not read back
+	if _, err := os.Stat(*watchOut); err != nil {
+		t.Fatal(err)
+	}
+	step("combined XML written", nil, false, "[10.0.0.1 10.0.0.2]")
+	// a file rewritten in place may keep its modification time on coarse
+	// file systems, so the new scan differs in size as well
+	writeFile(t, a, scanXML(true, "10.0.0.30"))
+	step("a is scanned again", []string{a}, true, "[10.0.0.30 10.0.0.2]")
+
+	if err := os.Remove(b); err != nil {
+		t.Fatal(err)
+	}
+	step("b is removed", nil, true, "[10.0.0.30]")
+	step("nothing changed again", nil, false, "[10.0.0.30]")
+}
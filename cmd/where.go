@@ -0,0 +1,38 @@
+package cmd
+
+import (
+	"fmt"
+	"os"
+
+	nmap "github.com/Ullaakut/nmap/v3"
+	"github.com/redt1de/pnmap/internal/query"
+)
+
+// parseWhere parses a query expression given on the command line, nil if
+// there is none.
+func parseWhere(expr string) *query.Query {
+	if expr == "" {
+		return nil
+	}
+	q, err := query.Parse(expr)
+	if err != nil {
+		fmt.Fprintln(os.Stderr, "[ERROR] bad expression:", err)
+		os.Exit(1)
+	}
+	return q
+}
+
+// where reports whether a host matches q, and returns it with only the
+// ports q holds for. A nil q matches every host as it is.
+func where(q *query.Query, h nmap.Host) (nmap.Host, bool) {
+	if q == nil {
+		return h, true
+	}
+	if !q.Match(h) {
+		return h, false
+	}
+	if len(h.Ports) > 0 {
+		h.Ports = q.Ports(h)
+	}
+	return h, true
+}
@@ -2,11 +2,14 @@ module github.com/redt1de/pnmap
 
 go 1.18
 
-require github.com/spf13/cobra v1.5.0
+require (
+	github.com/Ullaakut/nmap/v3 v3.0.2
+	github.com/klauspost/compress v1.17.0
+	github.com/spf13/cobra v1.5.0
+)
 
 require (
 	github.com/Ullaakut/nmap v2.0.2+incompatible // indirect
-	github.com/Ullaakut/nmap/v3 v3.0.2 // indirect
 	github.com/inconshreveable/mousetrap v1.0.0 // indirect
 	github.com/spf13/pflag v1.0.5 // indirect
 	golang.org/x/sync v0.1.0 // indirect
@@ -0,0 +1,120 @@
+// Package hostid works out which host a scan record belongs to. Nmap lists
+// the addresses of a host in no fixed order, on a local segment the MAC
+// address comes first, so the first address is not an identity to rely on.
+package hostid
+
+import (
+	"net"
+	"strconv"
+	"strings"
+
+	nmap "github.com/Ullaakut/nmap/v3"
+)
+
+// IPv4 returns the first IPv4 address of a host, or "" if it has none.
+func IPv4(h nmap.Host) string {
+	return find(h, "ipv4")
+}
+
+// IPv6 returns the first IPv6 address of a host, or "" if it has none.
+func IPv6(h nmap.Host) string {
+	return find(h, "ipv6")
+}
+
+// MAC returns the MAC address of a host in upper case, or "" if it has none.
+func MAC(h nmap.Host) string {
+	return strings.ToUpper(find(h, "mac"))
+}
+
+// Addr returns the address a host is known by, its IPv4 address, else its
+// IPv6 address, or "" if it has neither.
+func Addr(h nmap.Host) string {
+	if ip := IPv4(h); ip != "" {
+		return ip
+	}
+	return IPv6(h)
+}
+
+// HostPort joins an address and a port, putting IPv6 addresses in brackets.
+func HostPort(addr string, port uint16) string {
+	return net.JoinHostPort(addr, strconv.Itoa(int(port)))
+}
+
+// find returns the first address of the given type. Addresses written
+// without a type are typed by their form.
+func find(h nmap.Host, typ string) string {
+	for _, a := range h.Addresses {
+		if addrType(a) == typ {
+			return a.Addr
+		}
+	}
+	return ""
+}
+
+func addrType(a nmap.Address) string {
+	if a.AddrType != "" {
+		return a.AddrType
+	}
+	if _, err := net.ParseMAC(a.Addr); err == nil {
+		return "mac"
+	}
+	ip := net.ParseIP(a.Addr)
+	switch {
+	case ip == nil:
+		return ""
+	case ip.To4() != nil:
+		return "ipv4"
+	}
+	return "ipv6"
+}
+
+// Keyer hands out the key that records of the same host share, which is the
+// address the host is known by, see Addr. The zero value is ready to use.
+type Keyer struct {
+	// MergeMAC gives records that share a MAC address the same key when one
+	// is for the IPv4 and the other for the IPv6 address of the host. Records
+	// take the key of the first one seen, so an IPv6 record seen before the
+	// IPv4 one keys the host by its IPv6 address.
+	MergeMAC bool
+
+	macs map[string]*macHost
+}
+
+// macHost is a host seen with a MAC address, by its key and the address it
+// was seen with for each family.
+type macHost struct {
+	key        string
+	ipv4, ipv6 string
+}
+
+// Key returns the key for a record, or "" for a record with neither an IPv4
+// nor an IPv6 address.
+func (k *Keyer) Key(h nmap.Host) string {
+	addr := Addr(h)
+	mac := MAC(h)
+	if !k.MergeMAC || addr == "" || mac == "" {
+		return addr
+	}
+	if k.macs == nil {
+		k.macs = make(map[string]*macHost)
+	}
+
+	v4, v6 := IPv4(h), IPv6(h)
+	m, ok := k.macs[mac]
+	if !ok {
+		k.macs[mac] = &macHost{key: addr, ipv4: v4, ipv6: v6}
+		return addr
+	}
+	// the record belongs to the host when it agrees with every address the
+	// host was already seen with
+	if (v4 != "" && m.ipv4 != "" && v4 != m.ipv4) || (v6 != "" && m.ipv6 != "" && v6 != m.ipv6) {
+		return addr
+	}
+	if m.ipv4 == "" {
+		m.ipv4 = v4
+	}
+	if m.ipv6 == "" {
+		m.ipv6 = v6
+	}
+	return m.key
+}
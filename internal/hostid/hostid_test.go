@@ -0,0 +1,113 @@
+package hostid
+
+import (
+	"testing"
+
+	nmap "github.com/Ullaakut/nmap/v3"
+)
+
+func host(addrs ...string) nmap.Host {
+	var h nmap.Host
+	for i := 0; i+1 < len(addrs); i += 2 {
+		h.Addresses = append(h.Addresses, nmap.Address{Addr: addrs[i], AddrType: addrs[i+1]})
+	}
+	return h
+}
+
+func TestAddr(t *testing.T) {
+	tests := []struct {
+		name string
+		host nmap.Host
+		addr string
+		mac  string
+	}{
+		// nmap lists the MAC address first on a local segment
+		{"mac first", host("00:50:56:aa:bb:cc", "mac", "10.0.0.5", "ipv4"), "10.0.0.5", "00:50:56:AA:BB:CC"},
+		{"ipv4 over ipv6", host("2001:db8::5", "ipv6", "10.0.0.5", "ipv4"), "10.0.0.5", ""},
+		{"ipv6 only", host("2001:db8::5", "ipv6"), "2001:db8::5", ""},
+		{"untyped", host("00:50:56:AA:BB:CC", "", "2001:db8::5", "", "10.0.0.5", ""), "10.0.0.5", "00:50:56:AA:BB:CC"},
+		{"mac only", host("00:50:56:AA:BB:CC", "mac"), "", "00:50:56:AA:BB:CC"},
+		{"no address", nmap.Host{}, "", ""},
+	}
+	for _, tt := range tests {
+		if got := Addr(tt.host); got != tt.addr {
+			t.Errorf("%s: Addr is %q, want %q", tt.name, got, tt.addr)
+		}
+		if got := MAC(tt.host); got != tt.mac {
+			t.Errorf("%s: MAC is %q, want %q", tt.name, got, tt.mac)
+		}
+	}
+}
+
+func TestHostPort(t *testing.T) {
+	if got := HostPort("10.0.0.5", 80); got != "10.0.0.5:80" {
+		t.Errorf("ipv4: %s", got)
+	}
+	if got := HostPort("2001:db8::5", 443); got != "[2001:db8::5]:443" {
+		t.Errorf("ipv6: %s", got)
+	}
+}
+
+// TestKeyer keys a series of records, each test starting a new keyer.
+func TestKeyer(t *testing.T) {
+	const mac = "00:50:56:AA:BB:CC"
+	tests := []struct {
+		name     string
+		mergeMAC bool
+		records  []nmap.Host
+		keys     []string
+	}{
+		{
+			name:    "without merge-mac",
+			records: []nmap.Host{host(mac, "mac", "10.0.0.5", "ipv4"), host("2001:db8::5", "ipv6", mac, "mac")},
+			keys:    []string{"10.0.0.5", "2001:db8::5"},
+		},
+		{
+			name:     "ipv4 then ipv6",
+			mergeMAC: true,
+			records:  []nmap.Host{host(mac, "mac", "10.0.0.5", "ipv4"), host("2001:db8::5", "ipv6", "00:50:56:aa:bb:cc", "mac")},
+			keys:     []string{"10.0.0.5", "10.0.0.5"},
+		},
+		{
+			// the host is keyed by the record seen first
+			name:     "ipv6 then ipv4",
+			mergeMAC: true,
+			records:  []nmap.Host{host("2001:db8::5", "ipv6", mac, "mac"), host("10.0.0.5", "ipv4", mac, "mac"), host("10.0.0.5", "ipv4")},
+			keys:     []string{"2001:db8::5", "2001:db8::5", "10.0.0.5"},
+		},
+		{
+			name:     "ipv6 only",
+			mergeMAC: true,
+			records:  []nmap.Host{host("2001:db8::5", "ipv6"), host("2001:db8::5", "ipv6", mac, "mac")},
+			keys:     []string{"2001:db8::5", "2001:db8::5"},
+		},
+		{
+			// a router answers for the hosts behind it with its own MAC
+			// address, which makes no two of them the same host
+			name:     "two ipv4 addresses",
+			mergeMAC: true,
+			records:  []nmap.Host{host("10.0.0.5", "ipv4", mac, "mac"), host("10.0.0.6", "ipv4", mac, "mac")},
+			keys:     []string{"10.0.0.5", "10.0.0.6"},
+		},
+		{
+			name:     "ipv6 address taken",
+			mergeMAC: true,
+			records:  []nmap.Host{host("10.0.0.5", "ipv4", "2001:db8::5", "ipv6", mac, "mac"), host("2001:db8::6", "ipv6", mac, "mac")},
+			keys:     []string{"10.0.0.5", "2001:db8::6"},
+		},
+		{
+			name:     "no ip address",
+			mergeMAC: true,
+			records:  []nmap.Host{host(mac, "mac"), {}},
+			keys:     []string{"", ""},
+		},
+	}
+	for _, tt := range tests {
+		k := &Keyer{MergeMAC: tt.mergeMAC}
+		for i, h := range tt.records {
+			if got := k.Key(h); got != tt.keys[i] {
+				t.Errorf("%s: record %d keyed %q, want %q", tt.name, i, got, tt.keys[i])
+			}
+		}
+	}
+}
@@ -0,0 +1,80 @@
+package loader
+
+import (
+	"bufio"
+
+	nmap "github.com/Ullaakut/nmap/v3"
+	"github.com/redt1de/pnmap/internal/nmapxml"
+)
+
+// decoder reads the hosts of a single input one at a time, whatever its
+// format, and maps them onto the nmap host model.
+type decoder interface {
+	// Next returns the next host, or io.EOF once the input is exhausted.
+	Next() (nmap.Host, error)
+	// run returns the run level metadata, complete once Next returned io.EOF.
+	run() nmap.Run
+	// truncated reports the hosts that were cut off and the error recovered
+	// from, for an input read in recover mode that ended early.
+	truncated() ([]nmap.Host, error)
+}
+
+// scanInfoer is implemented by decoders of formats that can hold more than
+// one scaninfo.
+type scanInfoer interface {
+	scanInfo() []nmap.ScanInfo
+}
+
+// scanInfos returns every scaninfo of the input dec read.
+func scanInfos(dec decoder, r nmap.Run) []nmap.ScanInfo {
+	if si, ok := dec.(scanInfoer); ok {
+		return si.scanInfo()
+	}
+	if r.ScanInfo == (nmap.ScanInfo{}) {
+		return nil
+	}
+	return []nmap.ScanInfo{r.ScanInfo}
+}
+
+// newDecoder returns a decoder for an input of the given format, or nil if
+// the format holds no hosts.
+func newDecoder(format Format, r *bufio.Reader, recover bool) decoder {
+	switch format {
+	case NmapXML:
+		dec := nmapxml.NewDecoder(r)
+		dec.Recover = recover
+		return xmlDecoder{dec}
+	case Gnmap:
+		return newGnmapDecoder(r, recover)
+	case MasscanXML:
+		return newMasscanDecoder(loadMasscanXML(r), recover)
+	case MasscanJSON:
+		return newMasscanDecoder(loadMasscanJSON(r), recover)
+	case MasscanList:
+		return newMasscanDecoder(loadMasscanList(r), recover)
+	case NaabuJSON:
+		return newNaabuDecoder(r, recover)
+	case Rustscan:
+		return newRustscanDecoder(r, recover)
+	case Nessus:
+		return newNessusDecoder(r, recover)
+	}
+	return nil
+}
+
+// xmlDecoder reads nmap XML.
+type xmlDecoder struct {
+	*nmapxml.Decoder
+}
+
+func (d xmlDecoder) run() nmap.Run {
+	return d.Run
+}
+
+func (d xmlDecoder) scanInfo() []nmap.ScanInfo {
+	return d.ScanInfo
+}
+
+func (d xmlDecoder) truncated() ([]nmap.Host, error) {
+	return d.Partial, d.Truncated
+}
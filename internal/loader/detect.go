@@ -0,0 +1,227 @@
+package loader
+
+import (
+	"archive/tar"
+	"archive/zip"
+	"bufio"
+	"bytes"
+	"compress/bzip2"
+	"compress/gzip"
+	"fmt"
+	"io"
+	"os"
+	"strings"
+
+	"github.com/klauspost/compress/zstd"
+)
+
+// Format identifies the format of an input, as detected from its content.
+type Format string
+
+// Supported formats.
+const (
+	Unknown     Format = "unknown"
+	NmapXML     Format = "nmap-xml"
+	Gnmap       Format = "nmap-grepable"
+	MasscanXML  Format = "masscan-xml"
+	MasscanJSON Format = "masscan-json"
+	MasscanList Format = "masscan-list"
+	NaabuJSON   Format = "naabu-json"
+	Rustscan    Format = "rustscan"
+	Nessus      Format = "nessus"
+	CSV         Format = "csv"
+	Tar         Format = "tar"
+	Zip         Format = "zip"
+)
+
+// sniffLen is how much of an input is looked at to detect its format.
+const sniffLen = 8192
+
+// Detect reports the format of an input from the first few KB of its
+// (decompressed) content.
+func Detect(head []byte) Format {
+	if bytes.HasPrefix(head, []byte("PK\x03\x04")) {
+		return Zip
+	}
+	if len(head) > 262 && string(head[257:262]) == "ustar" {
+		return Tar
+	}
+
+	text := strings.TrimLeft(string(head), "\ufeff \t\r\n")
+	first := strings.TrimSpace(strings.SplitN(text, "\n", 2)[0])
+
+	switch {
+	case strings.Contains(text, "<NessusClientData_v2"):
+		return Nessus
+	case strings.Contains(text, "<nmaprun"):
+		if strings.Contains(text, `scanner="masscan"`) {
+			return MasscanXML
+		}
+		return NmapXML
+	case strings.HasPrefix(text, "#masscan"):
+		return MasscanList
+	case strings.HasPrefix(text, "# Nmap"):
+		// normal (-oN) output starts with the same comment, but only it
+		// has the human readable host reports
+		if strings.Contains(text, "Nmap scan report for") {
+			return Unknown
+		}
+		return Gnmap
+	case strings.HasPrefix(text, "Host: "):
+		return Gnmap
+	case strings.HasPrefix(text, "[") || strings.HasPrefix(text, "{"):
+		if strings.Contains(text, `"ports"`) {
+			return MasscanJSON
+		}
+		if strings.Contains(text, `"port"`) {
+			return NaabuJSON
+		}
+	case rustscanLine.MatchString(first):
+		return Rustscan
+	case strings.HasPrefix(strings.ToLower(first), "ip,"):
+		return CSV
+	}
+	return Unknown
+}
+
+// Input is an opened input with any compression removed and its format
+// detected.
+type Input struct {
+	*bufio.Reader
+	Format Format
+	// Compression names the compression that was removed, if any.
+	Compression string
+
+	closers []io.Closer
+}
+
+// Open opens the file at path, or standard input if path is Stdin. gzip, zstd
+// and bzip2 compressed files are decompressed transparently.
+func Open(path string) (*Input, error) {
+	if path == Stdin {
+		return NewInput(os.Stdin)
+	}
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	in, err := NewInput(f)
+	if err != nil {
+		f.Close()
+		return nil, err
+	}
+	in.closers = append(in.closers, f)
+	return in, nil
+}
+
+// NewInput detects the format of the content read from r, decompressing it
+// first if need be.
+func NewInput(r io.Reader) (*Input, error) {
+	in := &Input{Reader: bufio.NewReaderSize(r, 64*1024)}
+	magic, _ := in.Peek(4)
+
+	switch {
+	case bytes.HasPrefix(magic, []byte{0x1f, 0x8b}):
+		gz, err := gzip.NewReader(in.Reader)
+		if err != nil {
+			return nil, err
+		}
+		in.Compression = "gzip"
+		in.closers = append(in.closers, gz)
+		in.Reader = bufio.NewReaderSize(gz, 64*1024)
+	case bytes.HasPrefix(magic, []byte{0x28, 0xb5, 0x2f, 0xfd}):
+		zr, err := zstd.NewReader(in.Reader)
+		if err != nil {
+			return nil, err
+		}
+		in.Compression = "zstd"
+		in.closers = append(in.closers, zr.IOReadCloser())
+		in.Reader = bufio.NewReaderSize(zr, 64*1024)
+	case bytes.HasPrefix(magic, []byte("BZh")):
+		in.Compression = "bzip2"
+		in.Reader = bufio.NewReaderSize(bzip2.NewReader(in.Reader), 64*1024)
+	}
+
+	head, err := in.Peek(sniffLen)
+	if err != nil && err != io.EOF && err != bufio.ErrBufferFull {
+		in.Close()
+		return nil, err
+	}
+	in.Format = Detect(head)
+	return in, nil
+}
+
+// Close releases the input and anything opened to decompress it.
+func (in *Input) Close() error {
+	var err error
+	for i := len(in.closers) - 1; i >= 0; i-- {
+		if cerr := in.closers[i].Close(); cerr != nil && err == nil {
+			err = cerr
+		}
+	}
+	return err
+}
+
+// member is a single file inside an archive.
+type member struct {
+	name string
+	open func() (io.ReadCloser, error)
+}
+
+// members lists the regular files of an archive input. A zip needs random
+// access, so unless it was read straight from the file at path it is read
+// into memory first. For a tar, each member must be read before the next is
+// opened.
+func members(path string, in *Input, fn func(m member) error) error {
+	switch in.Format {
+	case Tar:
+		tr := tar.NewReader(in)
+		for {
+			hdr, err := tr.Next()
+			if err == io.EOF {
+				return nil
+			}
+			if err != nil {
+				return err
+			}
+			if hdr.Typeflag != tar.TypeReg {
+				continue
+			}
+			m := member{name: hdr.Name, open: func() (io.ReadCloser, error) {
+				return io.NopCloser(tr), nil
+			}}
+			if err := fn(m); err != nil {
+				return err
+			}
+		}
+	case Zip:
+		var zr *zip.Reader
+		if in.Compression == "" && path != "" && path != Stdin {
+			rc, err := zip.OpenReader(path)
+			if err != nil {
+				return err
+			}
+			defer rc.Close()
+			zr = &rc.Reader
+		} else {
+			data, err := io.ReadAll(in)
+			if err != nil {
+				return err
+			}
+			zr, err = zip.NewReader(bytes.NewReader(data), int64(len(data)))
+			if err != nil {
+				return err
+			}
+		}
+		for _, zf := range zr.File {
+			if !zf.Mode().IsRegular() {
+				continue
+			}
+			if err := fn(member{name: zf.Name, open: zf.Open}); err != nil {
+				return err
+			}
+		}
+		return nil
+	}
+	return fmt.Errorf("%s is not an archive format", in.Format)
+}
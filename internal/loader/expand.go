@@ -0,0 +1,100 @@
+package loader
+
+import (
+	"fmt"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// Stdin is the input name that reads from standard input.
+const Stdin = "-"
+
+// Expand resolves args into a list of input files. Each arg may be a glob, a
+// directory, which is searched recursively, a plain path or Stdin. Files keep
+// argument order and a file named more than once is returned once.
+func Expand(args []string) ([]string, []error) {
+	files, errs := expand(args)
+	paths := make([]string, len(files))
+	for i, f := range files {
+		paths[i] = f.path
+	}
+	return paths, errs
+}
+
+// file is an input file found by expand.
+type file struct {
+	path string
+	// walked is set for files found by searching a directory, rather than
+	// named on the command line. Those are skipped quietly when they turn out
+	// not to hold scan results.
+	walked bool
+}
+
+func expand(args []string) ([]file, []error) {
+	var files []file
+	var errs []error
+	seen := make(map[string]bool)
+
+	add := func(f string, walked bool) {
+		if f != Stdin {
+			f = filepath.Clean(f)
+		}
+		if !seen[f] {
+			seen[f] = true
+			files = append(files, file{path: f, walked: walked})
+		}
+	}
+
+	for _, arg := range args {
+		if arg == Stdin {
+			add(arg, false)
+			continue
+		}
+		matches := []string{arg}
+		if hasMeta(arg) {
+			var err error
+			matches, err = filepath.Glob(arg)
+			if err != nil {
+				errs = append(errs, &FileError{Path: arg, Err: err})
+				continue
+			}
+			if len(matches) == 0 {
+				errs = append(errs, &FileError{Path: arg, Err: fmt.Errorf("no files match")})
+				continue
+			}
+		}
+
+		for _, m := range matches {
+			fi, err := os.Stat(m)
+			if err != nil {
+				errs = append(errs, &FileError{Path: m, Err: err})
+				continue
+			}
+			if !fi.IsDir() {
+				add(m, false)
+				continue
+			}
+			err = filepath.WalkDir(m, func(pth string, d fs.DirEntry, err error) error {
+				if err != nil {
+					errs = append(errs, &FileError{Path: pth, Err: err})
+					return nil
+				}
+				if d.Type().IsRegular() {
+					add(pth, true)
+				}
+				return nil
+			})
+			if err != nil {
+				errs = append(errs, &FileError{Path: m, Err: err})
+			}
+		}
+	}
+	return files, errs
+}
+
+// hasMeta reports whether path contains any glob magic characters.
+func hasMeta(path string) bool {
+	return strings.ContainsAny(path, "*?[")
+}
@@ -0,0 +1,312 @@
+package loader
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"net"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+
+	nmap "github.com/Ullaakut/nmap/v3"
+)
+
+// gnmapDecoder reads the hosts of nmap grepable (-oG) output. nmap writes a
+// host over one or more consecutive lines, which are folded into one host.
+type gnmapDecoder struct {
+	recover bool
+
+	r         *bufio.Reader
+	meta      nmap.Run
+	scanInfos []nmap.ScanInfo
+	pending   *nmap.Host
+	done      bool
+	unclosed  bool
+	failed    error
+	partial   []nmap.Host
+	up        int
+	down      int
+}
+
+var (
+	gnmapStarted = regexp.MustCompile(`^# Nmap (\S+) scan initiated (.+?) as: (.*)$`)
+	gnmapDone    = regexp.MustCompile(`^# Nmap done at (.+?) -- (\d+) IP address(?:es)? \((\d+) hosts? up\) scanned in ([\d.]+) seconds`)
+	gnmapScanned = regexp.MustCompile(`(TCP|UDP|SCTP|PROTOCOLS)\((\d+);([^)]*)\)`)
+	gnmapHost    = regexp.MustCompile(`^Host: (\S+) \(([^)]*)\)$`)
+	gnmapIgnored = regexp.MustCompile(`^(\S+) \((\d+)\)$`)
+)
+
+func newGnmapDecoder(r io.Reader, recover bool) *gnmapDecoder {
+	return &gnmapDecoder{
+		recover: recover,
+		r:       bufio.NewReader(r),
+		meta:    nmap.Run{Scanner: "nmap", XMLOutputVersion: "1.05"},
+	}
+}
+
+func (d *gnmapDecoder) Next() (nmap.Host, error) {
+	for !d.done {
+		line, err := d.r.ReadString('\n')
+		if err == io.EOF && line == "" {
+			// the input ends on a complete line but nmap never said it was
+			// done, as with the output of grep or of a run still going, so
+			// the hosts read so far are taken as they are
+			d.done, d.unclosed = true, true
+			break
+		}
+		if err == io.EOF {
+			// a final line without a newline was cut off part way through
+			if m := gnmapHost.FindStringSubmatch(strings.SplitN(line, "\t", 2)[0]); m != nil && d.recover {
+				if d.pending == nil || d.pending.Addresses[0].Addr != m[1] {
+					d.partial = append(d.partial, *newGnmapHost(m[1], m[2]))
+				}
+			}
+			break
+		}
+		if err != nil {
+			return nmap.Host{}, d.fail(err)
+		}
+
+		line = strings.TrimRight(line, "\r\n")
+		if strings.HasPrefix(line, "#") {
+			d.comment(line)
+			continue
+		}
+		if !strings.HasPrefix(line, "Host: ") {
+			continue
+		}
+
+		fields := strings.Split(line, "\t")
+		m := gnmapHost.FindStringSubmatch(fields[0])
+		if m == nil {
+			return nmap.Host{}, d.fail(fmt.Errorf("malformed host line %q", line))
+		}
+
+		var prev *nmap.Host
+		if d.pending != nil && d.pending.Addresses[0].Addr != m[1] {
+			prev = d.pending
+			d.pending = nil
+		}
+		if d.pending == nil {
+			d.pending = newGnmapHost(m[1], m[2])
+		}
+		if err := parseGnmapFields(d.pending, fields[1:]); err != nil {
+			return nmap.Host{}, d.fail(err)
+		}
+		if prev != nil {
+			return d.emit(prev), nil
+		}
+	}
+
+	if !d.done {
+		// the input stopped before nmap was done, so the host being read
+		// may still be missing lines
+		return nmap.Host{}, d.fail(io.ErrUnexpectedEOF)
+	}
+	if d.pending != nil {
+		prev := d.pending
+		d.pending = nil
+		return d.emit(prev), nil
+	}
+	if d.failed != nil && !d.recover {
+		return nmap.Host{}, d.failed
+	}
+	return nmap.Host{}, io.EOF
+}
+
+func (d *gnmapDecoder) run() nmap.Run {
+	if d.unclosed {
+		r := d.meta
+		r.Stats.Finished.Summary = fmt.Sprintf("No Nmap done line; %d hosts (%d up) read", d.up+d.down, d.up)
+		r.Stats.Hosts = nmap.HostStats{Up: d.up, Down: d.down, Total: d.up + d.down}
+		return r
+	}
+	return d.meta
+}
+
+func (d *gnmapDecoder) scanInfo() []nmap.ScanInfo {
+	return d.scanInfos
+}
+
+func (d *gnmapDecoder) truncated() ([]nmap.Host, error) {
+	if d.recover {
+		return d.partial, d.failed
+	}
+	return nil, nil
+}
+
+// emit counts h towards the run stats and returns it.
+func (d *gnmapDecoder) emit(h *nmap.Host) nmap.Host {
+	if h.Status.State == "up" {
+		d.up++
+	} else {
+		d.down++
+	}
+	return *h
+}
+
+// fail handles an error hit part way through the input. Without recover the
+// error is returned as is. In recover mode the host being read is dropped as
+// partial, the run stats are synthesized and io.EOF is returned.
+func (d *gnmapDecoder) fail(err error) error {
+	d.done = true
+	d.failed = err
+	if !d.recover {
+		return err
+	}
+	if d.pending != nil {
+		d.partial = append(d.partial, *d.pending)
+		d.pending = nil
+	}
+	d.meta.Stats.Finished.Summary = fmt.Sprintf("Recovered from interrupted scan; %d hosts (%d up) written before it stopped", d.up+d.down, d.up)
+	d.meta.Stats.Finished.Exit = "error"
+	d.meta.Stats.Finished.ErrorMsg = err.Error()
+	d.meta.Stats.Hosts = nmap.HostStats{Up: d.up, Down: d.down, Total: d.up + d.down}
+	return io.EOF
+}
+
+// comment reads the run level metadata nmap writes as comments.
+func (d *gnmapDecoder) comment(line string) {
+	r := &d.meta
+	if m := gnmapStarted.FindStringSubmatch(line); m != nil {
+		r.Version = m[1]
+		r.StartStr = m[2]
+		r.Args = m[3]
+		if t, err := time.ParseInLocation(time.ANSIC, m[2], time.Local); err == nil {
+			r.Start = nmap.Timestamp(t)
+		}
+		return
+	}
+	if strings.HasPrefix(line, "# Ports scanned:") {
+		for _, m := range gnmapScanned.FindAllStringSubmatch(line, -1) {
+			n, _ := strconv.Atoi(m[2])
+			if n == 0 {
+				continue
+			}
+			si := nmap.ScanInfo{Protocol: strings.ToLower(m[1]), NumServices: n, Services: m[3]}
+			if r.ScanInfo.Protocol == "" {
+				r.ScanInfo = si
+			}
+			d.scanInfos = append(d.scanInfos, si)
+		}
+		return
+	}
+	if m := gnmapDone.FindStringSubmatch(line); m != nil {
+		// the pending host is complete once nmap is done
+		d.done = true
+		total, _ := strconv.Atoi(m[2])
+		up, _ := strconv.Atoi(m[3])
+		elapsed, _ := strconv.ParseFloat(m[4], 32)
+		r.Stats.Finished.TimeStr = m[1]
+		if t, err := time.ParseInLocation(time.ANSIC, m[1], time.Local); err == nil {
+			r.Stats.Finished.Time = nmap.Timestamp(t)
+		}
+		r.Stats.Finished.Elapsed = float32(elapsed)
+		r.Stats.Finished.Summary = fmt.Sprintf("Nmap done at %s; %s", m[1], strings.SplitN(line, " -- ", 2)[1])
+		r.Stats.Finished.Exit = "success"
+		r.Stats.Hosts = nmap.HostStats{Up: up, Down: total - up, Total: total}
+	}
+}
+
+// newGnmapHost returns a host with the address and hostname of a host line.
+func newGnmapHost(ip, name string) *nmap.Host {
+	h := &nmap.Host{
+		Status:    nmap.Status{State: "up"},
+		Addresses: []nmap.Address{{Addr: ip, AddrType: addrType(ip)}},
+	}
+	if name != "" {
+		h.Hostnames = []nmap.Hostname{{Name: name, Type: "PTR"}}
+	}
+	return h
+}
+
+// parseGnmapFields reads the tab separated "Name: value" fields that follow
+// the host on a grepable output line.
+func parseGnmapFields(h *nmap.Host, fields []string) error {
+	for _, f := range fields {
+		name, value, ok := strings.Cut(f, ": ")
+		if !ok {
+			continue
+		}
+		switch name {
+		case "Status":
+			h.Status.State = strings.ToLower(value)
+		case "Ports":
+			ports, err := parseGnmapPorts(value)
+			if err != nil {
+				return err
+			}
+			h.Ports = append(h.Ports, ports...)
+		case "Ignored State":
+			if m := gnmapIgnored.FindStringSubmatch(value); m != nil {
+				n, _ := strconv.Atoi(m[2])
+				h.ExtraPorts = append(h.ExtraPorts, nmap.ExtraPort{State: m[1], Count: n})
+			}
+		case "OS":
+			h.OS.Matches = append(h.OS.Matches, nmap.OSMatch{Name: value})
+		case "Seq Index":
+			h.TCPSequence.Index, _ = strconv.Atoi(value)
+		case "IP ID Seq":
+			h.IPIDSequence.Class = value
+		}
+	}
+	return nil
+}
+
+// parseGnmapPorts reads a Ports field. Every port is written as seven slash
+// terminated fields, port/state/protocol/owner/service/rpc info/version/, and
+// ports are separated by ", ". nmap replaces slashes within a field with '|',
+// so the version may contain commas but never a slash.
+func parseGnmapPorts(value string) ([]nmap.Port, error) {
+	var ports []nmap.Port
+	rest := value
+	for rest != "" {
+		var f [7]string
+		for i := range f {
+			var ok bool
+			f[i], rest, ok = strings.Cut(rest, "/")
+			if !ok {
+				return nil, fmt.Errorf("malformed port entry in %q", value)
+			}
+		}
+		rest = strings.TrimPrefix(rest, ",")
+		rest = strings.TrimLeft(rest, " ")
+
+		id, err := strconv.ParseUint(f[0], 10, 16)
+		if err != nil {
+			return nil, fmt.Errorf("malformed port number %q", f[0])
+		}
+		p := nmap.Port{
+			ID:       uint16(id),
+			State:    nmap.State{State: f[1]},
+			Protocol: f[2],
+			Owner:    nmap.Owner{Name: f[3]},
+		}
+
+		svc := strings.TrimSuffix(f[4], "?")
+		if tunnel, name, ok := strings.Cut(svc, "|"); ok {
+			p.Service.Tunnel = tunnel
+			svc = name
+		}
+		p.Service.Name = svc
+		p.Service.RPCNum = f[5]
+		p.Service.Product = f[6]
+		if f[6] != "" {
+			p.Service.Method, p.Service.Confidence = "probed", 10
+		} else if svc != "" {
+			p.Service.Method, p.Service.Confidence = "table", 3
+		}
+		ports = append(ports, p)
+	}
+	return ports, nil
+}
+
+// addrType returns the nmap address type of ip.
+func addrType(ip string) string {
+	if parsed := net.ParseIP(ip); parsed != nil && parsed.To4() == nil {
+		return "ipv6"
+	}
+	return "ipv4"
+}
@@ -0,0 +1,146 @@
+package loader
+
+import (
+	"errors"
+	"io"
+	"testing"
+)
+
+func TestGnmap(t *testing.T) {
+	res := loadFile(t, "scan.gnmap", false)
+	run := res.run(t)
+
+	if run.Version != "7.94SVN" || run.Args != "nmap -sS -sU -sV -O -oG scan.gnmap 10.0.0.0/24" {
+		t.Errorf("run version %q args %q", run.Version, run.Args)
+	}
+	if run.StartStr != "Mon Oct  2 10:00:00 2023" {
+		t.Errorf("run start %q", run.StartStr)
+	}
+	if s := run.Stats.Hosts; s.Up != 1 || s.Down != 255 || s.Total != 256 {
+		t.Errorf("run hosts %+v, want 1 up of 256", s)
+	}
+	if f := run.Stats.Finished; f.Exit != "success" || f.Elapsed != 30.12 {
+		t.Errorf("run finished %+v", f)
+	}
+	if len(run.ScanInfos) != 2 {
+		t.Fatalf("got %d scaninfos, want 2", len(run.ScanInfos))
+	}
+	if si := run.ScanInfos[1]; si.Protocol != "udp" || si.NumServices != 2 || si.Services != "53,161" {
+		t.Errorf("udp scaninfo %+v", si)
+	}
+
+	if got := res.addrs(); !equal(got, []string{"10.0.0.5", "10.0.0.6"}) {
+		t.Fatalf("hosts %v", got)
+	}
+	if h := res.host(t, "10.0.0.6"); h.Status.State != "down" || len(h.Hostnames) != 0 {
+		t.Errorf("10.0.0.6: state %q hostnames %v", h.Status.State, h.Hostnames)
+	}
+
+	// the host is written over two lines, which are folded into one
+	h := res.host(t, "10.0.0.5")
+	if h.Status.State != "up" || len(h.Hostnames) != 1 || h.Hostnames[0].Name != "web.example.com" {
+		t.Errorf("10.0.0.5: state %q hostnames %v", h.Status.State, h.Hostnames)
+	}
+	if len(h.Ports) != 4 {
+		t.Fatalf("10.0.0.5: got %d ports, want 4", len(h.Ports))
+	}
+	if len(h.ExtraPorts) != 1 || h.ExtraPorts[0].State != "closed" || h.ExtraPorts[0].Count != 997 {
+		t.Errorf("extraports %+v", h.ExtraPorts)
+	}
+	if len(h.OS.Matches) != 1 || h.OS.Matches[0].Name != "Linux 5.X" {
+		t.Errorf("os %+v", h.OS.Matches)
+	}
+	if h.TCPSequence.Index != 260 || h.IPIDSequence.Class != "All zeros" {
+		t.Errorf("seq index %d, ip id seq %q", h.TCPSequence.Index, h.IPIDSequence.Class)
+	}
+
+	tests := []struct {
+		id      uint16
+		proto   string
+		state   string
+		name    string
+		tunnel  string
+		product string
+		method  string
+	}{
+		// the version field holds commas and parentheses
+		{22, "tcp", "open", "ssh", "", "OpenSSH 8.9p1 Ubuntu 3 (Ubuntu Linux, protocol 2.0)", "probed"},
+		{443, "tcp", "open", "http", "ssl", "nginx 1.18.0", "probed"},
+		// a guessed service is written with a trailing '?'
+		{8080, "tcp", "filtered", "http-proxy", "", "", "table"},
+		{53, "udp", "open|filtered", "domain", "", "", "table"},
+	}
+	for _, tt := range tests {
+		p := port(t, h, tt.id, tt.proto)
+		s := p.Service
+		if p.State.State != tt.state || s.Name != tt.name || s.Tunnel != tt.tunnel || s.Product != tt.product || s.Method != tt.method {
+			t.Errorf("%d/%s: state %q service %+v", tt.id, tt.proto, p.State.State, s)
+		}
+	}
+}
+
+// cut is scan.gnmap killed part way through the second line of a host.
+const cut = "# Nmap 7.94SVN scan initiated Mon Oct  2 10:00:00 2023 as: nmap -oG - 10.0.0.0/24\n" +
+	"Host: 10.0.0.6 ()\tStatus: Down\n" +
+	"Host: 10.0.0.5 (web.example.com)\tStatus: Up\n" +
+	"Host: 10.0.0.5 (web.example.com)\tPorts: 22/open/tcp//ssh///, 80/op"
+
+func TestGnmapTruncated(t *testing.T) {
+	res := loadString(t, cut, false)
+	errs := res.l.Errors()
+	if len(errs) != 1 || !errors.Is(errs[0], io.ErrUnexpectedEOF) {
+		t.Fatalf("errors %v, want unexpected EOF", errs)
+	}
+
+	res = loadString(t, cut, true)
+	run := res.run(t)
+	// the host being read when the input stopped may be missing lines
+	if got := res.addrs(); !equal(got, []string{"10.0.0.6"}) {
+		t.Errorf("hosts %v, want the complete host only", got)
+	}
+	rec := res.l.Recovered()
+	if len(rec) != 1 || rec[0].Hosts != 1 || len(rec[0].Partial) != 1 || rec[0].Partial[0].Addresses[0].Addr != "10.0.0.5" {
+		t.Errorf("recovered %+v", rec)
+	}
+	if run.Stats.Finished.Exit != "error" || run.Stats.Hosts.Down != 1 {
+		t.Errorf("run stats %+v", run.Stats)
+	}
+}
+
+// TestGnmapUnclosed reads grepable output that lacks nmap's closing comment,
+// as grep open scan.gnmap writes it.
+func TestGnmapUnclosed(t *testing.T) {
+	in := "Host: 10.0.0.5 (web.example.com)\tPorts: 22/open/tcp//ssh///, 80/open/tcp//http///\n" +
+		"Host: 10.0.0.7 ()\tPorts: 443/open/tcp//https///\n"
+	res := loadString(t, in, false)
+	if errs := res.l.Errors(); len(errs) != 0 {
+		t.Fatalf("errors %v", errs)
+	}
+	run := res.run(t)
+	if got := res.addrs(); !equal(got, []string{"10.0.0.5", "10.0.0.7"}) {
+		t.Fatalf("hosts %v", got)
+	}
+	if h := res.host(t, "10.0.0.7"); len(h.Ports) != 1 || h.Status.State != "up" {
+		t.Errorf("10.0.0.7: state %q ports %+v", h.Status.State, h.Ports)
+	}
+	if s := run.Stats.Hosts; s.Up != 2 || s.Total != 2 {
+		t.Errorf("run hosts %+v, want the 2 hosts read", s)
+	}
+	if rec := res.l.Recovered(); len(rec) != 0 {
+		t.Errorf("recovered %+v", rec)
+	}
+}
+
+func TestGnmapMalformed(t *testing.T) {
+	tests := []string{
+		"Host: 10.0.0.5\tStatus: Up\n# Nmap done at Mon Oct  2 10:00:30 2023 -- 1 IP address (1 host up) scanned in 1.00 seconds\n",
+		"Host: 10.0.0.5 ()\tPorts: 22/open/tcp\n# Nmap done at Mon Oct  2 10:00:30 2023 -- 1 IP address (1 host up) scanned in 1.00 seconds\n",
+		"Host: 10.0.0.5 ()\tPorts: ssh/open/tcp//ssh///\n# Nmap done at Mon Oct  2 10:00:30 2023 -- 1 IP address (1 host up) scanned in 1.00 seconds\n",
+	}
+	for _, in := range tests {
+		res := loadString(t, in, false)
+		if len(res.l.Errors()) != 1 || len(res.hosts) != 0 {
+			t.Errorf("%q: got %d hosts, errors %v", in, len(res.hosts), res.l.Errors())
+		}
+	}
+}
@@ -0,0 +1,295 @@
+// Package loader expands command line inputs into scan files and hands every
+// command the hosts they contain, one at a time, with the source file attached.
+package loader
+
+import (
+	"fmt"
+	"io"
+
+	nmap "github.com/Ullaakut/nmap/v3"
+)
+
+// Host is a scanned host along with the input it was read from.
+type Host struct {
+	nmap.Host
+	Source string
+}
+
+// Run is the run level metadata of a single input. The embedded Run never
+// carries hosts, those are handed out one at a time through Handler.Host.
+type Run struct {
+	nmap.Run
+	// ScanInfos lists every scaninfo of the input, there is one per type of
+	// scan run, where the embedded Run only holds one of them.
+	ScanInfos []nmap.ScanInfo
+	Source    string
+}
+
+// FileError records a failure to read or parse a single input.
+type FileError struct {
+	Path string
+	Err  error
+}
+
+func (e *FileError) Error() string {
+	return fmt.Sprintf("%s: %v", e.Path, e.Err)
+}
+
+func (e *FileError) Unwrap() error {
+	return e.Err
+}
+
+// Recovery describes an input that was cut off part way through and read in
+// recover mode.
+type Recovery struct {
+	Path string
+	// Err is the error that was recovered from.
+	Err error
+	// Hosts is the number of complete hosts salvaged.
+	Hosts int
+	// Partial holds the hosts that were only partially written, these are
+	// not handed out.
+	Partial []nmap.Host
+}
+
+// Handler receives the contents of each input as it is parsed. Either field
+// may be left nil.
+type Handler struct {
+	// Host is called for every host of every input, in input order.
+	Host func(h Host)
+	// Run is called once per input, after all of its hosts.
+	Run func(r Run)
+}
+
+// Loader reads scan files. The zero value is ready to use.
+type Loader struct {
+	// Progress, if set, is called with the path of each file before it is parsed.
+	Progress func(path string)
+	// Detected, if set, is called with the format detected for every input,
+	// including files found in a directory that were skipped as Unknown.
+	Detected func(source string, format Format)
+	// Recover salvages the complete hosts of inputs that were cut off, such
+	// as the XML of an nmap run that was killed, instead of rejecting them.
+	Recover bool
+	// Jobs is the number of files parsed concurrently. Zero or one parses
+	// them one after another.
+	Jobs int
+	// Keep, if set, is called for every host before it is handed out, the
+	// hosts it returns false for are dropped. It may trim the host it is
+	// given.
+	Keep func(h *nmap.Host) bool
+
+	errs      []error
+	recovered []Recovery
+}
+
+// Errors returns every error collected so far. Errors for single inputs are
+// of type *FileError.
+func (l *Loader) Errors() []error {
+	return l.errs
+}
+
+// Recovered returns the inputs that were salvaged in recover mode.
+func (l *Loader) Recovered() []Recovery {
+	return l.recovered
+}
+
+// Load expands args and hands the contents of every input to h. The format
+// of each file is detected from its content. Compressed files are read
+// transparently and every member of a tar or zip archive is an input of its
+// own, named archive:member. An input that cannot be read or parsed is
+// skipped and recorded, see Errors.
+//
+// With Jobs above one, files are parsed concurrently, but h is always called
+// from the calling goroutine and sees exactly what a sequential load would, in
+// the same order. Progress is called as each file's turn comes.
+func (l *Loader) Load(args []string, h Handler) {
+	files, errs := expand(args)
+	l.errs = append(l.errs, errs...)
+
+	// a single file gains nothing from a worker, it is streamed as is
+	if l.Jobs <= 1 || len(files) <= 1 {
+		for _, f := range files {
+			if l.Progress != nil {
+				l.Progress(f.path)
+			}
+			l.read(f, func(ev event) {
+				l.dispatch(ev, h)
+			})
+		}
+		return
+	}
+
+	// every file gets a queue that its worker fills in, the queues are then
+	// drained strictly in input order. The file at the head is streamed
+	// straight to h, the files behind it are read ahead until their queue is
+	// full, so at most Jobs files of readAhead events each are held in
+	// memory. A worker slot is only freed once its queue has been drained.
+	queues := make([]chan event, len(files))
+	for i := range queues {
+		queues[i] = make(chan event, readAhead)
+	}
+	sem := make(chan struct{}, l.Jobs)
+	go func() {
+		for i, f := range files {
+			sem <- struct{}{}
+			go func(i int, f file) {
+				l.read(f, func(ev event) {
+					queues[i] <- ev
+				})
+				close(queues[i])
+			}(i, f)
+		}
+	}()
+
+	for i, f := range files {
+		if l.Progress != nil {
+			l.Progress(f.path)
+		}
+		for ev := range queues[i] {
+			l.dispatch(ev, h)
+		}
+		<-sem
+	}
+}
+
+// readAhead is the number of events buffered for a file read ahead of its
+// turn.
+const readAhead = 1024
+
+// LoadInput is Load for a single input that is already open, such as
+// standard input whose format was looked at before it is read. source names
+// the input in errors and in the hosts handed out.
+func (l *Loader) LoadInput(in *Input, source string, h Handler) {
+	l.readInput("", source, in, false, func(ev event) {
+		l.dispatch(ev, h)
+	})
+}
+
+// Each is shorthand for Load when only the hosts are of interest.
+func (l *Loader) Each(args []string, fn func(h Host)) {
+	l.Load(args, Handler{Host: fn})
+}
+
+// event is a step in reading a file: an input starting, one of its hosts or
+// its outcome. Events are queued for files read ahead of their turn.
+type event struct {
+	source string
+	format Format
+	host   *nmap.Host
+	// result is set once the input is done.
+	result *result
+}
+
+// result is the outcome of reading a single input.
+type result struct {
+	run      Run
+	recovery *Recovery
+	err      error
+}
+
+// dispatch hands a single event to h, recording the outcome of inputs.
+func (l *Loader) dispatch(ev event, h Handler) {
+	switch {
+	case ev.host != nil:
+		if l.Keep != nil && !l.Keep(ev.host) {
+			return
+		}
+		if h.Host != nil {
+			h.Host(Host{Host: *ev.host, Source: ev.source})
+		}
+	case ev.result != nil:
+		res := ev.result
+		if res.err != nil {
+			l.errs = append(l.errs, &FileError{Path: ev.source, Err: res.err})
+			return
+		}
+		if res.recovery != nil {
+			l.recovered = append(l.recovered, *res.recovery)
+		}
+		if h.Run != nil {
+			h.Run(res.run)
+		}
+	default:
+		if l.Detected != nil {
+			l.Detected(ev.source, ev.format)
+		}
+	}
+}
+
+// read reads a single file, passing what it finds to emit as it goes. Hosts
+// are decoded one at a time, so when an input turns out to be broken part way
+// through, the hosts before the error have already been emitted. read is
+// safe to call from several goroutines at once.
+func (l *Loader) read(f file, emit func(ev event)) {
+	in, err := Open(f.path)
+	if err != nil {
+		emit(event{source: f.path, result: &result{err: err}})
+		return
+	}
+	defer in.Close()
+	l.readInput(f.path, f.path, in, f.walked, emit)
+}
+
+// readInput reads an opened input, which is either a scan or an archive of
+// them. path is the file on disk the input came from.
+func (l *Loader) readInput(path, source string, in *Input, quiet bool, emit func(ev event)) {
+	emit(event{source: source, format: in.Format})
+
+	if in.Format == Tar || in.Format == Zip {
+		err := members(path, in, func(m member) error {
+			rc, err := m.open()
+			if err != nil {
+				emit(event{source: source + ":" + m.name, result: &result{err: err}})
+				return nil
+			}
+			defer rc.Close()
+			sub, err := NewInput(rc)
+			if err != nil {
+				emit(event{source: source + ":" + m.name, result: &result{err: err}})
+				return nil
+			}
+			defer sub.Close()
+			l.readInput("", source+":"+m.name, sub, true, emit)
+			return nil
+		})
+		if err != nil {
+			emit(event{source: source, result: &result{err: err}})
+		}
+		return
+	}
+
+	dec := newDecoder(in.Format, in.Reader, l.Recover)
+	if dec == nil {
+		if quiet {
+			return
+		}
+		err := fmt.Errorf("not a recognized scan format")
+		if in.Format != Unknown {
+			err = fmt.Errorf("%s input holds no scan results", in.Format)
+		}
+		emit(event{source: source, result: &result{err: err}})
+		return
+	}
+
+	n := 0
+	for {
+		hst, err := dec.Next()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			emit(event{source: source, result: &result{err: err}})
+			return
+		}
+		n++
+		emit(event{source: source, host: &hst})
+	}
+
+	run := dec.run()
+	res := &result{run: Run{Run: run, ScanInfos: scanInfos(dec, run), Source: source}}
+	if partial, err := dec.truncated(); err != nil {
+		res.recovery = &Recovery{Path: source, Err: err, Hosts: n, Partial: partial}
+	}
+	emit(event{source: source, result: res})
+}
@@ -0,0 +1,145 @@
+package loader
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	nmap "github.com/Ullaakut/nmap/v3"
+)
+
+func TestMain(m *testing.M) {
+	// name ports from the built in table, whether or not nmap is installed
+	NmapServices = filepath.Join("testdata", "no-nmap-services")
+	os.Exit(m.Run())
+}
+
+// loaded is what a loader handed out for a single input.
+type loaded struct {
+	hosts []Host
+	runs  []Run
+	l     *Loader
+}
+
+// loadFile reads a fixture from testdata.
+func loadFile(t *testing.T, name string, recover bool) loaded {
+	t.Helper()
+	res := loaded{l: &Loader{Recover: recover}}
+	res.l.Load([]string{filepath.Join("testdata", name)}, res.handler())
+	return res
+}
+
+// loadString reads an input held in a string, as standard input would be.
+func loadString(t *testing.T, content string, recover bool) loaded {
+	t.Helper()
+	in, err := NewInput(strings.NewReader(content))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer in.Close()
+	res := loaded{l: &Loader{Recover: recover}}
+	res.l.LoadInput(in, Stdin, res.handler())
+	return res
+}
+
+func (res *loaded) handler() Handler {
+	return Handler{
+		Host: func(h Host) { res.hosts = append(res.hosts, h) },
+		Run:  func(r Run) { res.runs = append(res.runs, r) },
+	}
+}
+
+// run returns the only run read, failing the test on any error.
+func (res loaded) run(t *testing.T) Run {
+	t.Helper()
+	if errs := res.l.Errors(); len(errs) > 0 {
+		t.Fatalf("unexpected errors: %v", errs)
+	}
+	if len(res.runs) != 1 {
+		t.Fatalf("got %d runs, want 1", len(res.runs))
+	}
+	return res.runs[0]
+}
+
+// host returns the host read with address addr.
+func (res loaded) host(t *testing.T, addr string) nmap.Host {
+	t.Helper()
+	for _, h := range res.hosts {
+		for _, a := range h.Addresses {
+			if a.Addr == addr {
+				return h.Host
+			}
+		}
+	}
+	t.Fatalf("no host %s", addr)
+	return nmap.Host{}
+}
+
+// addrs returns the first address of every host read, in order.
+func (res loaded) addrs() []string {
+	var out []string
+	for _, h := range res.hosts {
+		out = append(out, h.Addresses[0].Addr)
+	}
+	return out
+}
+
+// port returns the port id/proto of h.
+func port(t *testing.T, h nmap.Host, id uint16, proto string) nmap.Port {
+	t.Helper()
+	for _, p := range h.Ports {
+		if p.ID == id && p.Protocol == proto {
+			return p
+		}
+	}
+	t.Fatalf("%s has no port %d/%s", h.Addresses[0].Addr, id, proto)
+	return nmap.Port{}
+}
+
+// script returns the output of the script id on p.
+func script(p nmap.Port, id string) string {
+	for _, s := range p.Scripts {
+		if s.ID == id {
+			return s.Output
+		}
+	}
+	return ""
+}
+
+func equal(a, b []string) bool {
+	if len(a) != len(b) {
+		return false
+	}
+	for i := range a {
+		if a[i] != b[i] {
+			return false
+		}
+	}
+	return true
+}
+
+func TestDetectFixtures(t *testing.T) {
+	tests := []struct {
+		file string
+		want Format
+	}{
+		{"scan.gnmap", Gnmap},
+		{"masscan.xml", MasscanXML},
+		{"masscan.json", MasscanJSON},
+		{"masscan.list", MasscanList},
+		{"naabu.json", NaabuJSON},
+		{"rustscan.txt", Rustscan},
+		{"scan.nessus", Nessus},
+	}
+	for _, tt := range tests {
+		in, err := Open(filepath.Join("testdata", tt.file))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if in.Format != tt.want {
+			t.Errorf("%s: detected %s, want %s", tt.file, in.Format, tt.want)
+		}
+		in.Close()
+	}
+}
@@ -0,0 +1,227 @@
+package loader
+
+import (
+	"encoding/json"
+	"encoding/xml"
+	"fmt"
+	"io"
+	"regexp"
+	"strconv"
+	"strings"
+
+	nmap "github.com/Ullaakut/nmap/v3"
+)
+
+// newMasscanDecoder returns a decoder for masscan output in its XML, JSON or
+// list (-oL) format, read by load.
+func newMasscanDecoder(load func(d *recordDecoder) error, recover bool) *recordDecoder {
+	d := newRecordDecoder("masscan", "syn-ack", load, recover)
+	d.meta.ScanInfo = nmap.ScanInfo{Type: "syn", Protocol: "tcp"}
+	return d
+}
+
+// masscanBanner maps a banner masscan grabbed onto the port. Banners become
+// script output under the id of the closest nmap script, and banners that
+// name a protocol also set the service.
+func masscanBanner(p *nmap.Port, service, banner string) {
+	id := "banner"
+	switch service {
+	case "title":
+		id = "http-title"
+	case "http.server":
+		id = "http-server-header"
+	case "X509", "X509CA":
+		id = "ssl-cert"
+	case "ssl":
+		id = "ssl-cert"
+		p.Service.Tunnel = "ssl"
+	default:
+		if p.Service.Name == "" {
+			p.Service.Name = service
+			p.Service.Method = "probed"
+			p.Service.Confidence = 10
+		}
+	}
+	p.Scripts = append(p.Scripts, nmap.Script{ID: id, Output: banner})
+}
+
+// loadMasscanXML reads masscan -oX output, which borrows the nmap element
+// names but writes a host per port and keeps banners in an attribute.
+func loadMasscanXML(r io.Reader) func(d *recordDecoder) error {
+	return func(d *recordDecoder) error {
+		type host struct {
+			EndTime int64        `xml:"endtime,attr"`
+			Address nmap.Address `xml:"address"`
+			Ports   []struct {
+				Protocol string     `xml:"protocol,attr"`
+				ID       uint16     `xml:"portid,attr"`
+				State    nmap.State `xml:"state"`
+				Service  struct {
+					Name   string `xml:"name,attr"`
+					Banner string `xml:"banner,attr"`
+				} `xml:"service"`
+			} `xml:"ports>port"`
+		}
+
+		dec := xml.NewDecoder(r)
+		for {
+			tok, err := dec.Token()
+			if err == io.EOF {
+				return io.ErrUnexpectedEOF
+			}
+			if err != nil {
+				return err
+			}
+
+			switch t := tok.(type) {
+			case xml.StartElement:
+				switch t.Name.Local {
+				case "nmaprun":
+					for _, a := range t.Attr {
+						switch a.Name.Local {
+						case "version":
+							d.meta.Version = a.Value
+						case "xmloutputversion":
+							d.meta.XMLOutputVersion = a.Value
+						case "start":
+							d.meta.Start.ParseTime(a.Value)
+						}
+					}
+				case "scaninfo":
+					if err := dec.DecodeElement(&d.meta.ScanInfo, &t); err != nil {
+						return err
+					}
+				case "runstats":
+					if err := dec.DecodeElement(&d.meta.Stats, &t); err != nil {
+						return err
+					}
+				case "host":
+					var h host
+					if err := dec.DecodeElement(&h, &t); err != nil {
+						d.cutoff(h.Address.Addr)
+						return err
+					}
+					for _, hp := range h.Ports {
+						p := d.port(h.Address.Addr, hp.Protocol, hp.ID, h.EndTime)
+						if hp.Service.Banner != "" || hp.Service.Name != "" {
+							masscanBanner(p, hp.Service.Name, hp.Service.Banner)
+						} else {
+							p.State = hp.State
+						}
+					}
+				}
+			case xml.EndElement:
+				if t.Name.Local == "nmaprun" {
+					return nil
+				}
+			}
+		}
+	}
+}
+
+// masscanJSONRecord is a single record of masscan -oJ or --ndjson output.
+type masscanJSONRecord struct {
+	IP        string          `json:"ip"`
+	Timestamp json.RawMessage `json:"timestamp"`
+	Ports     []struct {
+		Port    uint16  `json:"port"`
+		Proto   string  `json:"proto"`
+		Status  string  `json:"status"`
+		Reason  string  `json:"reason"`
+		TTL     float32 `json:"ttl"`
+		Service *struct {
+			Name   string `json:"name"`
+			Banner string `json:"banner"`
+		} `json:"service"`
+	} `json:"ports"`
+}
+
+// jsonIPField matches the "ip" field of a JSON record.
+var jsonIPField = regexp.MustCompile(`"ip"\s*:\s*"([^"]+)"`)
+
+// jsonIP returns the address of a JSON record that was cut off, which does
+// not decode.
+func jsonIP(line string) string {
+	if m := jsonIPField.FindStringSubmatch(line); m != nil {
+		return m[1]
+	}
+	return ""
+}
+
+// loadMasscanJSON reads masscan -oJ output. masscan writes a record per line
+// wrapped in a JSON array, and older versions leave a trailing comma and a
+// bogus "{finished: 1}" record at the end, so the array is not decoded as a
+// whole but line by line, which also covers --ndjson.
+func loadMasscanJSON(r io.Reader) func(d *recordDecoder) error {
+	return func(d *recordDecoder) error {
+		return eachLine(r, func(line string, complete bool) error {
+			line = strings.Trim(strings.TrimSpace(line), ",")
+			if line == "" || line == "[" || line == "]" || strings.HasPrefix(line, "{finished") {
+				return nil
+			}
+
+			var rec masscanJSONRecord
+			if err := json.Unmarshal([]byte(line), &rec); err != nil {
+				if !complete {
+					d.cutoff(jsonIP(line))
+					return io.ErrUnexpectedEOF
+				}
+				return err
+			}
+
+			ts, _ := strconv.ParseInt(strings.Trim(string(rec.Timestamp), `"`), 10, 64)
+			for _, rp := range rec.Ports {
+				p := d.port(rec.IP, rp.Proto, rp.Port, ts)
+				if rp.Service != nil {
+					masscanBanner(p, rp.Service.Name, rp.Service.Banner)
+					continue
+				}
+				p.State = nmap.State{State: rp.Status, Reason: rp.Reason, ReasonTTL: rp.TTL}
+			}
+			return nil
+		})
+	}
+}
+
+// loadMasscanList reads masscan -oL output, lines of
+//
+//	open tcp 80 10.0.0.1 1490242774
+//	banner tcp 80 10.0.0.1 1490242774 http HTTP/1.0 200 OK
+func loadMasscanList(r io.Reader) func(d *recordDecoder) error {
+	return func(d *recordDecoder) error {
+		return eachLine(r, func(line string, complete bool) error {
+			if strings.HasPrefix(line, "#") || strings.TrimSpace(line) == "" {
+				return nil
+			}
+			f := strings.SplitN(line, " ", 7)
+			var id uint64
+			err := fmt.Errorf("malformed masscan line %q", line)
+			if len(f) >= 5 {
+				if id, err = strconv.ParseUint(f[2], 10, 16); err != nil {
+					err = fmt.Errorf("malformed port number %q", f[2])
+				}
+			}
+			if err != nil {
+				// a last line without a newline is only cut off if it
+				// does not parse
+				if !complete {
+					if len(f) > 3 {
+						d.cutoff(f[3])
+					}
+					return io.ErrUnexpectedEOF
+				}
+				return err
+			}
+			ts, _ := strconv.ParseInt(f[4], 10, 64)
+
+			p := d.port(f[3], f[1], uint16(id), ts)
+			switch {
+			case f[0] == "banner" && len(f) == 7:
+				masscanBanner(p, f[5], f[6])
+			case f[0] != "banner":
+				p.State.State = f[0]
+			}
+			return nil
+		})
+	}
+}
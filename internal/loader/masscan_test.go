@@ -0,0 +1,132 @@
+package loader
+
+import (
+	"errors"
+	"io"
+	"testing"
+	"time"
+)
+
+// TestMasscan reads the same scan written in each of the masscan formats.
+func TestMasscan(t *testing.T) {
+	tests := []struct {
+		file     string
+		start    int64
+		finished int64
+	}{
+		// only the XML carries the start of the run and its runstats
+		{"masscan.xml", 1696240800, 1696240815},
+		{"masscan.json", 1696240805, 1696240812},
+		{"masscan.list", 1696240805, 1696240812},
+	}
+	for _, tt := range tests {
+		t.Run(tt.file, func(t *testing.T) {
+			res := loadFile(t, tt.file, false)
+			run := res.run(t)
+			if run.Scanner != "masscan" || run.ScanInfo.Type != "syn" {
+				t.Errorf("run scanner %q scaninfo %+v", run.Scanner, run.ScanInfo)
+			}
+			if got := time.Time(run.Start).Unix(); got != tt.start {
+				t.Errorf("run start %d, want %d", got, tt.start)
+			}
+			if got := time.Time(run.Stats.Finished.Time).Unix(); got != tt.finished {
+				t.Errorf("run finished %d, want %d", got, tt.finished)
+			}
+			if s := run.Stats.Hosts; s.Up != 2 || s.Total != 2 {
+				t.Errorf("run hosts %+v", s)
+			}
+
+			// a record per port is folded into a host per address, in the
+			// order the addresses were first seen
+			if got := res.addrs(); !equal(got, []string{"10.0.0.5", "10.0.0.7"}) {
+				t.Fatalf("hosts %v", got)
+			}
+			h := res.host(t, "10.0.0.5")
+			if len(h.Ports) != 2 {
+				t.Fatalf("10.0.0.5: got %d ports, want 2", len(h.Ports))
+			}
+			if start, end := time.Time(h.StartTime).Unix(), time.Time(h.EndTime).Unix(); start != 1696240805 || end != 1696240811 {
+				t.Errorf("10.0.0.5: start %d end %d", start, end)
+			}
+
+			ports := []struct {
+				addr   string
+				id     uint16
+				name   string
+				method string
+				tunnel string
+				script string
+				output string
+			}{
+				// banners become the output of the closest nmap script
+				{"10.0.0.5", 80, "http", "table", "", "http-title", "Welcome to nginx!"},
+				{"10.0.0.5", 443, "https", "table", "ssl", "ssl-cert", "TLS/1.1 cipher:0xc013, web.example.com"},
+				// a banner that names a protocol names the service
+				{"10.0.0.7", 22, "ssh", "probed", "", "banner", "SSH-2.0-OpenSSH_8.9p1 Ubuntu-3"},
+			}
+			for _, pt := range ports {
+				p := port(t, res.host(t, pt.addr), pt.id, "tcp")
+				s := p.Service
+				if p.State.State != "open" || s.Name != pt.name || s.Method != pt.method || s.Tunnel != pt.tunnel {
+					t.Errorf("%s %d: state %q service %+v", pt.addr, pt.id, p.State.State, s)
+				}
+				if got := script(p, pt.script); got != pt.output {
+					t.Errorf("%s %d: script %s output %q, want %q", pt.addr, pt.id, pt.script, got, pt.output)
+				}
+			}
+		})
+	}
+}
+
+func TestMasscanTruncated(t *testing.T) {
+	tests := []struct {
+		name string
+		in   string
+	}{
+		{"json", "[\n{   \"ip\": \"10.0.0.5\",   \"timestamp\": \"1696240805\", \"ports\": [ {\"port\": 443, \"proto\": \"tcp\", \"status\": \"open\"} ] }\n,\n{   \"ip\": \"10.0.0.7\",   \"timestamp\": \"16962"},
+		{"list", "#masscan\nopen tcp 443 10.0.0.5 1696240805\nopen tcp 22 10.0.0.7"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			res := loadString(t, tt.in, false)
+			if errs := res.l.Errors(); len(errs) != 1 || !errors.Is(errs[0], io.ErrUnexpectedEOF) {
+				t.Fatalf("errors %v, want unexpected EOF", errs)
+			}
+
+			res = loadString(t, tt.in, true)
+			run := res.run(t)
+			if got := res.addrs(); !equal(got, []string{"10.0.0.5"}) {
+				t.Errorf("hosts %v", got)
+			}
+			rec := res.l.Recovered()
+			if len(rec) != 1 || len(rec[0].Partial) != 1 || rec[0].Partial[0].Addresses[0].Addr != "10.0.0.7" {
+				t.Errorf("recovered %+v", rec)
+			}
+			if run.Stats.Finished.Exit != "error" {
+				t.Errorf("run finished %+v", run.Stats.Finished)
+			}
+		})
+	}
+}
+
+// TestMasscanNoNewline reads a complete last line that lacks a newline.
+func TestMasscanNoNewline(t *testing.T) {
+	tests := []struct {
+		name string
+		in   string
+	}{
+		{"json", "[\n{   \"ip\": \"10.0.0.5\",   \"timestamp\": \"1696240805\", \"ports\": [ {\"port\": 443, \"proto\": \"tcp\", \"status\": \"open\"} ] }\n,\n{   \"ip\": \"10.0.0.7\",   \"timestamp\": \"1696240806\", \"ports\": [ {\"port\": 22, \"proto\": \"tcp\", \"status\": \"open\"} ] }"},
+		{"list", "#masscan\nopen tcp 443 10.0.0.5 1696240805\nopen tcp 22 10.0.0.7 1696240806"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			res := loadString(t, tt.in, false)
+			if errs := res.l.Errors(); len(errs) != 0 {
+				t.Fatalf("errors %v", errs)
+			}
+			if got := res.addrs(); !equal(got, []string{"10.0.0.5", "10.0.0.7"}) {
+				t.Errorf("hosts %v", got)
+			}
+		})
+	}
+}
@@ -0,0 +1,91 @@
+package loader
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"net"
+	"strings"
+	"time"
+
+	nmap "github.com/Ullaakut/nmap/v3"
+)
+
+// naabuRecord is a single line of naabu -json output. Older releases write
+// the port as a number, newer ones as an object.
+type naabuRecord struct {
+	Host      string          `json:"host"`
+	IP        string          `json:"ip"`
+	Port      json.RawMessage `json:"port"`
+	Protocol  string          `json:"protocol"`
+	TLS       bool            `json:"tls"`
+	Timestamp string          `json:"timestamp"`
+}
+
+// newNaabuDecoder returns a decoder for naabu -json output. The host naabu
+// was asked to scan is kept as a hostname, so vhost URLs can be built for it.
+func newNaabuDecoder(r io.Reader, recover bool) *recordDecoder {
+	d := newRecordDecoder("naabu", "naabu", loadNaabu(r), recover)
+	d.meta.ScanInfo = nmap.ScanInfo{Type: "syn", Protocol: "tcp"}
+	return d
+}
+
+func loadNaabu(r io.Reader) func(d *recordDecoder) error {
+	return func(d *recordDecoder) error {
+		return eachLine(r, func(line string, complete bool) error {
+			if strings.TrimSpace(line) == "" {
+				return nil
+			}
+
+			var rec naabuRecord
+			if err := json.Unmarshal([]byte(line), &rec); err != nil {
+				if !complete {
+					d.cutoff(jsonIP(line))
+					return io.ErrUnexpectedEOF
+				}
+				return err
+			}
+
+			var port struct {
+				Port     uint16 `json:"Port"`
+				Protocol int    `json:"Protocol"`
+				TLS      bool   `json:"TLS"`
+			}
+			if err := json.Unmarshal(rec.Port, &port.Port); err != nil {
+				if err := json.Unmarshal(rec.Port, &port); err != nil {
+					return err
+				}
+			}
+
+			// a record of a host naabu was given as an address may only
+			// name it as the host, never put a DNS name in its place
+			ip := rec.IP
+			if ip == "" && net.ParseIP(rec.Host) != nil {
+				ip = rec.Host
+			}
+			if ip == "" {
+				return fmt.Errorf("naabu record for %q has no IP address", rec.Host)
+			}
+			proto := strings.ToLower(rec.Protocol)
+			if proto == "" {
+				// naabu encodes the protocol of the object form as 0 for tcp, 1 for udp
+				proto = "tcp"
+				if port.Protocol == 1 {
+					proto = "udp"
+				}
+			}
+
+			var ts int64
+			if t, err := time.Parse(time.RFC3339Nano, rec.Timestamp); err == nil {
+				ts = t.Unix()
+			}
+
+			p := d.port(ip, proto, port.Port, ts)
+			if rec.TLS || port.TLS {
+				p.Service.Tunnel = "ssl"
+			}
+			d.hostname(ip, rec.Host)
+			return nil
+		})
+	}
+}
@@ -0,0 +1,95 @@
+package loader
+
+import (
+	"errors"
+	"io"
+	"testing"
+	"time"
+)
+
+func TestNaabu(t *testing.T) {
+	res := loadFile(t, "naabu.json", false)
+	run := res.run(t)
+	if run.Scanner != "naabu" {
+		t.Errorf("run scanner %q", run.Scanner)
+	}
+	if got := time.Time(run.Start).Unix(); got != 1696240805 {
+		t.Errorf("run start %d", got)
+	}
+	if got := time.Time(run.Stats.Finished.Time).Unix(); got != 1696240809 {
+		t.Errorf("run finished %d", got)
+	}
+
+	if got := res.addrs(); !equal(got, []string{"10.0.0.5", "10.0.0.7"}) {
+		t.Fatalf("hosts %v", got)
+	}
+
+	// the hosts naabu was asked to scan are kept as hostnames, an address
+	// is not
+	h := res.host(t, "10.0.0.5")
+	var names []string
+	for _, hn := range h.Hostnames {
+		names = append(names, hn.Name)
+	}
+	if !equal(names, []string{"web.example.com", "api.example.com"}) {
+		t.Errorf("10.0.0.5 hostnames %v", names)
+	}
+	if hn := res.host(t, "10.0.0.7").Hostnames; len(hn) != 0 {
+		t.Errorf("10.0.0.7 hostnames %v", hn)
+	}
+
+	tests := []struct {
+		addr   string
+		id     uint16
+		proto  string
+		name   string
+		tunnel string
+	}{
+		{"10.0.0.5", 80, "tcp", "http", ""},
+		{"10.0.0.5", 443, "tcp", "https", "ssl"},
+		// newer releases write the port as an object
+		{"10.0.0.5", 8443, "tcp", "https-alt", "ssl"},
+		{"10.0.0.7", 53, "udp", "domain", ""},
+		{"10.0.0.7", 22, "tcp", "ssh", ""},
+	}
+	for _, tt := range tests {
+		p := port(t, res.host(t, tt.addr), tt.id, tt.proto)
+		if p.State.State != "open" || p.Service.Name != tt.name || p.Service.Tunnel != tt.tunnel {
+			t.Errorf("%s %d/%s: state %q service %+v", tt.addr, tt.id, tt.proto, p.State.State, p.Service)
+		}
+	}
+}
+
+func TestNaabuTruncated(t *testing.T) {
+	in := `{"host":"10.0.0.5","ip":"10.0.0.5","port":80,"protocol":"tcp","timestamp":"2023-10-02T10:00:05Z"}` + "\n" +
+		`{"host":"10.0.0.7","ip":"10.0.0.7","po`
+
+	res := loadString(t, in, false)
+	if errs := res.l.Errors(); len(errs) != 1 || !errors.Is(errs[0], io.ErrUnexpectedEOF) {
+		t.Fatalf("errors %v, want unexpected EOF", errs)
+	}
+
+	res = loadString(t, in, true)
+	res.run(t)
+	if got := res.addrs(); !equal(got, []string{"10.0.0.5"}) {
+		t.Errorf("hosts %v", got)
+	}
+	rec := res.l.Recovered()
+	if len(rec) != 1 || len(rec[0].Partial) != 1 || rec[0].Partial[0].Addresses[0].Addr != "10.0.0.7" {
+		t.Errorf("recovered %+v", rec)
+	}
+}
+
+// TestNaabuNoIP takes the address of a record without an ip from its host
+// only when that is an address.
+func TestNaabuNoIP(t *testing.T) {
+	res := loadString(t, `{"host":"10.0.0.5","port":80,"protocol":"tcp"}`+"\n", false)
+	if got := res.addrs(); len(res.l.Errors()) != 0 || !equal(got, []string{"10.0.0.5"}) {
+		t.Errorf("hosts %v, errors %v", got, res.l.Errors())
+	}
+
+	res = loadString(t, `{"host":"web.example.com","port":80,"protocol":"tcp"}`+"\n", false)
+	if errs := res.l.Errors(); len(errs) != 1 || len(res.hosts) != 0 {
+		t.Errorf("got %d hosts, errors %v", len(res.hosts), errs)
+	}
+}
@@ -0,0 +1,271 @@
+package loader
+
+import (
+	"encoding/xml"
+	"io"
+	"strconv"
+	"strings"
+	"time"
+
+	nmap "github.com/Ullaakut/nmap/v3"
+)
+
+// nessusHost is a <ReportHost> element of a .nessus v2 file.
+type nessusHost struct {
+	Name       string `xml:"name,attr"`
+	Properties []struct {
+		Name  string `xml:"name,attr"`
+		Value string `xml:",chardata"`
+	} `xml:"HostProperties>tag"`
+	Items []struct {
+		Port       uint16 `xml:"port,attr"`
+		Service    string `xml:"svc_name,attr"`
+		Protocol   string `xml:"protocol,attr"`
+		PluginName string `xml:"pluginName,attr"`
+		Output     string `xml:"plugin_output"`
+	} `xml:"ReportItem"`
+}
+
+// nessusServices maps Nessus svc_name values onto the names nmap uses.
+var nessusServices = map[string]string{
+	"www":             "http",
+	"cifs":            "microsoft-ds",
+	"smb":             "microsoft-ds",
+	"msrdp":           "ms-wbt-server",
+	"dce-rpc":         "msrpc",
+	"epmap":           "msrpc",
+	"dns":             "domain",
+	"mssql":           "ms-sql-s",
+	"ldaps":           "ldapssl",
+	"general":         "",
+	"unknown":         "",
+	"icmp":            "",
+	"unknown_service": "",
+}
+
+// nessusDecoder reads the hosts of a Nessus v2 (.nessus) export, one
+// <ReportHost> at a time. Open ports are taken from the ports the report
+// items were raised against, host details from the host properties.
+type nessusDecoder struct {
+	recover bool
+
+	d       *xml.Decoder
+	meta    nmap.Run
+	started bool
+	done    bool
+	failed  error
+	partial []nmap.Host
+	up      int
+}
+
+func newNessusDecoder(r io.Reader, recover bool) *nessusDecoder {
+	return &nessusDecoder{
+		recover: recover,
+		d:       xml.NewDecoder(r),
+		meta: nmap.Run{
+			Scanner:          "nessus",
+			XMLOutputVersion: "1.05",
+			ScanInfo:         nmap.ScanInfo{Type: "syn", Protocol: "tcp"},
+		},
+	}
+}
+
+func (d *nessusDecoder) Next() (nmap.Host, error) {
+	for !d.done {
+		tok, err := d.d.Token()
+		if err == io.EOF {
+			err = io.ErrUnexpectedEOF
+		}
+		if err != nil {
+			return nmap.Host{}, d.fail(err, "")
+		}
+
+		switch t := tok.(type) {
+		case xml.StartElement:
+			switch t.Name.Local {
+			case "NessusClientData_v2":
+				d.started = true
+			case "Report":
+				for _, a := range t.Attr {
+					if a.Name.Local == "name" {
+						d.meta.Args = "nessus report: " + a.Value
+					}
+				}
+			case "ReportHost":
+				var nh nessusHost
+				if err := d.d.DecodeElement(&nh, &t); err != nil {
+					return nmap.Host{}, d.fail(err, nh.Name)
+				}
+				h := nessusToHost(&nh)
+				d.seen(&h)
+				return h, nil
+			case "Policy":
+				if err := d.d.Skip(); err != nil {
+					return nmap.Host{}, d.fail(err, "")
+				}
+			}
+		case xml.EndElement:
+			if t.Name.Local == "NessusClientData_v2" {
+				d.done = true
+			}
+		}
+	}
+
+	if d.failed != nil && !d.recover {
+		return nmap.Host{}, d.failed
+	}
+	d.finish()
+	return nmap.Host{}, io.EOF
+}
+
+func (d *nessusDecoder) run() nmap.Run {
+	return d.meta
+}
+
+func (d *nessusDecoder) truncated() ([]nmap.Host, error) {
+	if d.recover {
+		return d.partial, d.failed
+	}
+	return nil, nil
+}
+
+// fail stops reading on err. In recover mode the host being read, if any, is
+// recorded as partial and io.EOF is returned instead of err.
+func (d *nessusDecoder) fail(err error, partial string) error {
+	d.done = true
+	d.failed = err
+	if !d.recover || !d.started {
+		return err
+	}
+	if partial != "" {
+		d.partial = append(d.partial, nmap.Host{Addresses: []nmap.Address{{Addr: partial, AddrType: addrType(partial)}}})
+	}
+	d.finish()
+	return io.EOF
+}
+
+// seen keeps track of the hosts handed out, for the run level metadata.
+func (d *nessusDecoder) seen(h *nmap.Host) {
+	d.up++
+	r := &d.meta
+	if !time.Time(h.StartTime).IsZero() && (time.Time(r.Start).IsZero() || time.Time(h.StartTime).Before(time.Time(r.Start))) {
+		r.Start = h.StartTime
+	}
+	if time.Time(h.EndTime).After(time.Time(r.Stats.Finished.Time)) {
+		r.Stats.Finished.Time = h.EndTime
+	}
+}
+
+// finish fills in the run level metadata from the hosts seen.
+func (d *nessusDecoder) finish() {
+	r := &d.meta
+	if !time.Time(r.Start).IsZero() {
+		r.StartStr = time.Time(r.Start).Format(time.ANSIC)
+	}
+	f := &r.Stats.Finished
+	if !time.Time(f.Time).IsZero() {
+		f.TimeStr = time.Time(f.Time).Format(time.ANSIC)
+		if !time.Time(r.Start).IsZero() {
+			f.Elapsed = float32(time.Time(f.Time).Sub(time.Time(r.Start)).Seconds())
+		}
+	}
+	f.Exit = "success"
+	if d.failed != nil {
+		f.Exit = "error"
+		f.ErrorMsg = d.failed.Error()
+	}
+	r.Stats.Hosts = nmap.HostStats{Up: d.up, Total: d.up}
+}
+
+// nessusToHost maps a Nessus report host onto the nmap host model.
+func nessusToHost(nh *nessusHost) nmap.Host {
+	h := nmap.Host{Status: nmap.Status{State: "up", Reason: "nessus"}}
+
+	props := make(map[string]string)
+	for _, p := range nh.Properties {
+		props[p.Name] = strings.TrimSpace(p.Value)
+	}
+
+	ip := props["host-ip"]
+	if ip == "" {
+		ip = nh.Name
+	}
+	h.Addresses = append(h.Addresses, nmap.Address{Addr: ip, AddrType: addrType(ip)})
+	for _, mac := range strings.Fields(props["mac-address"]) {
+		h.Addresses = append(h.Addresses, nmap.Address{Addr: strings.ToUpper(mac), AddrType: "mac"})
+	}
+
+	if fqdn := props["host-fqdn"]; fqdn != "" {
+		h.Hostnames = append(h.Hostnames, nmap.Hostname{Name: fqdn, Type: "PTR"})
+	}
+	if nh.Name != ip && nh.Name != props["host-fqdn"] {
+		h.Hostnames = append(h.Hostnames, nmap.Hostname{Name: nh.Name, Type: "user"})
+	}
+	if nb := props["netbios-name"]; nb != "" {
+		h.Hostnames = append(h.Hostnames, nmap.Hostname{Name: nb, Type: "netbios"})
+	}
+
+	for _, name := range strings.Split(props["operating-system"], "\n") {
+		if name = strings.TrimSpace(name); name != "" {
+			h.OS.Matches = append(h.OS.Matches, nmap.OSMatch{Name: name})
+		}
+	}
+
+	h.StartTime = nessusTime(props["HOST_START_TIMESTAMP"], props["HOST_START"])
+	h.EndTime = nessusTime(props["HOST_END_TIMESTAMP"], props["HOST_END"])
+
+	// a port shows up once per plugin raised against it, the first item names
+	// the service and any TLS finding marks it as tunnelled
+	index := make(map[string]int)
+	for _, it := range nh.Items {
+		if it.Port == 0 {
+			continue
+		}
+		key := strconv.Itoa(int(it.Port)) + "/" + it.Protocol
+		i, ok := index[key]
+		if !ok {
+			svc := strings.TrimSuffix(it.Service, "?")
+			name, mapped := nessusServices[svc]
+			if !mapped {
+				name = svc
+			}
+			method, conf := "probed", 10
+			if name == "" {
+				name, method, conf = ServiceName(key), "table", 3
+			}
+			i = len(h.Ports)
+			index[key] = i
+			h.Ports = append(h.Ports, nmap.Port{
+				ID:       it.Port,
+				Protocol: it.Protocol,
+				State:    nmap.State{State: "open", Reason: "nessus"},
+				Service:  nmap.Service{Name: name, Method: method, Confidence: conf},
+			})
+		}
+		if isNessusTLS(it.PluginName, it.Output) {
+			h.Ports[i].Service.Tunnel = "ssl"
+		}
+	}
+	return h
+}
+
+// isNessusTLS reports whether a plugin finding shows the port speaks TLS.
+func isNessusTLS(plugin, output string) bool {
+	if strings.HasPrefix(plugin, "SSL") || strings.HasPrefix(plugin, "TLS") {
+		return true
+	}
+	return strings.HasPrefix(plugin, "Service Detection") && strings.Contains(output, "through TLS")
+}
+
+// nessusTime reads a host timestamp, preferring the epoch form Nessus writes
+// alongside the human readable one.
+func nessusTime(epoch, str string) nmap.Timestamp {
+	var t nmap.Timestamp
+	if epoch != "" && t.ParseTime(epoch) == nil {
+		return t
+	}
+	if parsed, err := time.ParseInLocation(time.ANSIC, str, time.Local); err == nil {
+		return nmap.Timestamp(parsed)
+	}
+	return t
+}
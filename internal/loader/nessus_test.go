@@ -0,0 +1,108 @@
+package loader
+
+import (
+	"testing"
+	"time"
+)
+
+func TestNessus(t *testing.T) {
+	res := loadFile(t, "scan.nessus", false)
+	run := res.run(t)
+	if run.Scanner != "nessus" || run.Args != "nessus report: Internal sweep" {
+		t.Errorf("run scanner %q args %q", run.Scanner, run.Args)
+	}
+	if got := time.Time(run.Start).Unix(); got != 1696240800 {
+		t.Errorf("run start %d", got)
+	}
+	if f := run.Stats.Finished; time.Time(f.Time).Unix() != 1696241200 || f.Elapsed != 400 || f.Exit != "success" {
+		t.Errorf("run finished %+v", f)
+	}
+	if s := run.Stats.Hosts; s.Up != 2 || s.Total != 2 {
+		t.Errorf("run hosts %+v", s)
+	}
+
+	if got := res.addrs(); !equal(got, []string{"10.0.0.5", "10.0.0.7"}) {
+		t.Fatalf("hosts %v", got)
+	}
+
+	h := res.host(t, "10.0.0.5")
+	if len(h.Addresses) != 2 || h.Addresses[1].Addr != "00:50:56:AA:BB:CC" || h.Addresses[1].AddrType != "mac" {
+		t.Errorf("10.0.0.5 addresses %+v", h.Addresses)
+	}
+	var names []string
+	for _, hn := range h.Hostnames {
+		names = append(names, hn.Name+" "+hn.Type)
+	}
+	// the name the host was reported under is kept next to its FQDN
+	if !equal(names, []string{"web.internal.example.com PTR", "web.example.com user"}) {
+		t.Errorf("10.0.0.5 hostnames %v", names)
+	}
+	if len(h.OS.Matches) != 2 || h.OS.Matches[1].Name != "Linux Kernel 5.4" {
+		t.Errorf("10.0.0.5 os %+v", h.OS.Matches)
+	}
+	if start, end := time.Time(h.StartTime).Unix(), time.Time(h.EndTime).Unix(); start != 1696240800 || end != 1696241100 {
+		t.Errorf("10.0.0.5 start %d end %d", start, end)
+	}
+
+	// a port is listed once however many plugins were raised against it,
+	// and findings on port 0 are about the host
+	if len(h.Ports) != 5 {
+		t.Errorf("10.0.0.5: got %d ports, want 5", len(h.Ports))
+	}
+	tests := []struct {
+		addr   string
+		id     uint16
+		proto  string
+		name   string
+		method string
+		tunnel string
+	}{
+		{"10.0.0.5", 22, "tcp", "ssh", "probed", ""},
+		{"10.0.0.5", 443, "tcp", "http", "probed", "ssl"},
+		{"10.0.0.5", 8000, "tcp", "http", "probed", ""},
+		{"10.0.0.5", 3389, "tcp", "ms-wbt-server", "probed", ""},
+		// Nessus could not name the service, so it is named after the port
+		{"10.0.0.5", 161, "udp", "snmp", "table", ""},
+		{"10.0.0.7", 445, "tcp", "microsoft-ds", "probed", ""},
+	}
+	for _, tt := range tests {
+		p := port(t, res.host(t, tt.addr), tt.id, tt.proto)
+		s := p.Service
+		if p.State.State != "open" || s.Name != tt.name || s.Method != tt.method || s.Tunnel != tt.tunnel {
+			t.Errorf("%s %d/%s: state %q service %+v", tt.addr, tt.id, tt.proto, p.State.State, s)
+		}
+	}
+
+	h = res.host(t, "10.0.0.7")
+	if len(h.Hostnames) != 1 || h.Hostnames[0].Name != "FILESRV" || h.Hostnames[0].Type != "netbios" {
+		t.Errorf("10.0.0.7 hostnames %+v", h.Hostnames)
+	}
+}
+
+func TestNessusTruncated(t *testing.T) {
+	in := `<?xml version="1.0" ?>
+<NessusClientData_v2>
+<Report name="cut">
+<ReportHost name="10.0.0.5"><HostProperties><tag name="host-ip">10.0.0.5</tag></HostProperties>
+<ReportItem port="22" svc_name="ssh" protocol="tcp" pluginName="Service Detection"></ReportItem>
+</ReportHost>
+<ReportHost name="10.0.0.7"><HostProperties><tag name="host-ip">10.0.0.7</tag></HostProp`
+
+	res := loadString(t, in, false)
+	if errs := res.l.Errors(); len(errs) != 1 || len(res.hosts) != 1 {
+		t.Fatalf("got %d hosts, errors %v", len(res.hosts), errs)
+	}
+
+	res = loadString(t, in, true)
+	run := res.run(t)
+	if got := res.addrs(); !equal(got, []string{"10.0.0.5"}) {
+		t.Errorf("hosts %v", got)
+	}
+	rec := res.l.Recovered()
+	if len(rec) != 1 || len(rec[0].Partial) != 1 || rec[0].Partial[0].Addresses[0].Addr != "10.0.0.7" {
+		t.Errorf("recovered %+v", rec)
+	}
+	if run.Stats.Finished.Exit != "error" || run.Stats.Hosts.Up != 1 {
+		t.Errorf("run stats %+v", run.Stats)
+	}
+}
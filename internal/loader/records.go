@@ -0,0 +1,195 @@
+package loader
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"strings"
+	"time"
+
+	nmap "github.com/Ullaakut/nmap/v3"
+)
+
+// recordDecoder reads the output of port scanners such as masscan, naabu and
+// rustscan, which write a record per port rather than per host. Records for
+// the same address can be spread across the whole input, so it is read by
+// load and folded into hosts before the first one is handed out.
+type recordDecoder struct {
+	recover bool
+	load    func(d *recordDecoder) error
+	// reason is the state reason given to every port and host found.
+	reason string
+
+	meta    nmap.Run
+	hosts   map[string]*nmap.Host
+	order   []string
+	first   int64
+	last    int64
+	loaded  bool
+	failed  error
+	partial []nmap.Host
+	next    int
+}
+
+func newRecordDecoder(scanner, reason string, load func(d *recordDecoder) error, recover bool) *recordDecoder {
+	return &recordDecoder{
+		recover: recover,
+		load:    load,
+		reason:  reason,
+		meta:    nmap.Run{Scanner: scanner, XMLOutputVersion: "1.05"},
+		hosts:   make(map[string]*nmap.Host),
+	}
+}
+
+func (d *recordDecoder) Next() (nmap.Host, error) {
+	if !d.loaded {
+		d.loaded = true
+		if err := d.load(d); err != nil {
+			if !d.recover {
+				return nmap.Host{}, err
+			}
+			d.failed = err
+		}
+		d.finish()
+	}
+	if d.next >= len(d.order) {
+		return nmap.Host{}, io.EOF
+	}
+	h := d.hosts[d.order[d.next]]
+	delete(d.hosts, d.order[d.next])
+	d.next++
+	return *h, nil
+}
+
+func (d *recordDecoder) run() nmap.Run {
+	return d.meta
+}
+
+func (d *recordDecoder) truncated() ([]nmap.Host, error) {
+	return d.partial, d.failed
+}
+
+// port returns the port record for ip and port, adding the host and port
+// if they have not been seen yet.
+func (d *recordDecoder) port(ip, proto string, id uint16, ts int64) *nmap.Port {
+	h, ok := d.hosts[ip]
+	if !ok {
+		h = &nmap.Host{
+			Status:    nmap.Status{State: "up", Reason: d.reason},
+			Addresses: []nmap.Address{{Addr: ip, AddrType: addrType(ip)}},
+		}
+		d.hosts[ip] = h
+		d.order = append(d.order, ip)
+	}
+
+	if ts > 0 {
+		t := nmap.Timestamp(time.Unix(ts, 0))
+		if time.Time(h.StartTime).IsZero() || ts < time.Time(h.StartTime).Unix() {
+			h.StartTime = t
+		}
+		if ts > time.Time(h.EndTime).Unix() {
+			h.EndTime = t
+		}
+		if d.first == 0 || ts < d.first {
+			d.first = ts
+		}
+		if ts > d.last {
+			d.last = ts
+		}
+	}
+
+	for i := range h.Ports {
+		if h.Ports[i].ID == id && h.Ports[i].Protocol == proto {
+			return &h.Ports[i]
+		}
+	}
+	h.Ports = append(h.Ports, nmap.Port{
+		ID:       id,
+		Protocol: proto,
+		State:    nmap.State{State: "open", Reason: d.reason},
+	})
+	return &h.Ports[len(h.Ports)-1]
+}
+
+// hostname adds name to the hostnames of the host with address ip.
+func (d *recordDecoder) hostname(ip, name string) {
+	h := d.hosts[ip]
+	if h == nil || name == "" || name == ip {
+		return
+	}
+	for _, hn := range h.Hostnames {
+		if hn.Name == name {
+			return
+		}
+	}
+	h.Hostnames = append(h.Hostnames, nmap.Hostname{Name: name, Type: "user"})
+}
+
+// cutoff records the address of a record that was cut off part way through.
+func (d *recordDecoder) cutoff(ip string) {
+	if ip != "" && d.recover {
+		d.partial = append(d.partial, nmap.Host{Addresses: []nmap.Address{{Addr: ip, AddrType: addrType(ip)}}})
+	}
+}
+
+// finish names the ports no banner was seen for, as nmap does for a scan
+// without version detection, and fills in the run level metadata the scanner
+// left out.
+func (d *recordDecoder) finish() {
+	for _, h := range d.hosts {
+		for i := range h.Ports {
+			svc := &h.Ports[i].Service
+			if svc.Name != "" {
+				continue
+			}
+			if svc.Name = ServiceName(fmt.Sprintf("%d/%s", h.Ports[i].ID, h.Ports[i].Protocol)); svc.Name != "" {
+				svc.Method, svc.Confidence = "table", 3
+			}
+		}
+	}
+
+	r := &d.meta
+	if time.Time(r.Start).IsZero() && d.first > 0 {
+		r.Start = nmap.Timestamp(time.Unix(d.first, 0))
+	}
+	if r.StartStr == "" && !time.Time(r.Start).IsZero() {
+		r.StartStr = time.Time(r.Start).Format(time.ANSIC)
+	}
+	f := &r.Stats.Finished
+	if time.Time(f.Time).IsZero() && d.last > 0 {
+		f.Time = nmap.Timestamp(time.Unix(d.last, 0))
+		f.Elapsed = float32(d.last - d.first)
+	}
+	if !time.Time(f.Time).IsZero() {
+		f.TimeStr = time.Time(f.Time).Format(time.ANSIC)
+	}
+	if d.failed != nil {
+		f.Exit = "error"
+		f.ErrorMsg = d.failed.Error()
+	} else if f.Exit == "" {
+		f.Exit = "success"
+	}
+	r.Stats.Hosts = nmap.HostStats{Up: len(d.order), Total: len(d.order)}
+}
+
+// eachLine calls fn for every line of r, with any trailing "\r\n" removed.
+// complete is false for a final line that lacks its newline, which is how an
+// interrupted write shows.
+func eachLine(r io.Reader, fn func(line string, complete bool) error) error {
+	br := bufio.NewReader(r)
+	for {
+		line, err := br.ReadString('\n')
+		if err != nil && err != io.EOF {
+			return err
+		}
+		if line != "" {
+			complete := err == nil
+			if ferr := fn(strings.TrimRight(line, "\r\n"), complete); ferr != nil {
+				return ferr
+			}
+		}
+		if err == io.EOF {
+			return nil
+		}
+	}
+}
@@ -0,0 +1,62 @@
+package loader
+
+import (
+	"fmt"
+	"io"
+	"net"
+	"regexp"
+	"strconv"
+	"strings"
+
+	nmap "github.com/Ullaakut/nmap/v3"
+)
+
+var rustscanLine = regexp.MustCompile(`^(\S+) -> \[([\d,\s]*)\]$`)
+
+// newRustscanDecoder returns a decoder for rustscan greppable (-g) output,
+// lines of
+//
+//	10.0.0.1 -> [22,80,443]
+func newRustscanDecoder(r io.Reader, recover bool) *recordDecoder {
+	d := newRecordDecoder("rustscan", "rustscan", loadRustscan(r), recover)
+	d.meta.ScanInfo = nmap.ScanInfo{Type: "connect", Protocol: "tcp"}
+	return d
+}
+
+func loadRustscan(r io.Reader) func(d *recordDecoder) error {
+	return func(d *recordDecoder) error {
+		return eachLine(r, func(line string, complete bool) error {
+			line = strings.TrimSpace(line)
+			if line == "" {
+				return nil
+			}
+
+			// a last line without a newline is only cut off if it does not
+			// parse, rustscan closes every line with a bracket
+			m := rustscanLine.FindStringSubmatch(line)
+			if m == nil {
+				if !complete {
+					d.cutoff(strings.Fields(line)[0])
+					return io.ErrUnexpectedEOF
+				}
+				return fmt.Errorf("malformed rustscan line %q", line)
+			}
+			if net.ParseIP(m[1]) == nil {
+				return fmt.Errorf("rustscan line %q does not start with an IP address", line)
+			}
+
+			for _, f := range strings.Split(m[2], ",") {
+				f = strings.TrimSpace(f)
+				if f == "" {
+					continue
+				}
+				id, err := strconv.ParseUint(f, 10, 16)
+				if err != nil {
+					return fmt.Errorf("malformed port number %q", f)
+				}
+				d.port(m[1], "tcp", uint16(id), 0)
+			}
+			return nil
+		})
+	}
+}
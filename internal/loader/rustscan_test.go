@@ -0,0 +1,81 @@
+package loader
+
+import (
+	"errors"
+	"io"
+	"testing"
+)
+
+func TestRustscan(t *testing.T) {
+	res := loadFile(t, "rustscan.txt", false)
+	run := res.run(t)
+	if run.Scanner != "rustscan" || run.ScanInfo.Type != "connect" {
+		t.Errorf("run scanner %q scaninfo %+v", run.Scanner, run.ScanInfo)
+	}
+	if s := run.Stats.Hosts; s.Up != 3 || s.Total != 3 {
+		t.Errorf("run hosts %+v", s)
+	}
+
+	if got := res.addrs(); !equal(got, []string{"10.0.0.5", "10.0.0.7", "fe80::1"}) {
+		t.Fatalf("hosts %v", got)
+	}
+	if a := res.host(t, "fe80::1").Addresses[0]; a.AddrType != "ipv6" {
+		t.Errorf("fe80::1 address type %q", a.AddrType)
+	}
+
+	tests := []struct {
+		addr  string
+		ports []uint16
+	}{
+		// a host listed twice gets the ports of both lines
+		{"10.0.0.5", []uint16{80, 443, 8443, 8080}},
+		{"10.0.0.7", []uint16{22}},
+		{"fe80::1", []uint16{22, 80}},
+	}
+	for _, tt := range tests {
+		h := res.host(t, tt.addr)
+		if len(h.Ports) != len(tt.ports) {
+			t.Errorf("%s: got %d ports, want %d", tt.addr, len(h.Ports), len(tt.ports))
+			continue
+		}
+		for i, id := range tt.ports {
+			if p := h.Ports[i]; p.ID != id || p.Protocol != "tcp" || p.State.State != "open" {
+				t.Errorf("%s: port %d is %d/%s %s, want %d/tcp open", tt.addr, i, p.ID, p.Protocol, p.State.State, id)
+			}
+		}
+	}
+	if p := port(t, res.host(t, "10.0.0.5"), 8080, "tcp"); p.Service.Name != "http-proxy" || p.Service.Method != "table" {
+		t.Errorf("8080/tcp service %+v", p.Service)
+	}
+}
+
+func TestRustscanMalformed(t *testing.T) {
+	tests := []string{
+		"10.0.0.5 -> [80,http]\n",
+		"10.0.0.5 -> [70000]\n",
+		"web.example.com -> [80]\n",
+	}
+	for _, in := range tests {
+		res := loadString(t, in, false)
+		if len(res.l.Errors()) != 1 || len(res.hosts) != 0 {
+			t.Errorf("%q: got %d hosts, errors %v", in, len(res.hosts), res.l.Errors())
+		}
+	}
+}
+
+// TestRustscanLastLine reads a last line without a newline, which is only
+// cut off if it does not parse.
+func TestRustscanLastLine(t *testing.T) {
+	res := loadString(t, "10.0.0.5 -> [80,443]\n10.0.0.7 -> [22]", false)
+	if errs := res.l.Errors(); len(errs) != 0 {
+		t.Fatalf("errors %v", errs)
+	}
+	if got := res.addrs(); !equal(got, []string{"10.0.0.5", "10.0.0.7"}) {
+		t.Errorf("hosts %v", got)
+	}
+
+	res = loadString(t, "10.0.0.5 -> [80,443]\n10.0.0.7 -> [22,44", false)
+	if errs := res.l.Errors(); len(errs) != 1 || !errors.Is(errs[0], io.ErrUnexpectedEOF) {
+		t.Errorf("errors %v, want unexpected EOF", errs)
+	}
+}
@@ -0,0 +1,89 @@
+package loader
+
+import (
+	"bufio"
+	"os"
+	"strings"
+	"sync"
+)
+
+// NmapServices is the nmap services table used to name the ports of scanners
+// that only report port numbers.
+var NmapServices = "/usr/share/nmap/nmap-services"
+
+var (
+	servicesOnce sync.Once
+	services     map[string]string
+)
+
+// commonServices stands in for the nmap services table when nmap is not
+// installed, using the names nmap gives these ports.
+var commonServices = map[string]string{
+	"21/tcp":    "ftp",
+	"22/tcp":    "ssh",
+	"23/tcp":    "telnet",
+	"25/tcp":    "smtp",
+	"53/tcp":    "domain",
+	"53/udp":    "domain",
+	"80/tcp":    "http",
+	"110/tcp":   "pop3",
+	"111/tcp":   "rpcbind",
+	"123/udp":   "ntp",
+	"135/tcp":   "msrpc",
+	"139/tcp":   "netbios-ssn",
+	"143/tcp":   "imap",
+	"161/udp":   "snmp",
+	"389/tcp":   "ldap",
+	"443/tcp":   "https",
+	"445/tcp":   "microsoft-ds",
+	"636/tcp":   "ldapssl",
+	"993/tcp":   "imaps",
+	"995/tcp":   "pop3s",
+	"1433/tcp":  "ms-sql-s",
+	"1521/tcp":  "oracle",
+	"2049/tcp":  "nfs",
+	"3306/tcp":  "mysql",
+	"3389/tcp":  "ms-wbt-server",
+	"5432/tcp":  "postgresql",
+	"5900/tcp":  "vnc",
+	"5985/tcp":  "wsman",
+	"6379/tcp":  "redis",
+	"8000/tcp":  "http-alt",
+	"8080/tcp":  "http-proxy",
+	"8443/tcp":  "https-alt",
+	"9200/tcp":  "wap-wsp",
+	"27017/tcp": "mongod",
+}
+
+// ServiceName returns the name nmap gives port, written as "80/tcp", when it
+// has not probed the service. It returns "" for ports nmap has no name for.
+func ServiceName(port string) string {
+	servicesOnce.Do(func() {
+		services = commonServices
+		f, err := os.Open(NmapServices)
+		if err != nil {
+			return
+		}
+		defer f.Close()
+
+		table := make(map[string]string)
+		s := bufio.NewScanner(f)
+		for s.Scan() {
+			line := s.Text()
+			if strings.HasPrefix(line, "#") {
+				continue
+			}
+			f := strings.Split(line, "\t")
+			if len(f) < 2 || f[0] == "unknown" {
+				continue
+			}
+			if _, ok := table[f[1]]; !ok {
+				table[f[1]] = f[0]
+			}
+		}
+		if s.Err() == nil {
+			services = table
+		}
+	})
+	return services[port]
+}
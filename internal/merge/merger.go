@@ -0,0 +1,223 @@
+package merge
+
+import (
+	"fmt"
+	"strings"
+	"time"
+
+	nmap "github.com/Ullaakut/nmap/v3"
+)
+
+// Conflict records how two records of a host, or of one of its ports, were
+// reconciled.
+type Conflict struct {
+	// Port is the port the conflict was about, as "80/tcp", or empty for the
+	// host as a whole.
+	Port     string
+	Strategy string
+	// Kept and Seen are the sources of the record kept so far and of the
+	// newly seen one.
+	Kept, Seen string
+	Outcome    Outcome
+	Why        string
+}
+
+func (c Conflict) String() string {
+	var verb string
+	switch c.Outcome {
+	case Replaced:
+		verb = "took " + c.Seen + " over " + c.Kept
+	case Merged:
+		verb = "merged " + c.Seen + " into " + c.Kept
+	default:
+		verb = "kept " + c.Kept + " over " + c.Seen
+	}
+	return fmt.Sprintf("%s %s (%s)", c.Strategy, verb, c.Why)
+}
+
+// Merger combines the records of every host handed to it, resolving
+// conflicts between records of the same host with a host strategy and, where
+// that merges them, conflicts between records of the same port with a port
+// strategy.
+type Merger struct {
+	// Explain keeps every conflict and how it was resolved, see Conflicts.
+	Explain bool
+
+	hostName, portName string
+	host               HostStrategy
+	port               PortStrategy
+
+	hosts   map[string]nmap.Host
+	entries map[string]*entry
+	order   []string
+}
+
+// entry is what the merger knows about a host besides the host itself.
+type entry struct {
+	rec       Record
+	ports     map[string]Record
+	conflicts []Conflict
+}
+
+// NewMerger returns a merger using the named host and port strategies, see
+// HostStrategies and PortStrategies.
+func NewMerger(host, port string) (*Merger, error) {
+	hs, ok := HostStrategies[host]
+	if !ok {
+		return nil, fmt.Errorf("unknown host strategy %q, use one of %s", host, strings.Join(HostStrategyNames(), ", "))
+	}
+	ps, ok := PortStrategies[port]
+	if !ok {
+		return nil, fmt.Errorf("unknown port strategy %q, use one of %s", port, strings.Join(PortStrategyNames(), ", "))
+	}
+	return &Merger{
+		hostName: host,
+		portName: port,
+		host:     hs,
+		port:     ps,
+		hosts:    make(map[string]nmap.Host),
+		entries:  make(map[string]*entry),
+	}, nil
+}
+
+// Add merges a record of the host identified by key, read from source, into
+// what the merger holds for it.
+func (m *Merger) Add(key string, h nmap.Host, source string) {
+	rec := Record{Sources: []string{source}, Seen: seenAt(h)}
+	e, ok := m.entries[key]
+	if !ok {
+		m.hosts[key] = h
+		m.entries[key] = &entry{rec: rec, ports: portRecords(h, rec)}
+		m.order = append(m.order, key)
+		return
+	}
+
+	kept := m.hosts[key]
+	outcome, why := m.host(&kept, &h, e.rec, rec)
+	m.explain(e, Conflict{Strategy: m.hostName, Kept: e.rec.source(), Seen: source, Outcome: outcome, Why: why})
+	switch outcome {
+	case Replaced:
+		m.hosts[key] = h
+		e.rec = rec
+		e.ports = portRecords(h, rec)
+	case Merged:
+		merged := unionHost(kept, h)
+		merged.Ports = m.mergePorts(e, kept.Ports, h.Ports, rec)
+		m.hosts[key] = merged
+		e.rec = joinRecords(e.rec, rec)
+	}
+}
+
+// mergePorts merges the ports of a newly seen record into those kept so far,
+// resolving ports found in both with the port strategy.
+func (m *Merger) mergePorts(e *entry, kept, seen []nmap.Port, rec Record) []nmap.Port {
+	source := rec.source()
+	ports := append(make([]nmap.Port, 0, len(kept)+len(seen)), kept...)
+	index := make(map[string]int, len(kept))
+	for i, p := range kept {
+		index[PortKey(p)] = i
+	}
+	for _, p := range seen {
+		k := PortKey(p)
+		i, ok := index[k]
+		if !ok {
+			index[k] = len(ports)
+			ports = append(ports, p)
+			e.ports[k] = rec
+			continue
+		}
+		keptRec := e.ports[k]
+		port, outcome, why := m.port(ports[i], p, keptRec, rec)
+		ports[i] = port
+		m.explain(e, Conflict{Port: k, Strategy: m.portName, Kept: keptRec.source(), Seen: source, Outcome: outcome, Why: why})
+		switch outcome {
+		case Replaced:
+			e.ports[k] = rec
+		case Merged:
+			e.ports[k] = joinRecords(keptRec, rec)
+		}
+	}
+	SortPorts(ports)
+	return ports
+}
+
+func (m *Merger) explain(e *entry, c Conflict) {
+	if m.Explain {
+		e.conflicts = append(e.conflicts, c)
+	}
+}
+
+// Keys returns the key of every host, in the order they were first added.
+func (m *Merger) Keys() []string {
+	return m.order
+}
+
+// Hosts returns the merged hosts by key. The map is the merger's own.
+func (m *Merger) Hosts() map[string]nmap.Host {
+	return m.hosts
+}
+
+// Conflicts returns the conflicts resolved for the host identified by key,
+// in the order they came up. Conflicts are only kept when Explain is set.
+func (m *Merger) Conflicts(key string) []Conflict {
+	if e, ok := m.entries[key]; ok {
+		return e.conflicts
+	}
+	return nil
+}
+
+// Provenance returns the inputs the host identified by key was taken from,
+// and those each of its ports was taken from, by port key. Inputs that lost
+// out to another in a conflict are not listed.
+func (m *Merger) Provenance(key string) (host []string, ports map[string][]string) {
+	e, ok := m.entries[key]
+	if !ok {
+		return nil, nil
+	}
+	ports = make(map[string][]string, len(e.ports))
+	for k, rec := range e.ports {
+		ports[k] = rec.Sources
+	}
+	return e.rec.Sources, ports
+}
+
+// source returns the sources of a record for messages.
+func (r Record) source() string {
+	return strings.Join(r.Sources, ", ")
+}
+
+// seenAt returns when a host was scanned, the end time of its scan or,
+// failing that, the start.
+func seenAt(h nmap.Host) time.Time {
+	if t := time.Time(h.EndTime); !t.IsZero() {
+		return t
+	}
+	return time.Time(h.StartTime)
+}
+
+func portRecords(h nmap.Host, rec Record) map[string]Record {
+	ports := make(map[string]Record, len(h.Ports))
+	for _, p := range h.Ports {
+		ports[PortKey(p)] = rec
+	}
+	return ports
+}
+
+// joinRecords returns the record of something merged from a and b, naming
+// both sources and seen as late as the later of the two.
+func joinRecords(a, b Record) Record {
+	r := Record{Sources: append([]string(nil), a.Sources...), Seen: a.Seen}
+	for _, src := range b.Sources {
+		found := false
+		for _, s := range r.Sources {
+			found = found || s == src
+		}
+		if !found {
+			r.Sources = append(r.Sources, src)
+		}
+	}
+	if b.Seen.After(a.Seen) {
+		r.Seen = b.Seen
+	}
+	return r
+}
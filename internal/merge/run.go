@@ -0,0 +1,208 @@
+package merge
+
+import (
+	"sort"
+	"strconv"
+	"strings"
+
+	nmap "github.com/Ullaakut/nmap/v3"
+)
+
+// ScanInfos merges scaninfo entries, keeping one per scan type and protocol
+// with the service ranges of all of them, so a TCP SYN scan and a UDP scan
+// give two entries while two SYN scans of different ports give one. An entry
+// without a scan type, as read from grepable output, is merged with any of
+// the same protocol. Entries keep the order they were first seen in.
+func ScanInfos(infos []nmap.ScanInfo) []nmap.ScanInfo {
+	var out []nmap.ScanInfo
+	for _, si := range infos {
+		if si.Type == "" && si.Protocol == "" {
+			continue
+		}
+		i := -1
+		for j, have := range out {
+			if have.Protocol != si.Protocol {
+				continue
+			}
+			if have.Type == si.Type || si.Type == "" || have.Type == "" {
+				i = j
+				break
+			}
+		}
+		if i < 0 {
+			out = append(out, si)
+			continue
+		}
+		if out[i].Type == "" {
+			out[i].Type = si.Type
+		}
+		out[i].Services = mergeRanges(out[i].Services, si.Services)
+		out[i].NumServices = countRanges(out[i].Services)
+		if out[i].ScanFlags == "" {
+			out[i].ScanFlags = si.ScanFlags
+		}
+	}
+	return out
+}
+
+// mergeRanges merges two nmap port lists such as "1-1000,3389" into one with
+// overlapping and adjacent ranges joined. Anything that is not a port or
+// range is kept as it is, after the ranges.
+func mergeRanges(a, b string) string {
+	type span struct{ lo, hi int }
+	var spans []span
+	var other []string
+	seen := make(map[string]bool)
+	for _, list := range []string{a, b} {
+		for _, f := range strings.Split(list, ",") {
+			f = strings.TrimSpace(f)
+			if f == "" {
+				continue
+			}
+			lo, hi, ok := parseRange(f)
+			if !ok {
+				if !seen[f] {
+					seen[f] = true
+					other = append(other, f)
+				}
+				continue
+			}
+			spans = append(spans, span{lo, hi})
+		}
+	}
+	sort.Slice(spans, func(i, j int) bool { return spans[i].lo < spans[j].lo })
+
+	var merged []span
+	for _, s := range spans {
+		if n := len(merged); n > 0 && s.lo <= merged[n-1].hi+1 {
+			if s.hi > merged[n-1].hi {
+				merged[n-1].hi = s.hi
+			}
+			continue
+		}
+		merged = append(merged, s)
+	}
+
+	var parts []string
+	for _, s := range merged {
+		if s.lo == s.hi {
+			parts = append(parts, strconv.Itoa(s.lo))
+		} else {
+			parts = append(parts, strconv.Itoa(s.lo)+"-"+strconv.Itoa(s.hi))
+		}
+	}
+	return strings.Join(append(parts, other...), ",")
+}
+
+// countRanges returns the number of ports in an nmap port list.
+func countRanges(list string) int {
+	n := 0
+	for _, f := range strings.Split(list, ",") {
+		if lo, hi, ok := parseRange(strings.TrimSpace(f)); ok {
+			n += hi - lo + 1
+		}
+	}
+	return n
+}
+
+func parseRange(f string) (lo, hi int, ok bool) {
+	l, h, isRange := strings.Cut(f, "-")
+	lo, err := strconv.Atoi(l)
+	if err != nil {
+		return 0, 0, false
+	}
+	if !isRange {
+		return lo, lo, true
+	}
+	hi, err = strconv.Atoi(h)
+	if err != nil || hi < lo {
+		return 0, 0, false
+	}
+	return lo, hi, true
+}
+
+// Targets appends the targets of b that are not already in a.
+func Targets(a, b []nmap.Target) []nmap.Target {
+	for _, t := range b {
+		found := false
+		for _, have := range a {
+			found = found || have == t
+		}
+		if !found {
+			a = append(a, t)
+		}
+	}
+	return a
+}
+
+// RunScripts appends the pre or post scan scripts of b that are not already
+// in a. Scripts are the same when their id and output are.
+func RunScripts(a, b []nmap.Script) []nmap.Script {
+	for _, s := range b {
+		found := false
+		for _, have := range a {
+			found = found || (have.ID == s.ID && have.Output == s.Output)
+		}
+		if !found {
+			a = append(a, s)
+		}
+	}
+	return a
+}
+
+// NewerVersion returns whichever of two version strings, such as "7.94SVN"
+// or "1.05", is newer, or the one that is set if the other is empty.
+// Versions are compared number by number, so 7.100 is newer than 7.99, and
+// where the numbers are the same a release beats a suffixed build.
+func NewerVersion(a, b string) string {
+	switch {
+	case a == "":
+		return b
+	case b == "":
+		return a
+	}
+	na, sa := splitVersion(a)
+	nb, sb := splitVersion(b)
+	for i := 0; i < len(na) || i < len(nb); i++ {
+		var x, y int
+		if i < len(na) {
+			x = na[i]
+		}
+		if i < len(nb) {
+			y = nb[i]
+		}
+		if x != y {
+			if y > x {
+				return b
+			}
+			return a
+		}
+	}
+	if sa != "" && sb == "" {
+		return b
+	}
+	return a
+}
+
+// splitVersion splits a version into its dotted numbers and whatever suffix
+// follows them.
+func splitVersion(v string) ([]int, string) {
+	var nums []int
+	for v != "" {
+		i := 0
+		for i < len(v) && v[i] >= '0' && v[i] <= '9' {
+			i++
+		}
+		if i == 0 {
+			break
+		}
+		n, _ := strconv.Atoi(v[:i])
+		nums = append(nums, n)
+		v = v[i:]
+		if !strings.HasPrefix(v, ".") {
+			break
+		}
+		v = v[1:]
+	}
+	return nums, v
+}
@@ -0,0 +1,174 @@
+package merge
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+	"time"
+
+	nmap "github.com/Ullaakut/nmap/v3"
+)
+
+// Record says where a host, or one of its ports, was seen.
+type Record struct {
+	// Sources are the inputs the record was taken from, more than one once
+	// records have been merged.
+	Sources []string
+	// Seen is when the record was taken, the end time of the host's scan.
+	Seen time.Time
+}
+
+// Outcome is how a conflict between two records was resolved.
+type Outcome int
+
+const (
+	// Kept keeps the record seen first.
+	Kept Outcome = iota
+	// Replaced replaces it with the newly seen one.
+	Replaced
+	// Merged combines the two.
+	Merged
+)
+
+func (o Outcome) String() string {
+	switch o {
+	case Replaced:
+		return "replaced"
+	case Merged:
+		return "merged"
+	}
+	return "kept"
+}
+
+// HostStrategy resolves a conflict between the record kept so far for a host
+// and a newly seen one, and says why. A host strategy that merges the two
+// leaves the ports found in both to the PortStrategy.
+type HostStrategy func(kept, seen *nmap.Host, keptRec, seenRec Record) (Outcome, string)
+
+// PortStrategy resolves a conflict between two records of the same port of
+// a host. It returns the port to keep, how it was arrived at and why.
+type PortStrategy func(kept, seen nmap.Port, keptRec, seenRec Record) (nmap.Port, Outcome, string)
+
+// HostStrategies are the host level strategies by name.
+var HostStrategies = map[string]HostStrategy{
+	"most-ports": MostPorts,
+	"latest":     LatestHost,
+	"first":      FirstHost,
+	"union":      UnionHost,
+}
+
+// PortStrategies are the port level strategies by name, these only come into
+// play when a host strategy merges records.
+var PortStrategies = map[string]PortStrategy{
+	"richest":    RichestPort,
+	"latest":     LatestPort,
+	"confidence": ConfidentPort,
+	"first":      FirstPort,
+}
+
+// HostStrategyNames returns the names of the host level strategies, sorted.
+func HostStrategyNames() []string {
+	var names []string
+	for n := range HostStrategies {
+		names = append(names, n)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// PortStrategyNames returns the names of the port level strategies, sorted.
+func PortStrategyNames() []string {
+	var names []string
+	for n := range PortStrategies {
+		names = append(names, n)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// MostPorts keeps an up record over a down one and, between two up records,
+// the one with the most ports. Ties keep the record seen first.
+func MostPorts(kept, seen *nmap.Host, _, _ Record) (Outcome, string) {
+	ku, su := kept.Status.State == "up", seen.Status.State == "up"
+	switch {
+	case ku && !su:
+		return Kept, "only the kept record is up"
+	case !ku && su:
+		return Replaced, "only the new record is up"
+	case !ku && !su:
+		return Kept, "both records are down"
+	case len(seen.Ports) > len(kept.Ports):
+		return Replaced, fmt.Sprintf("new record has more ports, %d to %d", len(seen.Ports), len(kept.Ports))
+	}
+	return Kept, fmt.Sprintf("new record has no more ports, %d to %d", len(seen.Ports), len(kept.Ports))
+}
+
+// LatestHost keeps the record from the scan that finished last. Ties keep
+// the record seen first.
+func LatestHost(_, _ *nmap.Host, keptRec, seenRec Record) (Outcome, string) {
+	if seenRec.Seen.After(keptRec.Seen) {
+		return Replaced, "new record is from a later scan"
+	}
+	return Kept, "new record is from an earlier or the same scan"
+}
+
+// FirstHost keeps the record seen first.
+func FirstHost(_, _ *nmap.Host, _, _ Record) (Outcome, string) {
+	return Kept, "first record wins"
+}
+
+// UnionHost merges the two records, see Union.
+func UnionHost(_, _ *nmap.Host, _, _ Record) (Outcome, string) {
+	return Merged, "records are merged"
+}
+
+// RichestPort takes the higher ranked state, see StateWins, and the richer
+// service record, see Richer, along with the scripts of both. This is how
+// Union merges ports.
+func RichestPort(kept, seen nmap.Port, _, _ Record) (nmap.Port, Outcome, string) {
+	var why []string
+	if StateWins(seen.State.State, kept.State.State) {
+		why = append(why, "new state "+seen.State.State+" outranks "+kept.State.State)
+	}
+	if Richer(seen, kept) {
+		why = append(why, "new service record is richer")
+	}
+	if len(why) == 0 {
+		return unionPort(kept, seen), Kept, "kept state and service are as good or better"
+	}
+	return unionPort(kept, seen), Merged, strings.Join(why, ", ")
+}
+
+// LatestPort keeps the port record from the scan that finished last. Ties
+// keep the record seen first.
+func LatestPort(kept, seen nmap.Port, keptRec, seenRec Record) (nmap.Port, Outcome, string) {
+	if seenRec.Seen.After(keptRec.Seen) {
+		return seen, Replaced, "new record is from a later scan"
+	}
+	return kept, Kept, "new record is from an earlier or the same scan"
+}
+
+// ConfidentPort keeps the port whose service nmap was most confident in,
+// then a probed service over one named from the services table, then the
+// higher ranked state. Ties keep the record seen first.
+func ConfidentPort(kept, seen nmap.Port, _, _ Record) (nmap.Port, Outcome, string) {
+	ks, ss := kept.Service, seen.Service
+	switch {
+	case ss.Confidence > ks.Confidence:
+		return seen, Replaced, fmt.Sprintf("new service is more confident, %d to %d", ss.Confidence, ks.Confidence)
+	case ss.Confidence < ks.Confidence:
+		return kept, Kept, fmt.Sprintf("kept service is more confident, %d to %d", ks.Confidence, ss.Confidence)
+	case ss.Method == "probed" && ks.Method != "probed":
+		return seen, Replaced, "new service was probed"
+	case ks.Method == "probed" && ss.Method != "probed":
+		return kept, Kept, "kept service was probed"
+	case StateWins(seen.State.State, kept.State.State):
+		return seen, Replaced, "equally confident, new state " + seen.State.State + " outranks " + kept.State.State
+	}
+	return kept, Kept, "equally confident"
+}
+
+// FirstPort keeps the port record seen first.
+func FirstPort(kept, _ nmap.Port, _, _ Record) (nmap.Port, Outcome, string) {
+	return kept, Kept, "first record wins"
+}
@@ -0,0 +1,136 @@
+package merge
+
+import (
+	"fmt"
+	"testing"
+	"time"
+
+	nmap "github.com/Ullaakut/nmap/v3"
+)
+
+// record is a scan record of host 10.0.0.5, read from source and finished
+// at minute end of the day.
+type record struct {
+	source string
+	end    int
+	host   nmap.Host
+}
+
+func at(end int, h nmap.Host) nmap.Host {
+	h.EndTime = nmap.Timestamp(time.Date(2023, 10, 2, 10, end, 0, 0, time.UTC))
+	return h
+}
+
+func merged(t *testing.T, host, port string, records []record) (*Merger, nmap.Host) {
+	t.Helper()
+	m, err := NewMerger(host, port)
+	if err != nil {
+		t.Fatal(err)
+	}
+	m.Explain = true
+	for _, r := range records {
+		m.Add("10.0.0.5", at(r.end, r.host), r.source)
+	}
+	return m, m.Hosts()["10.0.0.5"]
+}
+
+// TestHostStrategies picks the record of a host each host strategy keeps,
+// ties keeping the record seen first.
+func TestHostStrategies(t *testing.T) {
+	two := upHost(tcp(22, "open"), tcp(80, "open"))
+	tests := []struct {
+		strategy string
+		records  []record
+		want     string
+	}{
+		{"latest", []record{{"a.xml", 5, two}, {"b.xml", 9, upHost(tcp(22, "open"))}, {"c.xml", 7, two}}, "b.xml"},
+		// both end at the same time
+		{"latest", []record{{"a.xml", 9, two}, {"b.xml", 9, upHost(tcp(22, "open"))}}, "a.xml"},
+		{"first", []record{{"a.xml", 5, upHost(tcp(22, "open"))}, {"b.xml", 9, two}}, "a.xml"},
+		{"most-ports", []record{{"a.xml", 5, upHost(tcp(22, "open"))}, {"b.xml", 1, two}}, "b.xml"},
+		{"most-ports", []record{{"a.xml", 5, two}, {"b.xml", 9, upHost(tcp(443, "open"), tcp(8443, "open"))}}, "a.xml"},
+		{"most-ports", []record{{"a.xml", 5, nmap.Host{Status: nmap.Status{State: "down"}}}, {"b.xml", 1, upHost()}}, "b.xml"},
+	}
+	for _, tt := range tests {
+		m, _ := merged(t, tt.strategy, "richest", tt.records)
+		if src, _ := m.Provenance("10.0.0.5"); fmt.Sprint(src) != fmt.Sprint([]string{tt.want}) {
+			t.Errorf("%s %v: kept %v, want %s", tt.strategy, tt.records, src, tt.want)
+		}
+	}
+}
+
+// TestPortStrategies merges the records of a host and picks the record of a
+// port found in both with each port strategy, ties keeping the record seen
+// first.
+func TestPortStrategies(t *testing.T) {
+	table := nmap.Service{Name: "http", Method: "table", Confidence: 3}
+	nginx := nmap.Service{Name: "http", Product: "nginx", Method: "probed", Confidence: 10}
+	apache := nmap.Service{Name: "http", Product: "Apache httpd", Method: "probed", Confidence: 10}
+	guess := nmap.Service{Name: "http-alt", Product: "Jetty", Method: "probed", Confidence: 8}
+	tests := []struct {
+		strategy string
+		records  []record
+		want     string
+	}{
+		{"latest", []record{{"a.xml", 9, upHost(svc(tcp(80, "open"), nginx))}, {"b.xml", 5, upHost(svc(tcp(80, "open"), apache))}}, "80/tcp open http nginx"},
+		{"latest", []record{{"a.xml", 5, upHost(svc(tcp(80, "open"), nginx))}, {"b.xml", 9, upHost(svc(tcp(80, "filtered"), table))}}, "80/tcp filtered http"},
+		// both end at the same time
+		{"latest", []record{{"a.xml", 9, upHost(svc(tcp(80, "open"), nginx))}, {"b.xml", 9, upHost(svc(tcp(80, "open"), apache))}}, "80/tcp open http nginx"},
+
+		{"confidence", []record{{"a.xml", 1, upHost(svc(tcp(80, "open"), guess))}, {"b.xml", 1, upHost(svc(tcp(80, "open"), nginx))}}, "80/tcp open http nginx"},
+		{"confidence", []record{{"a.xml", 1, upHost(svc(tcp(80, "open"), nginx))}, {"b.xml", 1, upHost(svc(tcp(80, "open"), guess))}}, "80/tcp open http nginx"},
+		// equally confident, a probed service wins, then the better state,
+		// then the first seen
+		{"confidence", []record{{"a.xml", 1, upHost(svc(tcp(80, "open"), nmap.Service{Name: "http", Confidence: 10}))}, {"b.xml", 1, upHost(svc(tcp(80, "open"), apache))}}, "80/tcp open http Apache httpd"},
+		{"confidence", []record{{"a.xml", 1, upHost(svc(tcp(80, "filtered"), nginx))}, {"b.xml", 1, upHost(svc(tcp(80, "open"), apache))}}, "80/tcp open http Apache httpd"},
+		{"confidence", []record{{"a.xml", 1, upHost(svc(tcp(80, "open"), nginx))}, {"b.xml", 1, upHost(svc(tcp(80, "open"), apache))}}, "80/tcp open http nginx"},
+
+		{"first", []record{{"a.xml", 1, upHost(svc(tcp(80, "filtered"), table))}, {"b.xml", 9, upHost(svc(tcp(80, "open"), nginx))}}, "80/tcp filtered http"},
+	}
+	for _, tt := range tests {
+		_, h := merged(t, "union", tt.strategy, tt.records)
+		if got := portList(h.Ports); got != tt.want {
+			t.Errorf("%s %v: port %s, want %s", tt.strategy, tt.records, got, tt.want)
+		}
+	}
+}
+
+// TestExplain lists how every conflict of a host was resolved, as combine
+// --explain prints them.
+func TestExplain(t *testing.T) {
+	nginx := nmap.Service{Name: "http", Product: "nginx", Method: "probed", Confidence: 10}
+	guess := nmap.Service{Name: "http-alt", Method: "table", Confidence: 3}
+	m, _ := merged(t, "union", "confidence", []record{
+		{"a.xml", 1, upHost(svc(tcp(80, "open"), guess), tcp(22, "open"))},
+		{"b.xml", 2, upHost(svc(tcp(80, "open"), nginx))},
+		{"c.xml", 3, upHost(svc(tcp(80, "open"), guess))},
+	})
+	var got []string
+	for _, c := range m.Conflicts("10.0.0.5") {
+		got = append(got, c.Port+": "+c.String())
+	}
+	want := []string{
+		": union merged b.xml into a.xml (records are merged)",
+		"80/tcp: confidence took b.xml over a.xml (new service is more confident, 10 to 3)",
+		": union merged c.xml into a.xml, b.xml (records are merged)",
+		"80/tcp: confidence kept b.xml over c.xml (kept service is more confident, 10 to 3)",
+	}
+	if fmt.Sprint(got) != fmt.Sprint(want) {
+		t.Errorf("conflicts\n got: %q\nwant: %q", got, want)
+	}
+
+	// ports only one record had are taken from it, the port that conflicted
+	// from the record that won
+	_, ports := m.Provenance("10.0.0.5")
+	if fmt.Sprint(ports["22/tcp"], ports["80/tcp"]) != "[a.xml] [b.xml]" {
+		t.Errorf("port provenance %v", ports)
+	}
+
+	// conflicts are only kept when asked for
+	quiet, _ := NewMerger("first", "richest")
+	quiet.Add("10.0.0.5", upHost(), "a.xml")
+	quiet.Add("10.0.0.5", upHost(), "b.xml")
+	if c := quiet.Conflicts("10.0.0.5"); len(c) != 0 {
+		t.Errorf("conflicts %v without Explain", c)
+	}
+}
@@ -0,0 +1,290 @@
+// Package merge combines the records of a host that was seen in more than
+// one scan, and the run level metadata of the scans themselves.
+package merge
+
+import (
+	"sort"
+	"strconv"
+	"time"
+
+	nmap "github.com/Ullaakut/nmap/v3"
+)
+
+// stateRank orders port states for when scans disagree. Open beats anything,
+// then definite answers beat ambiguous ones, and filtered, the absence of any
+// answer, comes last. States nmap does not use rank below all of these.
+var stateRank = map[string]int{
+	"open":            6,
+	"open|filtered":   5,
+	"closed":          4,
+	"unfiltered":      3,
+	"closed|filtered": 2,
+	"filtered":        1,
+}
+
+// protoRank orders ports the way nmap lists them.
+var protoRank = map[string]int{
+	"tcp":  0,
+	"udp":  1,
+	"sctp": 2,
+	"ip":   3,
+}
+
+// Union merges two records of the same host. Ports are merged per protocol
+// and port number: the port takes the highest ranked state of the two, see
+// StateWins, and the richer of the two service records, see Richer, with
+// the scripts of both. Addresses, hostnames, OS matches and host scripts are
+// merged, the host is up if either record says so, and the scan window covers
+// both. Where the records hold a single value, such as the trace or uptime,
+// a's is kept unless it is empty.
+func Union(a, b nmap.Host) nmap.Host {
+	h := unionHost(a, b)
+	h.Ports = UnionPorts(a.Ports, b.Ports)
+	return h
+}
+
+// unionHost merges everything but the ports of two records of the same host.
+func unionHost(a, b nmap.Host) nmap.Host {
+	h := a
+
+	if a.Status.State != "up" && b.Status.State == "up" {
+		h.Status = b.Status
+	}
+	if time.Time(h.StartTime).IsZero() || (!time.Time(b.StartTime).IsZero() && time.Time(b.StartTime).Before(time.Time(h.StartTime))) {
+		h.StartTime = b.StartTime
+	}
+	if time.Time(b.EndTime).After(time.Time(h.EndTime)) {
+		h.EndTime = b.EndTime
+	}
+
+	h.Addresses = unionAddresses(a.Addresses, b.Addresses)
+	h.Hostnames = unionHostnames(a.Hostnames, b.Hostnames)
+	h.HostScripts = unionScripts(a.HostScripts, b.HostScripts)
+	h.ExtraPorts = unionExtraPorts(a.ExtraPorts, b.ExtraPorts)
+	h.OS = unionOS(a.OS, b.OS)
+	h.Smurfs = append(append([]nmap.Smurf(nil), a.Smurfs...), b.Smurfs...)
+
+	if h.Distance.Value == 0 {
+		h.Distance = b.Distance
+	}
+	if len(h.Trace.Hops) == 0 {
+		h.Trace = b.Trace
+	}
+	if h.Uptime.Seconds == 0 {
+		h.Uptime = b.Uptime
+	}
+	if h.Times.SRTT == "" {
+		h.Times = b.Times
+	}
+	if h.TCPSequence.Values == "" {
+		h.TCPSequence = b.TCPSequence
+	}
+	if h.TCPTSSequence.Class == "" {
+		h.TCPTSSequence = b.TCPTSSequence
+	}
+	if h.IPIDSequence.Class == "" {
+		h.IPIDSequence = b.IPIDSequence
+	}
+	if h.Comment == "" {
+		h.Comment = b.Comment
+	}
+	return h
+}
+
+// UnionPorts merges two port lists of the same host, see Union. The result
+// is sorted the way nmap lists ports.
+func UnionPorts(a, b []nmap.Port) []nmap.Port {
+	ports := make([]nmap.Port, 0, len(a)+len(b))
+	index := make(map[string]int)
+	for _, list := range [][]nmap.Port{a, b} {
+		for _, p := range list {
+			k := PortKey(p)
+			i, ok := index[k]
+			if !ok {
+				index[k] = len(ports)
+				ports = append(ports, p)
+				continue
+			}
+			ports[i] = unionPort(ports[i], p)
+		}
+	}
+	SortPorts(ports)
+	return ports
+}
+
+// PortKey identifies a port within a host, as "80/tcp".
+func PortKey(p nmap.Port) string {
+	return strconv.Itoa(int(p.ID)) + "/" + p.Protocol
+}
+
+// SortPorts sorts ports the way nmap lists them, by protocol then number.
+func SortPorts(ports []nmap.Port) {
+	sort.SliceStable(ports, func(i, j int) bool {
+		pi, pj := protoRank[ports[i].Protocol], protoRank[ports[j].Protocol]
+		if pi != pj {
+			return pi < pj
+		}
+		return ports[i].ID < ports[j].ID
+	})
+}
+
+// StateWins reports whether port state a ranks above b.
+func StateWins(a, b string) bool {
+	return stateRank[a] > stateRank[b]
+}
+
+// Richer reports whether the service record of port a tells more than that
+// of port b. Probed services beat ones named from the services table, then
+// the record naming the most of product, version, CPEs and scripts wins.
+func Richer(a, b nmap.Port) bool {
+	return richness(a) > richness(b)
+}
+
+func richness(p nmap.Port) int {
+	s := p.Service
+	n := s.Confidence
+	if s.Method == "probed" {
+		n += 100
+	}
+	for _, f := range []string{s.Product, s.Version, s.ExtraInfo, s.OSType, s.DeviceType, s.Tunnel} {
+		if f != "" {
+			n += 10
+		}
+	}
+	n += 5 * len(s.CPEs)
+	n += 5 * len(p.Scripts)
+	return n
+}
+
+// unionPort merges two records of the same port.
+func unionPort(a, b nmap.Port) nmap.Port {
+	p := a
+	if StateWins(b.State.State, a.State.State) {
+		p.State = b.State
+	}
+	if Richer(b, a) {
+		p.Service = b.Service
+		p.Scripts = unionScripts(b.Scripts, a.Scripts)
+	} else {
+		p.Scripts = unionScripts(a.Scripts, b.Scripts)
+	}
+	if p.Owner.Name == "" {
+		p.Owner = b.Owner
+	}
+	return p
+}
+
+// unionScripts merges script results by id, keeping a's for ids in both.
+func unionScripts(a, b []nmap.Script) []nmap.Script {
+	if len(b) == 0 {
+		return a
+	}
+	seen := make(map[string]bool)
+	var out []nmap.Script
+	for _, list := range [][]nmap.Script{a, b} {
+		for _, s := range list {
+			if !seen[s.ID] {
+				seen[s.ID] = true
+				out = append(out, s)
+			}
+		}
+	}
+	return out
+}
+
+func unionAddresses(a, b []nmap.Address) []nmap.Address {
+	seen := make(map[string]bool)
+	var out []nmap.Address
+	for _, list := range [][]nmap.Address{a, b} {
+		for _, addr := range list {
+			if !seen[addr.Addr] {
+				seen[addr.Addr] = true
+				out = append(out, addr)
+			}
+		}
+	}
+	return out
+}
+
+func unionHostnames(a, b []nmap.Hostname) []nmap.Hostname {
+	seen := make(map[nmap.Hostname]bool)
+	var out []nmap.Hostname
+	for _, list := range [][]nmap.Hostname{a, b} {
+		for _, hn := range list {
+			if !seen[hn] {
+				seen[hn] = true
+				out = append(out, hn)
+			}
+		}
+	}
+	return out
+}
+
+// unionExtraPorts merges the counts of ports left out per state. The scans
+// may or may not have covered the same ports, so rather than adding the
+// counts up, the larger of the two is kept, which never over reports.
+func unionExtraPorts(a, b []nmap.ExtraPort) []nmap.ExtraPort {
+	out := append([]nmap.ExtraPort(nil), a...)
+	for _, eb := range b {
+		found := false
+		for i := range out {
+			if out[i].State == eb.State {
+				found = true
+				if eb.Count > out[i].Count {
+					out[i] = eb
+				}
+			}
+		}
+		if !found {
+			out = append(out, eb)
+		}
+	}
+	return out
+}
+
+// unionOS merges OS detection results. Matches are merged by name, keeping
+// the higher accuracy, and listed most accurate first.
+func unionOS(a, b nmap.OS) nmap.OS {
+	merged := a
+	merged.Matches = append([]nmap.OSMatch(nil), a.Matches...)
+	for _, mb := range b.Matches {
+		found := false
+		for i := range merged.Matches {
+			if merged.Matches[i].Name == mb.Name {
+				found = true
+				if mb.Accuracy > merged.Matches[i].Accuracy {
+					merged.Matches[i] = mb
+				}
+			}
+		}
+		if !found {
+			merged.Matches = append(merged.Matches, mb)
+		}
+	}
+	sort.SliceStable(merged.Matches, func(i, j int) bool {
+		return merged.Matches[i].Accuracy > merged.Matches[j].Accuracy
+	})
+
+	seen := make(map[nmap.PortUsed]bool)
+	merged.PortsUsed = nil
+	for _, list := range [][]nmap.PortUsed{a.PortsUsed, b.PortsUsed} {
+		for _, pu := range list {
+			if !seen[pu] {
+				seen[pu] = true
+				merged.PortsUsed = append(merged.PortsUsed, pu)
+			}
+		}
+	}
+
+	fps := make(map[string]bool)
+	merged.Fingerprints = nil
+	for _, list := range [][]nmap.OSFingerprint{a.Fingerprints, b.Fingerprints} {
+		for _, fp := range list {
+			if !fps[fp.Fingerprint] {
+				fps[fp.Fingerprint] = true
+				merged.Fingerprints = append(merged.Fingerprints, fp)
+			}
+		}
+	}
+	return merged
+}
@@ -0,0 +1,150 @@
+package merge
+
+import (
+	"fmt"
+	"strings"
+	"testing"
+
+	nmap "github.com/Ullaakut/nmap/v3"
+)
+
+// tcp, udp and svc build the ports of test hosts.
+func tcp(id uint16, state string) nmap.Port {
+	return nmap.Port{ID: id, Protocol: "tcp", State: nmap.State{State: state}}
+}
+
+func udp(id uint16, state string) nmap.Port {
+	return nmap.Port{ID: id, Protocol: "udp", State: nmap.State{State: state}}
+}
+
+func svc(p nmap.Port, s nmap.Service, scripts ...string) nmap.Port {
+	p.Service = s
+	for _, id := range scripts {
+		p.Scripts = append(p.Scripts, nmap.Script{ID: id})
+	}
+	return p
+}
+
+// portList writes ports as "80/tcp open http nginx [http-title]", leaving
+// out what is empty.
+func portList(ports []nmap.Port) string {
+	var out []string
+	for _, p := range ports {
+		f := []string{PortKey(p), p.State.State}
+		for _, s := range []string{p.Service.Name, p.Service.Product, p.Service.Version} {
+			if s != "" {
+				f = append(f, s)
+			}
+		}
+		if len(p.Scripts) > 0 {
+			var ids []string
+			for _, s := range p.Scripts {
+				ids = append(ids, s.ID)
+			}
+			f = append(f, fmt.Sprint(ids))
+		}
+		out = append(out, strings.Join(f, " "))
+	}
+	return strings.Join(out, ", ")
+}
+
+func upHost(ports ...nmap.Port) nmap.Host {
+	return nmap.Host{
+		Addresses: []nmap.Address{{Addr: "10.0.0.5", AddrType: "ipv4"}},
+		Status:    nmap.Status{State: "up"},
+		Ports:     ports,
+	}
+}
+
+// TestUnionPorts merges the ports of two records of a host, as --merge union
+// does with the default --port-merge richest.
+func TestUnionPorts(t *testing.T) {
+	ssh := nmap.Service{Name: "ssh", Method: "table", Confidence: 3}
+	openssh := nmap.Service{Name: "ssh", Product: "OpenSSH", Version: "8.9p1", Method: "probed", Confidence: 10}
+	tests := []struct {
+		name string
+		a, b nmap.Host
+		want string
+	}{
+		{
+			// nmap -p1-32767 and nmap -p32768-65535 of the same host
+			name: "split port ranges",
+			a:    upHost(tcp(22, "open"), tcp(8080, "open")),
+			b:    upHost(tcp(49152, "open"), tcp(32768, "filtered")),
+			want: "22/tcp open, 8080/tcp open, 32768/tcp filtered, 49152/tcp open",
+		},
+		{
+			// nmap -sS and nmap -sU, the same number on both protocols
+			// being two ports
+			name: "tcp and udp runs",
+			a:    upHost(tcp(53, "open"), tcp(443, "open")),
+			b:    upHost(udp(161, "open|filtered"), udp(53, "open")),
+			want: "53/tcp open, 443/tcp open, 53/udp open, 161/udp open|filtered",
+		},
+		{
+			name: "same port, richer service second",
+			a:    upHost(svc(tcp(22, "open"), ssh, "banner")),
+			b:    upHost(svc(tcp(22, "open"), openssh, "ssh-hostkey")),
+			want: "22/tcp open ssh OpenSSH 8.9p1 [ssh-hostkey banner]",
+		},
+		{
+			name: "same port, richer service first",
+			a:    upHost(svc(tcp(22, "open"), openssh)),
+			b:    upHost(svc(tcp(22, "filtered"), ssh, "banner")),
+			want: "22/tcp open ssh OpenSSH 8.9p1 [banner]",
+		},
+		{
+			// the state and the service are taken on their own
+			name: "same port, better state and richer service apart",
+			a:    upHost(svc(tcp(22, "filtered"), openssh)),
+			b:    upHost(svc(tcp(22, "open"), ssh)),
+			want: "22/tcp open ssh OpenSSH 8.9p1",
+		},
+	}
+	for _, tt := range tests {
+		if got := portList(Union(tt.a, tt.b).Ports); got != tt.want {
+			t.Errorf("%s: Union ports\n got: %s\nwant: %s", tt.name, got, tt.want)
+		}
+
+		// the merger comes to the same ports
+		m, err := NewMerger("union", "richest")
+		if err != nil {
+			t.Fatal(err)
+		}
+		m.Add("10.0.0.5", tt.a, "a.xml")
+		m.Add("10.0.0.5", tt.b, "b.xml")
+		if got := portList(m.Hosts()["10.0.0.5"].Ports); got != tt.want {
+			t.Errorf("%s: merged ports\n got: %s\nwant: %s", tt.name, got, tt.want)
+		}
+	}
+}
+
+// TestUnionHost merges everything but the ports of two records of a host.
+func TestUnionHost(t *testing.T) {
+	a := upHost(tcp(22, "open"))
+	a.Status = nmap.Status{State: "down"}
+	a.Hostnames = []nmap.Hostname{{Name: "web.example.com", Type: "PTR"}}
+	a.ExtraPorts = []nmap.ExtraPort{{State: "closed", Count: 32765}}
+	a.OS.Matches = []nmap.OSMatch{{Name: "Linux 5.X", Accuracy: 90}}
+
+	b := upHost(udp(53, "open"))
+	b.Addresses = append(b.Addresses, nmap.Address{Addr: "00:50:56:AA:BB:CC", AddrType: "mac"})
+	b.Hostnames = []nmap.Hostname{{Name: "web.example.com", Type: "PTR"}, {Name: "www.example.com", Type: "user"}}
+	b.ExtraPorts = []nmap.ExtraPort{{State: "closed", Count: 32766}, {State: "filtered", Count: 2}}
+	b.OS.Matches = []nmap.OSMatch{{Name: "Linux 5.X", Accuracy: 85}, {Name: "Linux 6.X", Accuracy: 95}}
+
+	h := Union(a, b)
+	if h.Status.State != "up" {
+		t.Errorf("state %q, want up", h.Status.State)
+	}
+	if len(h.Addresses) != 2 || len(h.Hostnames) != 2 {
+		t.Errorf("addresses %v hostnames %v", h.Addresses, h.Hostnames)
+	}
+	// the larger count of each state is kept, the scans may overlap
+	if fmt.Sprint(h.ExtraPorts) != fmt.Sprint([]nmap.ExtraPort{{State: "closed", Count: 32766}, {State: "filtered", Count: 2}}) {
+		t.Errorf("extraports %+v", h.ExtraPorts)
+	}
+	if m := h.OS.Matches; len(m) != 2 || m[0].Name != "Linux 6.X" || m[1].Accuracy != 90 {
+		t.Errorf("os matches %+v", m)
+	}
+}
@@ -0,0 +1,240 @@
+// Package nmapxml reads and writes Nmap XML documents one host at a time, so
+// that scans of any size can be processed in bounded memory.
+package nmapxml
+
+import (
+	"encoding/xml"
+	"errors"
+	"fmt"
+	"io"
+	"time"
+
+	nmap "github.com/Ullaakut/nmap/v3"
+)
+
+// ErrNotNmap is returned when a document's root element is not <nmaprun>.
+var ErrNotNmap = errors.New("not an nmap XML document")
+
+// Decoder reads the hosts of an Nmap XML document one at a time.
+type Decoder struct {
+	// Run holds the run level metadata read so far. Run.Hosts is never
+	// populated, and elements that follow the hosts, such as the run stats,
+	// are only present once Next has returned io.EOF.
+	Run nmap.Run
+	// ScanInfo holds every scaninfo element, nmap writes one per scan type.
+	// Run.ScanInfo only holds the last.
+	ScanInfo []nmap.ScanInfo
+
+	// Recover makes Next treat a document that ends early, as it does when
+	// nmap is killed, as complete. The hosts read up to that point are kept,
+	// a host that was cut off is moved to Partial and missing run stats are
+	// synthesized from the hosts seen.
+	Recover bool
+	// Truncated holds the error that was recovered from, if any.
+	Truncated error
+	// Partial holds hosts that were only partially written.
+	Partial []nmap.Host
+
+	d        *xml.Decoder
+	started  bool
+	done     bool
+	up, down int
+	last     nmap.Timestamp
+}
+
+// NewDecoder returns a decoder reading from r.
+func NewDecoder(r io.Reader) *Decoder {
+	return &Decoder{d: xml.NewDecoder(r)}
+}
+
+// Next returns the next host in the document. It returns io.EOF once the
+// closing </nmaprun> has been read.
+func (d *Decoder) Next() (nmap.Host, error) {
+	var hst nmap.Host
+	if d.done {
+		return hst, io.EOF
+	}
+
+	for {
+		tok, err := d.d.Token()
+		if err == io.EOF {
+			if !d.started {
+				return hst, ErrNotNmap
+			}
+			err = io.ErrUnexpectedEOF
+		}
+		if err != nil {
+			return hst, d.fail(err, nil)
+		}
+
+		switch t := tok.(type) {
+		case xml.StartElement:
+			if !d.started {
+				if t.Name.Local != "nmaprun" {
+					return hst, ErrNotNmap
+				}
+				d.started = true
+				if err := d.runAttrs(t.Attr); err != nil {
+					return hst, err
+				}
+				continue
+			}
+			if t.Name.Local == "host" {
+				if err := d.d.DecodeElement(&hst, &t); err != nil {
+					return nmap.Host{}, d.fail(err, &hst)
+				}
+				d.seen(&hst)
+				return hst, nil
+			}
+			if err := d.runElement(&t); err != nil {
+				return hst, d.fail(err, nil)
+			}
+		case xml.EndElement:
+			if t.Name.Local == "nmaprun" {
+				d.done = true
+				return hst, io.EOF
+			}
+		}
+	}
+}
+
+// fail handles an error hit part way through the document. Without Recover,
+// or before <nmaprun> has been seen, the error is returned as is. Otherwise
+// the document is closed off, partial is recorded if it holds anything, and
+// io.EOF is returned.
+func (d *Decoder) fail(err error, partial *nmap.Host) error {
+	if !d.Recover || !d.started {
+		return err
+	}
+	d.done = true
+	d.Truncated = err
+	if partial != nil && len(partial.Addresses) > 0 {
+		d.Partial = append(d.Partial, *partial)
+	}
+	if time.Time(d.Run.Stats.Finished.Time).IsZero() {
+		d.synthesizeStats()
+	}
+	return io.EOF
+}
+
+// seen keeps track of the hosts handed out, for synthesizing run stats.
+func (d *Decoder) seen(h *nmap.Host) {
+	if h.Status.State == "up" {
+		d.up++
+	} else {
+		d.down++
+	}
+	if time.Time(h.EndTime).After(time.Time(d.last)) {
+		d.last = h.EndTime
+	}
+}
+
+// synthesizeStats fills in the run stats of a document that was cut off
+// before nmap wrote them. The finish time is taken from the last host to
+// complete, falling back to the start of the run.
+func (d *Decoder) synthesizeStats() {
+	r := &d.Run
+	end := d.last
+	if time.Time(end).IsZero() {
+		end = r.Start
+	}
+
+	var elapsed float32
+	var timestr string
+	if !time.Time(end).IsZero() {
+		elapsed = float32(time.Time(end).Sub(time.Time(r.Start)).Seconds())
+		timestr = time.Time(end).Format(time.ANSIC)
+	}
+
+	r.Stats.Finished = nmap.Finished{
+		Time:     end,
+		TimeStr:  timestr,
+		Elapsed:  elapsed,
+		Summary:  fmt.Sprintf("Recovered from interrupted scan; %d hosts (%d up) written before it stopped", d.up+d.down, d.up),
+		Exit:     "error",
+		ErrorMsg: d.Truncated.Error(),
+	}
+	r.Stats.Hosts = nmap.HostStats{Up: d.up, Down: d.down, Total: d.up + d.down}
+}
+
+// runAttrs reads the attributes of the <nmaprun> element.
+func (d *Decoder) runAttrs(attrs []xml.Attr) error {
+	r := &d.Run
+	r.XMLName = xml.Name{Local: "nmaprun"}
+	for _, a := range attrs {
+		switch a.Name.Local {
+		case "args":
+			r.Args = a.Value
+		case "profile_name":
+			r.ProfileName = a.Value
+		case "scanner":
+			r.Scanner = a.Value
+		case "startstr":
+			r.StartStr = a.Value
+		case "version":
+			r.Version = a.Value
+		case "xmloutputversion":
+			r.XMLOutputVersion = a.Value
+		case "start":
+			if err := r.Start.ParseTime(a.Value); err != nil {
+				return fmt.Errorf("invalid start time: %w", err)
+			}
+		}
+	}
+	return nil
+}
+
+// runElement decodes a child of <nmaprun> other than a host into Run.
+func (d *Decoder) runElement(t *xml.StartElement) error {
+	r := &d.Run
+	switch t.Name.Local {
+	case "scaninfo":
+		if err := d.d.DecodeElement(&r.ScanInfo, t); err != nil {
+			return err
+		}
+		d.ScanInfo = append(d.ScanInfo, r.ScanInfo)
+	case "verbose":
+		return d.d.DecodeElement(&r.Verbose, t)
+	case "debugging":
+		return d.d.DecodeElement(&r.Debugging, t)
+	case "runstats":
+		return d.d.DecodeElement(&r.Stats, t)
+	case "target":
+		var v nmap.Target
+		if err := d.d.DecodeElement(&v, t); err != nil {
+			return err
+		}
+		r.Targets = append(r.Targets, v)
+	case "taskbegin", "taskend":
+		var v nmap.Task
+		if err := d.d.DecodeElement(&v, t); err != nil {
+			return err
+		}
+		if t.Name.Local == "taskbegin" {
+			r.TaskBegin = append(r.TaskBegin, v)
+		} else {
+			r.TaskEnd = append(r.TaskEnd, v)
+		}
+	case "taskprogress":
+		var v nmap.TaskProgress
+		if err := d.d.DecodeElement(&v, t); err != nil {
+			return err
+		}
+		r.TaskProgress = append(r.TaskProgress, v)
+	case "prescript", "postscript":
+		var v struct {
+			Scripts []nmap.Script `xml:"script"`
+		}
+		if err := d.d.DecodeElement(&v, t); err != nil {
+			return err
+		}
+		if t.Name.Local == "prescript" {
+			r.PreScripts = append(r.PreScripts, v.Scripts...)
+		} else {
+			r.PostScripts = append(r.PostScripts, v.Scripts...)
+		}
+	default:
+		return d.d.Skip()
+	}
+	return nil
+}
@@ -0,0 +1,123 @@
+package nmapxml
+
+import (
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	nmap "github.com/Ullaakut/nmap/v3"
+)
+
+// decodeAll reads every host of doc, returning the decoder to look at what
+// was recovered.
+func decodeAll(doc string, recover bool) (*Decoder, []string, error) {
+	d := NewDecoder(strings.NewReader(doc))
+	d.Recover = recover
+	var addrs []string
+	for {
+		h, err := d.Next()
+		if err == io.EOF {
+			return d, addrs, nil
+		}
+		if err != nil {
+			return d, addrs, err
+		}
+		addrs = append(addrs, h.Addresses[0].Addr)
+	}
+}
+
+// TestRecover salvages the complete hosts of os.xml cut off as nmap leaves
+// it when killed part way through the scan.
+func TestRecover(t *testing.T) {
+	b, err := os.ReadFile(filepath.Join("testdata", "os.xml"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	doc := string(b)
+	cut := func(marker string) string {
+		i := strings.Index(doc, marker)
+		if i < 0 {
+			t.Fatalf("%q not in os.xml", marker)
+		}
+		return doc[:i]
+	}
+	// the end times of the two hosts
+	first, second := time.Unix(1696244412, 0), time.Unix(1696244418, 0)
+
+	tests := []struct {
+		name    string
+		doc     string
+		hosts   string
+		partial string
+		stats   nmap.HostStats
+		end     time.Time
+	}{
+		{
+			name:    "inside a host",
+			doc:     cut(`<address addr="10.10.0.2"`) + `<address addr="10.10.0.2" addrtype="ipv4"/>` + "\n<ports><port protoc",
+			hosts:   "[10.10.0.1]",
+			partial: "[10.10.0.2]",
+			stats:   nmap.HostStats{Up: 1, Total: 1},
+			end:     first,
+		},
+		{
+			name:    "between hosts",
+			doc:     cut(`<host starttime="1696244400" endtime="1696244418">`),
+			hosts:   "[10.10.0.1]",
+			partial: "[]",
+			stats:   nmap.HostStats{Up: 1, Total: 1},
+			end:     first,
+		},
+		{
+			name:    "before the run stats",
+			doc:     cut("<runstats>"),
+			hosts:   "[10.10.0.1 10.10.0.2]",
+			partial: "[]",
+			stats:   nmap.HostStats{Up: 2, Total: 2},
+			end:     second,
+		},
+	}
+	for _, tt := range tests {
+		if _, _, err := decodeAll(tt.doc, false); err == nil {
+			t.Errorf("%s: no error without Recover", tt.name)
+		}
+
+		d, hosts, err := decodeAll(tt.doc, true)
+		if err != nil {
+			t.Errorf("%s: %v", tt.name, err)
+			continue
+		}
+		if fmt.Sprint(hosts) != tt.hosts {
+			t.Errorf("%s: hosts %v, want %s", tt.name, hosts, tt.hosts)
+		}
+		var partial []string
+		for _, h := range d.Partial {
+			partial = append(partial, h.Addresses[0].Addr)
+		}
+		if fmt.Sprint(partial) != tt.partial {
+			t.Errorf("%s: partial hosts %v, want %s", tt.name, partial, tt.partial)
+		}
+		if d.Truncated == nil {
+			t.Errorf("%s: Truncated not set", tt.name)
+		}
+
+		// the run stats are made up from the hosts read
+		s := d.Run.Stats
+		if s.Hosts != tt.stats || s.Finished.Exit != "error" || !time.Time(s.Finished.Time).Equal(tt.end) {
+			t.Errorf("%s: stats %+v", tt.name, s)
+		}
+	}
+
+	// a complete document keeps its own run stats
+	d, hosts, err := decodeAll(doc, true)
+	if err != nil || len(hosts) != 2 || d.Truncated != nil || len(d.Partial) != 0 {
+		t.Fatalf("complete: hosts %v partial %v truncated %v err %v", hosts, d.Partial, d.Truncated, err)
+	}
+	if s := d.Run.Stats; s.Hosts.Total != 4 || s.Finished.Exit != "success" {
+		t.Errorf("complete: stats %+v", s)
+	}
+}
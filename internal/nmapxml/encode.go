@@ -0,0 +1,550 @@
+package nmapxml
+
+import (
+	"bufio"
+	"encoding/xml"
+	"fmt"
+	"io"
+	"strconv"
+	"strings"
+	"time"
+
+	nmap "github.com/Ullaakut/nmap/v3"
+)
+
+// DefaultStylesheet is the stylesheet referenced by documents written with
+// NewWriter.
+const DefaultStylesheet = "file:///usr/share/nmap/nmap.xsl"
+
+// DefaultDocType is the document type declared by documents written with
+// NewWriter, the one nmap declares.
+const DefaultDocType = "nmaprun"
+
+// Writer writes an Nmap XML document one host at a time, laid out the way
+// nmap itself writes it: the same elements in the same order, attributes in
+// nmap's order, elements and attributes nmap would leave out left out, and
+// times as UNIX timestamps. Call WriteHeader once, WriteHost for every host
+// and finally Close.
+type Writer struct {
+	// DocType is declared in the document prolog as <!DOCTYPE DocType>, left
+	// out if empty.
+	DocType string
+	// Stylesheet is referenced in the document prolog, left out if empty.
+	Stylesheet string
+	// Comment is written as an XML comment ahead of <nmaprun>, left out if empty.
+	Comment string
+	// ScanInfo, if set, is written in place of the run's own scaninfo, for
+	// documents covering more than one type of scan.
+	ScanInfo []nmap.ScanInfo
+
+	w   *bufio.Writer
+	err error
+}
+
+// NewWriter returns a writer writing to w.
+func NewWriter(w io.Writer) *Writer {
+	return &Writer{
+		DocType:    DefaultDocType,
+		Stylesheet: DefaultStylesheet,
+		w:          bufio.NewWriter(w),
+	}
+}
+
+// WriteHeader writes the document prolog, the opening <nmaprun> tag and the
+// run level elements that precede the hosts.
+func (w *Writer) WriteHeader(r *nmap.Run) error {
+	w.str(xml.Header)
+	if w.DocType != "" {
+		w.str("<!DOCTYPE " + w.DocType + ">\n")
+	}
+	if w.Stylesheet != "" {
+		w.str(`<?xml-stylesheet href="`)
+		w.str(Escape(w.Stylesheet))
+		w.str(`" type="text/xsl"?>` + "\n")
+	}
+	if w.Comment != "" {
+		w.comment(w.Comment)
+	}
+
+	w.str("<nmaprun")
+	w.attr("scanner", r.Scanner)
+	w.attr("args", r.Args)
+	w.timeAttr("start", r.Start)
+	w.attr("startstr", r.StartStr)
+	w.attr("version", r.Version)
+	w.attr("xmloutputversion", r.XMLOutputVersion)
+	w.optAttr("profile_name", r.ProfileName)
+	w.str(">\n")
+
+	infos := w.ScanInfo
+	if infos == nil {
+		infos = []nmap.ScanInfo{r.ScanInfo}
+	}
+	for _, si := range infos {
+		if si.Type == "" && si.Protocol == "" {
+			continue
+		}
+		w.str("<scaninfo")
+		w.optAttr("type", si.Type)
+		w.optAttr("scanflags", si.ScanFlags)
+		w.attr("protocol", si.Protocol)
+		w.attr("numservices", strconv.Itoa(si.NumServices))
+		w.attr("services", si.Services)
+		w.str("/>\n")
+	}
+	w.str("<verbose")
+	w.attr("level", strconv.Itoa(r.Verbose.Level))
+	w.str("/>\n<debugging")
+	w.attr("level", strconv.Itoa(r.Debugging.Level))
+	w.str("/>\n")
+
+	for _, t := range r.Targets {
+		w.str("<target")
+		w.attr("specification", t.Specification)
+		w.attr("status", t.Status)
+		w.attr("reason", t.Reason)
+		w.str("/>\n")
+	}
+	if len(r.PreScripts) > 0 {
+		w.str("<prescript>")
+		w.scripts(r.PreScripts)
+		w.str("</prescript>\n")
+	}
+	w.tasks(r)
+	return w.err
+}
+
+// tasks writes the task records of a run. The run keeps them in a list per
+// kind, nmap writes them as they happen, so they are merged back in time
+// order. At the same second a task that is running ends before the next
+// one begins.
+func (w *Writer) tasks(r *nmap.Run) {
+	begins, progress, ends := r.TaskBegin, r.TaskProgress, r.TaskEnd
+	running := make(map[string]int)
+	unix := func(t nmap.Timestamp) int64 { return time.Time(t).Unix() }
+	for len(begins)+len(progress)+len(ends) > 0 {
+		// the earliest of the three next records, ties broken in the order
+		// a running task's end, a begin, progress, any other end
+		next, at := -1, int64(0)
+		try := func(kind int, t nmap.Timestamp) {
+			if next < 0 || unix(t) < at {
+				next, at = kind, unix(t)
+			}
+		}
+		if len(ends) > 0 && running[ends[0].Task] > 0 {
+			try(2, ends[0].Time)
+		}
+		if len(begins) > 0 {
+			try(0, begins[0].Time)
+		}
+		if len(progress) > 0 {
+			try(1, progress[0].Time)
+		}
+		if len(ends) > 0 {
+			try(2, ends[0].Time)
+		}
+
+		switch next {
+		case 0:
+			w.task("taskbegin", begins[0])
+			running[begins[0].Task]++
+			begins = begins[1:]
+		case 1:
+			t := progress[0]
+			w.str("<taskprogress")
+			w.attr("task", t.Task)
+			w.timeAttr("time", t.Time)
+			w.attr("percent", fmt.Sprintf("%.2f", t.Percent))
+			w.attr("remaining", strconv.Itoa(t.Remaining))
+			w.timeAttr("etc", t.Etc)
+			w.str("/>\n")
+			progress = progress[1:]
+		case 2:
+			w.task("taskend", ends[0])
+			running[ends[0].Task]--
+			ends = ends[1:]
+		}
+	}
+}
+
+// WriteHost writes a single <host> element.
+func (w *Writer) WriteHost(h *nmap.Host) error {
+	w.str("<host")
+	w.timeAttr("starttime", h.StartTime)
+	w.timeAttr("endtime", h.EndTime)
+	if h.TimedOut {
+		w.attr("timedout", "true")
+	}
+	w.optAttr("comment", h.Comment)
+	w.str("><status")
+	w.attr("state", h.Status.State)
+	w.attr("reason", h.Status.Reason)
+	w.attr("reason_ttl", strconv.Itoa(int(h.Status.ReasonTTL)))
+	w.str("/>\n")
+
+	for _, a := range h.Addresses {
+		w.str("<address")
+		w.attr("addr", a.Addr)
+		w.attr("addrtype", a.AddrType)
+		w.optAttr("vendor", a.Vendor)
+		w.str("/>\n")
+	}
+	w.str("<hostnames>\n")
+	for _, hn := range h.Hostnames {
+		w.str("<hostname")
+		w.attr("name", hn.Name)
+		w.attr("type", hn.Type)
+		w.str("/>\n")
+	}
+	w.str("</hostnames>\n")
+	for _, s := range h.Smurfs {
+		w.str("<smurf")
+		w.attr("responses", s.Responses)
+		w.str("/>\n")
+	}
+
+	if len(h.Ports) > 0 || len(h.ExtraPorts) > 0 {
+		w.str("<ports>")
+		for _, ep := range h.ExtraPorts {
+			w.str("<extraports")
+			w.attr("state", ep.State)
+			w.attr("count", strconv.Itoa(ep.Count))
+			w.str(">\n")
+			for _, r := range ep.Reasons {
+				w.str("<extrareasons")
+				w.attr("reason", r.Reason)
+				w.attr("count", strconv.Itoa(r.Count))
+				w.str("/>\n")
+			}
+			w.str("</extraports>\n")
+		}
+		for i := range h.Ports {
+			w.port(&h.Ports[i])
+		}
+		w.str("</ports>\n")
+	}
+
+	w.os(&h.OS)
+	if h.Uptime.Seconds != 0 || h.Uptime.Lastboot != "" {
+		w.str("<uptime")
+		w.attr("seconds", strconv.Itoa(h.Uptime.Seconds))
+		w.optAttr("lastboot", h.Uptime.Lastboot)
+		w.str("/>\n")
+	}
+	if h.Distance.Value != 0 {
+		w.str("<distance")
+		w.attr("value", strconv.Itoa(h.Distance.Value))
+		w.str("/>\n")
+	}
+	if s := h.TCPSequence; s.Values != "" || s.Difficulty != "" {
+		w.str("<tcpsequence")
+		w.attr("index", strconv.Itoa(s.Index))
+		w.attr("difficulty", s.Difficulty)
+		w.attr("values", s.Values)
+		w.str("/>\n")
+	}
+	w.sequence("ipidsequence", nmap.Sequence(h.IPIDSequence))
+	w.sequence("tcptssequence", nmap.Sequence(h.TCPTSSequence))
+	if len(h.HostScripts) > 0 {
+		w.str("<hostscript>")
+		w.scripts(h.HostScripts)
+		w.str("</hostscript>\n")
+	}
+	if len(h.Trace.Hops) > 0 {
+		w.str("<trace")
+		if h.Trace.Port != 0 {
+			w.attr("port", strconv.Itoa(h.Trace.Port))
+		}
+		w.optAttr("proto", h.Trace.Proto)
+		w.str(">\n")
+		for _, hop := range h.Trace.Hops {
+			w.str("<hop")
+			w.attr("ttl", strconv.Itoa(int(hop.TTL)))
+			w.optAttr("ipaddr", hop.IPAddr)
+			w.optAttr("rtt", hop.RTT)
+			w.optAttr("host", hop.Host)
+			w.str("/>\n")
+		}
+		w.str("</trace>\n")
+	}
+	if t := h.Times; t.SRTT != "" || t.RTT != "" || t.To != "" {
+		w.str("<times")
+		w.attr("srtt", t.SRTT)
+		w.attr("rttvar", t.RTT)
+		w.attr("to", t.To)
+		w.str("/>\n")
+	}
+	w.str("</host>\n")
+	return w.err
+}
+
+// WriteComment writes an XML comment. Comments may go anywhere between
+// WriteHeader and Close, such as ahead of the host they describe. As XML
+// does not allow "--" within a comment, the second dash is written as
+// "&#45;", the way nmap does.
+func (w *Writer) WriteComment(text string) error {
+	w.comment(text)
+	return w.err
+}
+
+// Flush writes anything buffered to the underlying writer, so that what was
+// written so far, such as a run of hosts without a header, can be used on
+// its own.
+func (w *Writer) Flush() error {
+	if w.err != nil {
+		return w.err
+	}
+	return w.w.Flush()
+}
+
+// Close writes the elements following the hosts, closes the document and
+// flushes the underlying writer. It does not close the underlying writer.
+func (w *Writer) Close(r *nmap.Run) error {
+	if len(r.PostScripts) > 0 {
+		w.str("<postscript>")
+		w.scripts(r.PostScripts)
+		w.str("</postscript>\n")
+	}
+
+	f := r.Stats.Finished
+	w.str("<runstats><finished")
+	w.timeAttr("time", f.Time)
+	w.attr("timestr", f.TimeStr)
+	w.optAttr("summary", f.Summary)
+	w.attr("elapsed", fmt.Sprintf("%.2f", f.Elapsed))
+	w.attr("exit", f.Exit)
+	w.optAttr("errormsg", f.ErrorMsg)
+	w.str("/><hosts")
+	w.attr("up", strconv.Itoa(r.Stats.Hosts.Up))
+	w.attr("down", strconv.Itoa(r.Stats.Hosts.Down))
+	w.attr("total", strconv.Itoa(r.Stats.Hosts.Total))
+	w.str("/>\n</runstats>\n</nmaprun>\n")
+	if w.err != nil {
+		return w.err
+	}
+	return w.w.Flush()
+}
+
+func (w *Writer) task(name string, t nmap.Task) {
+	w.str("<" + name)
+	w.attr("task", t.Task)
+	w.timeAttr("time", t.Time)
+	w.optAttr("extrainfo", t.ExtraInfo)
+	w.str("/>\n")
+}
+
+func (w *Writer) port(p *nmap.Port) {
+	w.str("<port")
+	w.attr("protocol", p.Protocol)
+	w.attr("portid", strconv.Itoa(int(p.ID)))
+	w.str("><state")
+	w.attr("state", p.State.State)
+	w.attr("reason", p.State.Reason)
+	w.attr("reason_ttl", strconv.Itoa(int(p.State.ReasonTTL)))
+	w.optAttr("reason_ip", p.State.ReasonIP)
+	w.str("/>")
+	if p.Owner.Name != "" {
+		w.str("<owner")
+		w.attr("name", p.Owner.Name)
+		w.str("/>")
+	}
+
+	s := p.Service
+	if s.Name != "" || s.Method != "" {
+		w.str("<service")
+		w.attr("name", s.Name)
+		w.optAttr("product", s.Product)
+		w.optAttr("version", s.Version)
+		w.optAttr("extrainfo", s.ExtraInfo)
+		w.optAttr("hostname", s.Hostname)
+		w.optAttr("ostype", s.OSType)
+		w.optAttr("devicetype", s.DeviceType)
+		w.optAttr("servicefp", s.ServiceFP)
+		w.optAttr("tunnel", s.Tunnel)
+		w.optAttr("method", s.Method)
+		w.attr("conf", strconv.Itoa(s.Confidence))
+		w.optAttr("rpcnum", s.RPCNum)
+		w.optAttr("lowver", s.LowVersion)
+		w.optAttr("highver", s.HighVersion)
+		w.optAttr("proto", s.Proto)
+		w.cpes(s.CPEs, "service")
+	}
+	w.scripts(p.Scripts)
+	w.str("</port>\n")
+}
+
+func (w *Writer) os(o *nmap.OS) {
+	if len(o.PortsUsed) == 0 && len(o.Matches) == 0 && len(o.Fingerprints) == 0 {
+		return
+	}
+	w.str("<os>")
+	for _, pu := range o.PortsUsed {
+		w.str("<portused")
+		w.attr("state", pu.State)
+		w.attr("proto", pu.Proto)
+		w.attr("portid", strconv.Itoa(pu.ID))
+		w.str("/>\n")
+	}
+	for _, m := range o.Matches {
+		w.str("<osmatch")
+		w.attr("name", m.Name)
+		w.attr("accuracy", strconv.Itoa(m.Accuracy))
+		w.attr("line", strconv.Itoa(m.Line))
+		w.str(">\n")
+		for _, c := range m.Classes {
+			w.str("<osclass")
+			w.optAttr("type", c.Type)
+			w.attr("vendor", c.Vendor)
+			w.attr("osfamily", c.Family)
+			w.optAttr("osgen", c.OSGeneration)
+			w.attr("accuracy", strconv.Itoa(c.Accuracy))
+			w.cpes(c.CPEs, "osclass")
+			w.str("\n")
+		}
+		w.str("</osmatch>\n")
+	}
+	for _, fp := range o.Fingerprints {
+		w.str("<osfingerprint")
+		w.attr("fingerprint", fp.Fingerprint)
+		w.str("/>\n")
+	}
+	w.str("</os>\n")
+}
+
+// cpes closes the opening tag of the element name, holding its CPEs if it
+// has any.
+func (w *Writer) cpes(cpes []nmap.CPE, name string) {
+	if len(cpes) == 0 {
+		w.str("/>")
+		return
+	}
+	w.str(">")
+	for _, c := range cpes {
+		w.str("<cpe>")
+		w.str(Escape(string(c)))
+		w.str("</cpe>")
+	}
+	w.str("</" + name + ">")
+}
+
+// sequence writes an IP ID or TCP timestamp sequence. nmap leaves the
+// values out of a sequence the host did not answer in.
+func (w *Writer) sequence(name string, s nmap.Sequence) {
+	if s.Class == "" && s.Values == "" {
+		return
+	}
+	w.str("<" + name)
+	w.attr("class", s.Class)
+	w.optAttr("values", s.Values)
+	w.str("/>\n")
+}
+
+// scripts writes script results. Structured output is written elements
+// first, then tables, as the order they were mixed in is not kept.
+func (w *Writer) scripts(scripts []nmap.Script) {
+	for _, s := range scripts {
+		w.str("<script")
+		w.attr("id", s.ID)
+		w.attr("output", s.Output)
+		if len(s.Elements) == 0 && len(s.Tables) == 0 {
+			w.str("/>")
+			continue
+		}
+		w.str(">")
+		w.elements(s.Elements)
+		w.tables(s.Tables)
+		w.str("</script>")
+	}
+}
+
+// elements writes script output elements. Their values are kept as the
+// document had them, already escaped.
+func (w *Writer) elements(elems []nmap.Element) {
+	for _, e := range elems {
+		w.str("<elem")
+		w.optAttr("key", e.Key)
+		w.str(">" + e.Value + "</elem>\n")
+	}
+}
+
+func (w *Writer) tables(tables []nmap.Table) {
+	for _, t := range tables {
+		w.str("<table")
+		w.optAttr("key", t.Key)
+		w.str(">\n")
+		w.elements(t.Elements)
+		w.tables(t.Tables)
+		w.str("</table>\n")
+	}
+}
+
+func (w *Writer) comment(text string) {
+	var b strings.Builder
+	for i, r := range text {
+		if r == '-' && i > 0 && text[i-1] == '-' {
+			b.WriteString("&#45;")
+			continue
+		}
+		b.WriteRune(r)
+	}
+	w.str("<!-- " + b.String() + " -->\n")
+}
+
+// attr writes a single attribute of an opening tag.
+func (w *Writer) attr(name, value string) {
+	w.str(" " + name + `="`)
+	w.str(Escape(value))
+	w.str(`"`)
+}
+
+// optAttr writes an attribute nmap leaves out when it has no value.
+func (w *Writer) optAttr(name, value string) {
+	if value != "" {
+		w.attr(name, value)
+	}
+}
+
+// timeAttr writes a time as a UNIX timestamp, left out if the time is unset.
+func (w *Writer) timeAttr(name string, t nmap.Timestamp) {
+	if !time.Time(t).IsZero() {
+		w.attr(name, strconv.FormatInt(time.Time(t).Unix(), 10))
+	}
+}
+
+// Escape returns s escaped the way nmap escapes text and attribute values:
+// markup characters as entities, the second of two dashes and anything
+// outside printable ASCII below U+0100 as character references. Other
+// characters are kept as they are.
+func Escape(s string) string {
+	var b strings.Builder
+	prev := rune(0)
+	for _, r := range s {
+		switch {
+		case r == '<':
+			b.WriteString("&lt;")
+		case r == '>':
+			b.WriteString("&gt;")
+		case r == '&':
+			b.WriteString("&amp;")
+		case r == '"':
+			b.WriteString("&quot;")
+		case r == '\'':
+			b.WriteString("&apos;")
+		case r == '-' && prev == '-':
+			b.WriteString("&#45;")
+		case r < 0x20 || (r >= 0x7f && r < 0x100):
+			fmt.Fprintf(&b, "&#x%x;", r)
+		default:
+			b.WriteRune(r)
+		}
+		prev = r
+	}
+	return b.String()
+}
+
+func (w *Writer) str(s string) {
+	if w.err != nil {
+		return
+	}
+	_, w.err = w.w.WriteString(s)
+}
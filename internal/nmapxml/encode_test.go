@@ -0,0 +1,144 @@
+package nmapxml
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strings"
+	"testing"
+
+	nmap "github.com/Ullaakut/nmap/v3"
+)
+
+var (
+	prologStylesheet = regexp.MustCompile(`(?m)^<\?xml-stylesheet href="([^"]*)"`)
+	prologComment    = regexp.MustCompile(`(?m)^<!-- (.*) -->$`)
+)
+
+// TestRoundTrip decodes nmap -oX output and writes it back, which must give
+// back the very same bytes.
+func TestRoundTrip(t *testing.T) {
+	files, err := filepath.Glob(filepath.Join("testdata", "*.xml"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(files) == 0 {
+		t.Fatal("no samples in testdata")
+	}
+	for _, file := range files {
+		t.Run(filepath.Base(file), func(t *testing.T) {
+			want, err := os.ReadFile(file)
+			if err != nil {
+				t.Fatal(err)
+			}
+			got := roundTrip(t, want)
+			if !bytes.Equal(got, want) {
+				t.Error(firstDiff(got, want))
+			}
+		})
+	}
+}
+
+// roundTrip decodes an nmap XML document and encodes it again, taking the
+// stylesheet and the comment ahead of <nmaprun> from the document.
+func roundTrip(t *testing.T, doc []byte) []byte {
+	t.Helper()
+	d := NewDecoder(bytes.NewReader(doc))
+	var hosts []nmap.Host
+	for {
+		h, err := d.Next()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			t.Fatal(err)
+		}
+		hosts = append(hosts, h)
+	}
+
+	var out bytes.Buffer
+	w := NewWriter(&out)
+	w.ScanInfo = d.ScanInfo
+	if m := prologStylesheet.FindSubmatch(doc); m != nil {
+		w.Stylesheet = string(m[1])
+	}
+	if m := prologComment.FindSubmatch(doc); m != nil {
+		w.Comment = strings.ReplaceAll(string(m[1]), "&#45;", "-")
+	}
+	if err := w.WriteHeader(&d.Run); err != nil {
+		t.Fatal(err)
+	}
+	for i := range hosts {
+		if err := w.WriteHost(&hosts[i]); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := w.Close(&d.Run); err != nil {
+		t.Fatal(err)
+	}
+	return out.Bytes()
+}
+
+// firstDiff describes the first line got and want differ on.
+func firstDiff(got, want []byte) string {
+	g := strings.Split(string(got), "\n")
+	w := strings.Split(string(want), "\n")
+	for i := 0; i < len(g) || i < len(w); i++ {
+		var gl, wl string
+		if i < len(g) {
+			gl = g[i]
+		}
+		if i < len(w) {
+			wl = w[i]
+		}
+		if gl != wl {
+			return fmt.Sprintf("line %d:\n got: %s\nwant: %s", i+1, gl, wl)
+		}
+	}
+	return "documents differ"
+}
+
+func TestWriteComment(t *testing.T) {
+	tests := []struct {
+		text string
+		want string
+	}{
+		{"nmap -sV 10.0.0.1", "<!-- nmap -sV 10.0.0.1 -->\n"},
+		{"nmap --top-ports 100", "<!-- nmap -&#45;top-ports 100 -->\n"},
+		{"a---b", "<!-- a-&#45;&#45;b -->\n"},
+		{"-a-", "<!-- -a- -->\n"},
+	}
+	for _, tt := range tests {
+		var out bytes.Buffer
+		w := NewWriter(&out)
+		if err := w.WriteComment(tt.text); err != nil {
+			t.Fatal(err)
+		}
+		if err := w.Flush(); err != nil {
+			t.Fatal(err)
+		}
+		if got := out.String(); got != tt.want {
+			t.Errorf("WriteComment(%q) wrote %q, want %q", tt.text, got, tt.want)
+		}
+	}
+}
+
+func TestEscape(t *testing.T) {
+	tests := []struct {
+		s    string
+		want string
+	}{
+		{`Microsoft "IIS" <httpd> & 'co'`, "Microsoft &quot;IIS&quot; &lt;httpd&gt; &amp; &apos;co&apos;"},
+		{"--script=a--b", "-&#45;script=a-&#45;b"},
+		{"line\r\n\tend\x7f", "line&#xd;&#xa;&#x9;end&#x7f;"},
+		{"café 日本", "caf&#xe9; 日本"},
+	}
+	for _, tt := range tests {
+		if got := Escape(tt.s); got != tt.want {
+			t.Errorf("Escape(%q) = %q, want %q", tt.s, got, tt.want)
+		}
+	}
+}
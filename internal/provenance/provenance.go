@@ -0,0 +1,193 @@
+// Package provenance records which inputs, and which scanner runs, the hosts
+// and ports of a combined scan were taken from. It is kept in a manifest
+// alongside the combined XML, as the XML itself has no place for it that
+// nmap.xsl and importers would accept.
+package provenance
+
+import (
+	"encoding/json"
+	"fmt"
+	"net"
+	"os"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Run describes the scanner run an input holds.
+type Run struct {
+	Scanner string `json:"scanner,omitempty"`
+	Version string `json:"version,omitempty"`
+	Args    string `json:"args,omitempty"`
+	// Start is the start of the run as a UNIX timestamp, zero if unknown.
+	Start int64 `json:"start,omitempty"`
+}
+
+// String describes a run in a single line.
+func (r Run) String() string {
+	var parts []string
+	if r.Args != "" {
+		parts = append(parts, r.Args)
+	} else if r.Scanner != "" {
+		parts = append(parts, r.Scanner)
+	}
+	if r.Start != 0 {
+		parts = append(parts, "started "+time.Unix(r.Start, 0).UTC().Format("2006-01-02 15:04:05 MST"))
+	}
+	if len(parts) == 0 {
+		return "unknown run"
+	}
+	return strings.Join(parts, ", ")
+}
+
+// Host holds the inputs a host, and each of its ports, was taken from.
+type Host struct {
+	// Addresses are the IP addresses of the host besides the one it is keyed by.
+	Addresses []string `json:"addresses,omitempty"`
+	Sources   []string `json:"sources"`
+	// Ports are keyed as "80/tcp".
+	Ports map[string][]string `json:"ports,omitempty"`
+}
+
+// Manifest is the provenance of a combined scan.
+type Manifest struct {
+	// Output is the combined file the manifest describes.
+	Output string `json:"output,omitempty"`
+	// Runs are keyed by input.
+	Runs map[string]Run `json:"runs"`
+	// Hosts are keyed by IP address.
+	Hosts map[string]Host `json:"hosts"`
+}
+
+// New returns an empty manifest for the given output.
+func New(output string) *Manifest {
+	return &Manifest{
+		Output: output,
+		Runs:   make(map[string]Run),
+		Hosts:  make(map[string]Host),
+	}
+}
+
+// Load reads a manifest written by Save.
+func Load(path string) (*Manifest, error) {
+	b, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	m := New("")
+	if err := json.Unmarshal(b, m); err != nil {
+		return nil, fmt.Errorf("%s: not a provenance manifest: %v", path, err)
+	}
+	return m, nil
+}
+
+// Save writes the manifest to path as JSON.
+func (m *Manifest) Save(path string) error {
+	b, err := json.MarshalIndent(m, "", "  ")
+	if err != nil {
+		return err
+	}
+	return os.WriteFile(path, append(b, '\n'), 0644)
+}
+
+// Match is the provenance of a single host or port.
+type Match struct {
+	// Host is the key of the host, Port the port as "80/tcp" or empty for the
+	// host itself.
+	Host, Port string
+	Sources    []string
+}
+
+// Lookup returns the provenance for a query of the form ip, ip:port or
+// ip:port/protocol, IPv6 addresses in brackets when a port is given. A bare
+// ip matches the host and every one of its ports, a port without a protocol
+// matches it for every protocol.
+func (m *Manifest) Lookup(query string) ([]Match, error) {
+	ip, port, proto, err := parseQuery(query)
+	if err != nil {
+		return nil, err
+	}
+	key, h, ok := m.host(ip)
+	if !ok {
+		return nil, fmt.Errorf("%s: no such host", ip)
+	}
+
+	var out []Match
+	if port == "" {
+		out = append(out, Match{Host: key, Sources: h.Sources})
+	}
+	for _, pk := range sortedPorts(h.Ports) {
+		id, p, _ := strings.Cut(pk, "/")
+		if port != "" && (id != port || (proto != "" && p != proto)) {
+			continue
+		}
+		out = append(out, Match{Host: key, Port: pk, Sources: h.Ports[pk]})
+	}
+	if len(out) == 0 {
+		return nil, fmt.Errorf("%s: no such port", query)
+	}
+	return out, nil
+}
+
+// host finds a host by its key or any of its other addresses, however an
+// IPv6 address is written.
+func (m *Manifest) host(ip string) (string, Host, bool) {
+	if h, ok := m.Hosts[ip]; ok {
+		return ip, h, true
+	}
+	same := func(a string) bool {
+		if a == ip {
+			return true
+		}
+		pa, pi := net.ParseIP(a), net.ParseIP(ip)
+		return pa != nil && pa.Equal(pi)
+	}
+	for k, h := range m.Hosts {
+		if same(k) {
+			return k, h, true
+		}
+		for _, a := range h.Addresses {
+			if same(a) {
+				return k, h, true
+			}
+		}
+	}
+	return "", Host{}, false
+}
+
+func parseQuery(q string) (ip, port, proto string, err error) {
+	q = strings.TrimSpace(q)
+	if !strings.HasPrefix(q, "[") && strings.Count(q, ":") != 1 {
+		return q, "", "", nil
+	}
+	if strings.HasPrefix(q, "[") && strings.HasSuffix(q, "]") {
+		return q[1 : len(q)-1], "", "", nil
+	}
+	ip, port, err = net.SplitHostPort(q)
+	if err != nil {
+		return "", "", "", err
+	}
+	port, proto, _ = strings.Cut(port, "/")
+	if _, err := strconv.ParseUint(port, 10, 16); err != nil {
+		return "", "", "", fmt.Errorf("%s: bad port %q", q, port)
+	}
+	return ip, port, proto, nil
+}
+
+// sortedPorts returns port keys by number, then protocol.
+func sortedPorts(ports map[string][]string) []string {
+	keys := make([]string, 0, len(ports))
+	for k := range ports {
+		keys = append(keys, k)
+	}
+	sort.Slice(keys, func(i, j int) bool {
+		ni, _ := strconv.Atoi(strings.SplitN(keys[i], "/", 2)[0])
+		nj, _ := strconv.Atoi(strings.SplitN(keys[j], "/", 2)[0])
+		if ni != nj {
+			return ni < nj
+		}
+		return keys[i] < keys[j]
+	})
+	return keys
+}
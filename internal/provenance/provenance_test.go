@@ -0,0 +1,120 @@
+package provenance
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func testManifest() *Manifest {
+	m := New("combined.xml")
+	m.Runs["a.xml"] = Run{Scanner: "nmap", Args: "nmap -sV -oX a.xml 10.0.0.0/24", Start: 1696240800}
+	m.Runs["b.xml"] = Run{Scanner: "masscan"}
+	m.Hosts["10.0.0.5"] = Host{
+		Addresses: []string{"2001:db8::5"},
+		Sources:   []string{"a.xml", "b.xml"},
+		Ports: map[string][]string{
+			"443/tcp": {"b.xml"},
+			"53/udp":  {"a.xml"},
+			"53/tcp":  {"a.xml", "b.xml"},
+		},
+	}
+	m.Hosts["2001:db8::7"] = Host{
+		Sources: []string{"a.xml"},
+		Ports:   map[string][]string{"22/tcp": {"a.xml"}},
+	}
+	return m
+}
+
+func TestLookup(t *testing.T) {
+	tests := []struct {
+		query string
+		want  []string
+	}{
+		// a host comes with every one of its ports
+		{"10.0.0.5", []string{"10.0.0.5 [a.xml b.xml]", "10.0.0.5 53/tcp [a.xml b.xml]", "10.0.0.5 53/udp [a.xml]", "10.0.0.5 443/tcp [b.xml]"}},
+		{"10.0.0.5:443/tcp", []string{"10.0.0.5 443/tcp [b.xml]"}},
+		// a port without a protocol is looked up for all of them
+		{"10.0.0.5:53", []string{"10.0.0.5 53/tcp [a.xml b.xml]", "10.0.0.5 53/udp [a.xml]"}},
+		// the host is found by its other addresses, however written
+		{"2001:db8::5", []string{"10.0.0.5 [a.xml b.xml]", "10.0.0.5 53/tcp [a.xml b.xml]", "10.0.0.5 53/udp [a.xml]", "10.0.0.5 443/tcp [b.xml]"}},
+		{"[2001:DB8:0::5]:53/udp", []string{"10.0.0.5 53/udp [a.xml]"}},
+		{"2001:db8::7", []string{"2001:db8::7 [a.xml]", "2001:db8::7 22/tcp [a.xml]"}},
+		{"[2001:db8::7]", []string{"2001:db8::7 [a.xml]", "2001:db8::7 22/tcp [a.xml]"}},
+		{"[2001:db8::7]:22/tcp", []string{"2001:db8::7 22/tcp [a.xml]"}},
+	}
+	m := testManifest()
+	for _, tt := range tests {
+		matches, err := m.Lookup(tt.query)
+		if err != nil {
+			t.Errorf("%s: %v", tt.query, err)
+			continue
+		}
+		var got []string
+		for _, match := range matches {
+			s := match.Host
+			if match.Port != "" {
+				s += " " + match.Port
+			}
+			got = append(got, s+" "+fmt.Sprint(match.Sources))
+		}
+		if !reflect.DeepEqual(got, tt.want) {
+			t.Errorf("%s:\n got: %q\nwant: %q", tt.query, got, tt.want)
+		}
+	}
+}
+
+func TestLookupErrors(t *testing.T) {
+	tests := []struct {
+		query string
+		want  string
+	}{
+		{"10.0.0.9", "10.0.0.9: no such host"},
+		{"10.0.0.5:22", "10.0.0.5:22: no such port"},
+		{"10.0.0.5:443/udp", "10.0.0.5:443/udp: no such port"},
+		{"10.0.0.5:http", `10.0.0.5:http: bad port "http"`},
+		{"10.0.0.5:70000", `10.0.0.5:70000: bad port "70000"`},
+	}
+	m := testManifest()
+	for _, tt := range tests {
+		_, err := m.Lookup(tt.query)
+		if err == nil || err.Error() != tt.want {
+			t.Errorf("%s: got error %v, want %q", tt.query, err, tt.want)
+		}
+	}
+}
+
+// TestSaveLoad reads back a manifest as it was saved.
+func TestSaveLoad(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "combined.provenance.json")
+	m := testManifest()
+	if err := m.Save(path); err != nil {
+		t.Fatal(err)
+	}
+	got, err := Load(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(got, m) {
+		t.Errorf("loaded %+v\n want %+v", got, m)
+	}
+	if s := got.Runs["a.xml"].String(); s != "nmap -sV -oX a.xml 10.0.0.0/24, started 2023-10-02 10:00:00 UTC" {
+		t.Errorf("run %q", s)
+	}
+	if s := got.Runs["b.xml"].String(); s != "masscan" {
+		t.Errorf("run %q", s)
+	}
+
+	if _, err := Load(filepath.Join(t.TempDir(), "missing.json")); err == nil {
+		t.Error("loaded a missing manifest")
+	}
+	if err := os.WriteFile(path, []byte("<nmaprun/>"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := Load(path); err == nil || !strings.Contains(err.Error(), "not a provenance manifest") {
+		t.Errorf("got error %v loading XML", err)
+	}
+}
@@ -0,0 +1,297 @@
+package query
+
+import (
+	"fmt"
+	"strings"
+)
+
+type tokenKind int
+
+const (
+	tEOF tokenKind = iota
+	tWord
+	tString
+	tOp
+	tLParen
+	tRParen
+	tComma
+)
+
+type token struct {
+	kind tokenKind
+	text string
+	pos  int
+}
+
+func (t token) String() string {
+	switch t.kind {
+	case tEOF:
+		return "end of expression"
+	case tString:
+		return fmt.Sprintf("%q", t.text)
+	}
+	return "'" + t.text + "'"
+}
+
+// is reports whether t is the keyword kw, which is not case sensitive.
+func (t token) is(kw string) bool {
+	return t.kind == tWord && strings.EqualFold(t.text, kw)
+}
+
+// lex splits an expression into tokens.
+func lex(s string) ([]token, error) {
+	var toks []token
+	i := 0
+	for i < len(s) {
+		c := s[i]
+		switch {
+		case c == ' ' || c == '\t' || c == '\r' || c == '\n':
+			i++
+		case c == '(':
+			toks = append(toks, token{tLParen, "(", i})
+			i++
+		case c == ')':
+			toks = append(toks, token{tRParen, ")", i})
+			i++
+		case c == ',':
+			toks = append(toks, token{tComma, ",", i})
+			i++
+		case c == '"':
+			var b strings.Builder
+			start := i
+			i++
+			for {
+				if i >= len(s) {
+					return nil, fmt.Errorf("unterminated string at %d", start+1)
+				}
+				if s[i] == '\\' && i+1 < len(s) {
+					// \" and \\ are unescaped, anything else is kept as
+					// is, so regular expressions need no double escaping
+					if s[i+1] == '"' || s[i+1] == '\\' {
+						b.WriteByte(s[i+1])
+					} else {
+						b.WriteString(s[i : i+2])
+					}
+					i += 2
+					continue
+				}
+				if s[i] == '"' {
+					i++
+					break
+				}
+				b.WriteByte(s[i])
+				i++
+			}
+			toks = append(toks, token{tString, b.String(), start})
+		case strings.ContainsRune("=!~<>", rune(c)):
+			start := i
+			op := s[i : i+1]
+			if i+1 < len(s) && strings.ContainsRune("=~", rune(s[i+1])) {
+				op = s[i : i+2]
+			}
+			i += len(op)
+			switch op {
+			case "==":
+				op = "="
+			case "=", "!=", "~", "!~", "<", "<=", ">", ">=":
+			default:
+				return nil, fmt.Errorf("unknown operator %q at %d", op, start+1)
+			}
+			toks = append(toks, token{tOp, op, start})
+		default:
+			start := i
+			for i < len(s) && !strings.ContainsRune(" \t\r\n(),\"=!~<>", rune(s[i])) {
+				i++
+			}
+			toks = append(toks, token{tWord, s[start:i], start})
+		}
+	}
+	return append(toks, token{tEOF, "", len(s)}), nil
+}
+
+// Parse parses an expression, see the package documentation.
+func Parse(s string) (*Query, error) {
+	toks, err := lex(s)
+	if err != nil {
+		return nil, err
+	}
+	p := &parser{toks: toks}
+	n, err := p.or()
+	if err != nil {
+		return nil, err
+	}
+	if t := p.peek(); t.kind != tEOF {
+		return nil, p.errorf(t, "unexpected %s", t)
+	}
+	return &Query{src: s, root: n}, nil
+}
+
+// parser is a recursive descent parser over the tokens of an expression.
+type parser struct {
+	toks []token
+	i    int
+}
+
+func (p *parser) peek() token {
+	return p.toks[p.i]
+}
+
+func (p *parser) next() token {
+	t := p.toks[p.i]
+	if t.kind != tEOF {
+		p.i++
+	}
+	return t
+}
+
+func (p *parser) errorf(t token, format string, args ...interface{}) error {
+	return fmt.Errorf("%s at %d", fmt.Sprintf(format, args...), t.pos+1)
+}
+
+// or := and { "or" and }
+func (p *parser) or() (node, error) {
+	n, err := p.and()
+	if err != nil {
+		return nil, err
+	}
+	for p.peek().is("or") {
+		p.next()
+		b, err := p.and()
+		if err != nil {
+			return nil, err
+		}
+		n = or{n, b}
+	}
+	return n, nil
+}
+
+// and := unary { "and" unary }
+func (p *parser) and() (node, error) {
+	n, err := p.unary()
+	if err != nil {
+		return nil, err
+	}
+	for p.peek().is("and") {
+		p.next()
+		b, err := p.unary()
+		if err != nil {
+			return nil, err
+		}
+		n = and{n, b}
+	}
+	return n, nil
+}
+
+// unary := "not" unary | "(" or ")" | comparison
+func (p *parser) unary() (node, error) {
+	t := p.peek()
+	switch {
+	case t.is("not"):
+		p.next()
+		n, err := p.unary()
+		if err != nil {
+			return nil, err
+		}
+		return not{n}, nil
+	case t.kind == tLParen:
+		p.next()
+		n, err := p.or()
+		if err != nil {
+			return nil, err
+		}
+		if t := p.next(); t.kind != tRParen {
+			return nil, p.errorf(t, "expected ')' but found %s", t)
+		}
+		return n, nil
+	}
+	return p.comparison()
+}
+
+// comparison := field op value | field ["not"] "in" "(" value { "," value } ")"
+func (p *parser) comparison() (node, error) {
+	t := p.next()
+	if t.kind != tWord {
+		return nil, p.errorf(t, "expected a field but found %s", t)
+	}
+	name := strings.ToLower(t.text)
+	f, ok := fields[name]
+	if !ok {
+		return nil, p.errorf(t, "unknown field %q", t.text)
+	}
+
+	op := p.next()
+	negate := false
+	if op.is("not") {
+		negate = true
+		op = p.next()
+		if !op.is("in") {
+			return nil, p.errorf(op, "expected 'in' after 'not' but found %s", op)
+		}
+	}
+	if op.is("in") {
+		lits, err := p.list()
+		if err != nil {
+			return nil, err
+		}
+		var preds []func(string) bool
+		for _, lit := range lits {
+			pred, err := equals(name, f, lit.text)
+			if err != nil {
+				return nil, p.errorf(lit, "%v", err)
+			}
+			preds = append(preds, pred)
+		}
+		return compare{f: f, negate: negate, pred: func(v string) bool {
+			for _, pred := range preds {
+				if pred(v) {
+					return true
+				}
+			}
+			return false
+		}}, nil
+	}
+	if op.kind != tOp {
+		return nil, p.errorf(op, "expected an operator after %s but found %s", name, op)
+	}
+
+	lit := p.next()
+	if lit.kind != tWord && lit.kind != tString {
+		return nil, p.errorf(lit, "expected a value after %s but found %s", op.text, lit)
+	}
+	var pred func(string) bool
+	var err error
+	switch op.text {
+	case "=", "!=":
+		pred, err = equals(name, f, lit.text)
+	case "~", "!~":
+		pred, err = matches(lit.text)
+	default:
+		pred, err = ordered(name, f, op.text, lit.text)
+	}
+	if err != nil {
+		return nil, p.errorf(lit, "%v", err)
+	}
+	return compare{f: f, pred: pred, negate: op.text == "!=" || op.text == "!~"}, nil
+}
+
+// list := "(" value { "," value } ")"
+func (p *parser) list() ([]token, error) {
+	if t := p.next(); t.kind != tLParen {
+		return nil, p.errorf(t, "expected '(' after 'in' but found %s", t)
+	}
+	var lits []token
+	for {
+		lit := p.next()
+		if lit.kind != tWord && lit.kind != tString {
+			return nil, p.errorf(lit, "expected a value but found %s", lit)
+		}
+		lits = append(lits, lit)
+		t := p.next()
+		if t.kind == tRParen {
+			return lits, nil
+		}
+		if t.kind != tComma {
+			return nil, p.errorf(t, "expected ',' or ')' but found %s", t)
+		}
+	}
+}
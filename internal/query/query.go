@@ -0,0 +1,291 @@
+// Package query implements the expression language used to pick hosts and
+// ports out of scans, such as
+//
+//	port in (80,443) and service ~ "http" and state = open and not os ~ "Windows"
+//
+// An expression is made of comparisons between a field and a value, joined
+// with and, or, not and parentheses. Fields either describe the host, such as
+// ip or os, or one of its ports, such as port or service. An expression is
+// evaluated against each port of a host in turn, host fields reading the same
+// for every port, and the host matches if it holds for any of them. A host
+// without ports is evaluated once, its port fields holding no value.
+//
+// Comparisons are:
+//
+//	field = value        equal, ignoring case, an ip also equals a CIDR that holds it
+//	field != value       not equal
+//	field ~ regexp       matches the regular expression, ignoring case
+//	field !~ regexp      does not match
+//	field < number       also <=, > and >=, for numeric fields only
+//	field in (a, b, ...) equal to any of the values
+//
+// A field with more than one value, such as hostname, compares true if any of
+// its values does, and != and !~ hold when none of them do. Values are bare
+// words or double quoted strings.
+package query
+
+import (
+	"fmt"
+	"net"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+
+	nmap "github.com/Ullaakut/nmap/v3"
+	"github.com/redt1de/pnmap/internal/hostid"
+)
+
+// field is something about a host or one of its ports an expression can
+// compare.
+type field struct {
+	// port is set for fields of a port, which hold no value without one.
+	port    bool
+	numeric bool
+	help    string
+	values  func(h *nmap.Host, p *nmap.Port) []string
+}
+
+var fields = map[string]field{
+	"ip": {help: "IPv4 and IPv6 address of the host", values: func(h *nmap.Host, p *nmap.Port) []string {
+		return nonEmpty(hostid.IPv4(*h), hostid.IPv6(*h))
+	}},
+	"mac": {help: "MAC address of the host", values: func(h *nmap.Host, p *nmap.Port) []string {
+		return nonEmpty(hostid.MAC(*h))
+	}},
+	"vendor": {help: "vendor of the MAC address", values: func(h *nmap.Host, p *nmap.Port) []string {
+		var out []string
+		for _, a := range h.Addresses {
+			out = append(out, a.Vendor)
+		}
+		return nonEmpty(out...)
+	}},
+	"hostname": {help: "hostnames of the host", values: func(h *nmap.Host, p *nmap.Port) []string {
+		var out []string
+		for _, hn := range h.Hostnames {
+			out = append(out, hn.Name)
+		}
+		return nonEmpty(out...)
+	}},
+	"status": {help: "up or down", values: func(h *nmap.Host, p *nmap.Port) []string {
+		return nonEmpty(h.Status.State)
+	}},
+	"os": {help: "OS matches of the host", values: func(h *nmap.Host, p *nmap.Port) []string {
+		var out []string
+		for _, m := range h.OS.Matches {
+			out = append(out, m.Name)
+		}
+		return nonEmpty(out...)
+	}},
+	"script": {help: "ids of the scripts run against the host or port", values: func(h *nmap.Host, p *nmap.Port) []string {
+		var out []string
+		for _, s := range h.HostScripts {
+			out = append(out, s.ID)
+		}
+		if p != nil {
+			for _, s := range p.Scripts {
+				out = append(out, s.ID)
+			}
+		}
+		return nonEmpty(out...)
+	}},
+	"port": {port: true, numeric: true, help: "port number", values: func(h *nmap.Host, p *nmap.Port) []string {
+		return []string{strconv.Itoa(int(p.ID))}
+	}},
+	"proto": {port: true, help: "tcp, udp, sctp or ip", values: func(h *nmap.Host, p *nmap.Port) []string {
+		return nonEmpty(p.Protocol)
+	}},
+	"state": {port: true, help: "port state, such as open or filtered", values: func(h *nmap.Host, p *nmap.Port) []string {
+		return nonEmpty(p.State.State)
+	}},
+	"reason": {port: true, help: "reason for the port state, such as syn-ack", values: func(h *nmap.Host, p *nmap.Port) []string {
+		return nonEmpty(p.State.Reason)
+	}},
+	"service": {port: true, help: "service name", values: func(h *nmap.Host, p *nmap.Port) []string {
+		return nonEmpty(p.Service.Name)
+	}},
+	"product": {port: true, help: "product name from version detection", values: func(h *nmap.Host, p *nmap.Port) []string {
+		return nonEmpty(p.Service.Product)
+	}},
+	"version": {port: true, help: "product version from version detection", values: func(h *nmap.Host, p *nmap.Port) []string {
+		return nonEmpty(p.Service.Version)
+	}},
+	"extrainfo": {port: true, help: "extra information from version detection", values: func(h *nmap.Host, p *nmap.Port) []string {
+		return nonEmpty(p.Service.ExtraInfo)
+	}},
+	"tunnel": {port: true, help: "tunnel the service runs over, such as ssl", values: func(h *nmap.Host, p *nmap.Port) []string {
+		return nonEmpty(p.Service.Tunnel)
+	}},
+	"cpe": {port: true, help: "CPEs of the service", values: func(h *nmap.Host, p *nmap.Port) []string {
+		var out []string
+		for _, c := range p.Service.CPEs {
+			out = append(out, string(c))
+		}
+		return nonEmpty(out...)
+	}},
+}
+
+// Fields describes every field an expression may use, one per line.
+func Fields() []string {
+	var out []string
+	for name, f := range fields {
+		out = append(out, fmt.Sprintf("%-10s %s", name, f.help))
+	}
+	sort.Strings(out)
+	return out
+}
+
+func nonEmpty(vals ...string) []string {
+	var out []string
+	for _, v := range vals {
+		if v != "" {
+			out = append(out, v)
+		}
+	}
+	return out
+}
+
+// Query is a parsed expression.
+type Query struct {
+	src  string
+	root node
+}
+
+// String returns the expression the query was parsed from.
+func (q *Query) String() string {
+	return q.src
+}
+
+// Match reports whether the expression holds for a host, that is for any
+// of its ports, or for the host alone if it has none.
+func (q *Query) Match(h nmap.Host) bool {
+	if len(h.Ports) == 0 {
+		return q.root.eval(&h, nil)
+	}
+	for i := range h.Ports {
+		if q.root.eval(&h, &h.Ports[i]) {
+			return true
+		}
+	}
+	return false
+}
+
+// MatchPort reports whether the expression holds for port p of host h.
+func (q *Query) MatchPort(h nmap.Host, p nmap.Port) bool {
+	return q.root.eval(&h, &p)
+}
+
+// Ports returns the ports of a host the expression holds for.
+func (q *Query) Ports(h nmap.Host) []nmap.Port {
+	var out []nmap.Port
+	for i := range h.Ports {
+		if q.root.eval(&h, &h.Ports[i]) {
+			out = append(out, h.Ports[i])
+		}
+	}
+	return out
+}
+
+// node is a parsed expression, or part of one.
+type node interface {
+	eval(h *nmap.Host, p *nmap.Port) bool
+}
+
+type and struct{ a, b node }
+
+func (n and) eval(h *nmap.Host, p *nmap.Port) bool { return n.a.eval(h, p) && n.b.eval(h, p) }
+
+type or struct{ a, b node }
+
+func (n or) eval(h *nmap.Host, p *nmap.Port) bool { return n.a.eval(h, p) || n.b.eval(h, p) }
+
+type not struct{ a node }
+
+func (n not) eval(h *nmap.Host, p *nmap.Port) bool { return !n.a.eval(h, p) }
+
+// compare holds when any value of a field satisfies pred, or with negate
+// set, when none does.
+type compare struct {
+	f      field
+	pred   func(v string) bool
+	negate bool
+}
+
+func (n compare) eval(h *nmap.Host, p *nmap.Port) bool {
+	var vals []string
+	if p != nil || !n.f.port {
+		vals = n.f.values(h, p)
+	}
+	for _, v := range vals {
+		if n.pred(v) {
+			return !n.negate
+		}
+	}
+	return n.negate
+}
+
+// equals returns a predicate testing for equality with lit, ignoring case.
+// Numeric fields compare as numbers, and the ip field also equals a CIDR
+// that holds the address.
+func equals(name string, f field, lit string) (func(string) bool, error) {
+	if f.numeric {
+		n, err := strconv.ParseFloat(lit, 64)
+		if err != nil {
+			return nil, fmt.Errorf("%s is a number, not %q", name, lit)
+		}
+		return func(v string) bool {
+			x, err := strconv.ParseFloat(v, 64)
+			return err == nil && x == n
+		}, nil
+	}
+	if name == "ip" && strings.Contains(lit, "/") {
+		_, cidr, err := net.ParseCIDR(lit)
+		if err != nil {
+			return nil, fmt.Errorf("bad CIDR %q: %v", lit, err)
+		}
+		return func(v string) bool {
+			ip := net.ParseIP(v)
+			return ip != nil && cidr.Contains(ip)
+		}, nil
+	}
+	return func(v string) bool {
+		return strings.EqualFold(v, lit)
+	}, nil
+}
+
+// ordered returns a predicate comparing a number with lit.
+func ordered(name string, f field, op, lit string) (func(string) bool, error) {
+	if !f.numeric {
+		return nil, fmt.Errorf("%s is not a number, %s cannot be used with it", name, op)
+	}
+	n, err := strconv.ParseFloat(lit, 64)
+	if err != nil {
+		return nil, fmt.Errorf("%s is a number, not %q", name, lit)
+	}
+	return func(v string) bool {
+		x, err := strconv.ParseFloat(v, 64)
+		if err != nil {
+			return false
+		}
+		switch op {
+		case "<":
+			return x < n
+		case "<=":
+			return x <= n
+		case ">":
+			return x > n
+		default:
+			return x >= n
+		}
+	}, nil
+}
+
+// matches returns a predicate testing for a match of the regular
+// expression lit, ignoring case.
+func matches(lit string) (func(string) bool, error) {
+	re, err := regexp.Compile("(?i)" + lit)
+	if err != nil {
+		return nil, fmt.Errorf("bad regular expression %q: %v", lit, err)
+	}
+	return re.MatchString, nil
+}
@@ -0,0 +1,172 @@
+package query
+
+import (
+	"fmt"
+	"testing"
+
+	nmap "github.com/Ullaakut/nmap/v3"
+)
+
+// testHost has three ports, each standing out in a different way.
+var testHost = nmap.Host{
+	Addresses: []nmap.Address{{Addr: "10.0.0.5", AddrType: "ipv4"}},
+	Hostnames: []nmap.Hostname{{Name: "web.example.com", Type: "PTR"}, {Name: "www.example.com", Type: "user"}},
+	Status:    nmap.Status{State: "up"},
+	OS:        nmap.OS{Matches: []nmap.OSMatch{{Name: "Microsoft Windows Server 2019"}}},
+	Ports: []nmap.Port{
+		{ID: 22, Protocol: "tcp", State: nmap.State{State: "filtered"}, Service: nmap.Service{Name: "ssh"}},
+		{ID: 80, Protocol: "tcp", State: nmap.State{State: "open"}, Service: nmap.Service{Name: "http", Product: `Microsoft "IIS" httpd`, Version: "10.0"}},
+		{ID: 443, Protocol: "tcp", State: nmap.State{State: "open"}, Service: nmap.Service{Name: "https", Tunnel: "ssl"}},
+	},
+}
+
+// TestPorts evaluates expressions against each port of testHost.
+func TestPorts(t *testing.T) {
+	tests := []struct {
+		expr string
+		want []uint16
+	}{
+		{"port = 80", []uint16{80}},
+		{"PORT == 080", []uint16{80}},
+		{"service = HTTP", []uint16{80}},
+		{"service ~ ^http", []uint16{80, 443}},
+		{"service !~ ^http", []uint16{22}},
+		{"state != open", []uint16{22}},
+
+		// not binds tighter than and, and and tighter than or
+		{"not port = 22 and state = filtered", nil},
+		{"not (port = 22 and state = filtered)", []uint16{80, 443}},
+		{"service = http or port = 22 and state = closed", []uint16{80}},
+		{"(service = http or port = 22) and state = closed", nil},
+		{"port = 22 or port = 80 and tunnel = ssl or port = 443", []uint16{22, 443}},
+		{"not not port = 22", []uint16{22}},
+		{"port = 22 AND NOT state = open", []uint16{22}},
+
+		{"port in (22, 443)", []uint16{22, 443}},
+		{"port not in (22,443)", []uint16{80}},
+		{`service in ("SSH", https)`, []uint16{22, 443}},
+		{"service not in (ssh)", []uint16{80, 443}},
+		{"port in (80) and state in (open, filtered)", []uint16{80}},
+
+		// \" is a quote, other escapes are kept for the regular expression
+		{`product = "Microsoft \"IIS\" httpd"`, []uint16{80}},
+		{`product ~ "\"iis\""`, []uint16{80}},
+		{`version ~ "^10\.\d$"`, []uint16{80}},
+		{`product = "Microsoft IIS httpd"`, nil},
+
+		// an ip equals a CIDR that holds it
+		{"ip = 10.0.0.5", []uint16{22, 80, 443}},
+		{"ip = 10.0.0.0/24", []uint16{22, 80, 443}},
+		{"ip = 10.0.0.6/31", nil},
+		{"ip != 10.0.0.0/8", nil},
+		{"ip in (192.168.0.0/16, 10.0.0.5/32)", []uint16{22, 80, 443}},
+
+		{"port < 443", []uint16{22, 80}},
+		{"port <= 443", []uint16{22, 80, 443}},
+		{"port > 80", []uint16{443}},
+		{"port >= 80 and port < 443", []uint16{80}},
+		{"port > 1e3", nil},
+
+		// a field of many values holds if any of them does, and != if
+		// none does
+		{"hostname = www.example.com", []uint16{22, 80, 443}},
+		{"hostname != www.example.com", nil},
+		{"hostname != ftp.example.com", []uint16{22, 80, 443}},
+		{"os ~ windows and port = 80", []uint16{80}},
+		{"mac = 00:11:22:33:44:55", nil},
+		{"mac != 00:11:22:33:44:55", []uint16{22, 80, 443}},
+	}
+	for _, tt := range tests {
+		q, err := Parse(tt.expr)
+		if err != nil {
+			t.Errorf("%s: %v", tt.expr, err)
+			continue
+		}
+		var got []uint16
+		for _, p := range q.Ports(testHost) {
+			got = append(got, p.ID)
+		}
+		if fmt.Sprint(got) != fmt.Sprint(tt.want) {
+			t.Errorf("%s: matched ports %v, want %v", tt.expr, got, tt.want)
+		}
+		if match := q.Match(testHost); match != (len(tt.want) > 0) {
+			t.Errorf("%s: Match is %v with ports %v", tt.expr, match, tt.want)
+		}
+	}
+}
+
+// TestMatch evaluates expressions against whole hosts.
+func TestMatch(t *testing.T) {
+	bare := nmap.Host{
+		Addresses: []nmap.Address{{Addr: "10.0.0.7", AddrType: "ipv4"}},
+		Status:    nmap.Status{State: "up"},
+	}
+	tests := []struct {
+		expr string
+		host nmap.Host
+		want bool
+	}{
+		// an expression holds for one port at a time, so no port is
+		// both 80 and 443
+		{"port = 80 and port = 443", testHost, false},
+		{"port = 80 or port = 443", testHost, true},
+		{"port = 80 and state = filtered", testHost, false},
+		{"port = 22 and state = filtered", testHost, true},
+
+		// a host without ports is evaluated once, with no port values
+		{"ip = 10.0.0.7", bare, true},
+		{"port = 80", bare, false},
+		{"port != 80", bare, true},
+		{"not port = 80", bare, true},
+		{"port < 1000", bare, false},
+		{"status = up and service !~ .", bare, true},
+	}
+	for _, tt := range tests {
+		q, err := Parse(tt.expr)
+		if err != nil {
+			t.Errorf("%s: %v", tt.expr, err)
+			continue
+		}
+		if got := q.Match(tt.host); got != tt.want {
+			t.Errorf("%s: Match is %v, want %v", tt.expr, got, tt.want)
+		}
+	}
+}
+
+func TestParseErrors(t *testing.T) {
+	tests := []struct {
+		expr string
+		want string
+	}{
+		{"", "expected a field but found end of expression at 1"},
+		{"port = ", "expected a value after = but found end of expression at 8"},
+		{"bogus = 1", `unknown field "bogus" at 1`},
+		{"port 80", "expected an operator after port but found '80' at 6"},
+		{"port ! 80", `unknown operator "!" at 6`},
+		{"port => 80", "expected a value after = but found '>' at 7"},
+		{"port = http", `port is a number, not "http" at 8`},
+		{"service < 5", "service is not a number, < cannot be used with it at 11"},
+		{`service ~ "(http"`, "bad regular expression \"(http\": error parsing regexp: missing closing ): `(?i)(http` at 11"},
+		{`product = "IIS`, "unterminated string at 11"},
+		{"ip = 10.0.0.0/33", `bad CIDR "10.0.0.0/33": invalid CIDR address: 10.0.0.0/33 at 6`},
+		{"(port = 80", "expected ')' but found end of expression at 11"},
+		{"port = 80)", "unexpected ')' at 10"},
+		{"port = 80 port = 443", "unexpected 'port' at 11"},
+		{"port = 80 and", "expected a field but found end of expression at 14"},
+		{"port in 80", "expected '(' after 'in' but found '80' at 9"},
+		{"port in (80 443)", "expected ',' or ')' but found '443' at 13"},
+		{"port in (80,)", "expected a value but found ')' at 13"},
+		{"port in (80, http)", `port is a number, not "http" at 14`},
+		{"port not 80", "expected 'in' after 'not' but found '80' at 10"},
+	}
+	for _, tt := range tests {
+		_, err := Parse(tt.expr)
+		if err == nil {
+			t.Errorf("%q parsed, want error %q", tt.expr, tt.want)
+			continue
+		}
+		if err.Error() != tt.want {
+			t.Errorf("%q: got error %q, want %q", tt.expr, err, tt.want)
+		}
+	}
+}
@@ -0,0 +1,286 @@
+package scandiff
+
+import (
+	"bufio"
+	"encoding/xml"
+	"io"
+	"strconv"
+	"strings"
+	"time"
+
+	nmap "github.com/Ullaakut/nmap/v3"
+	"github.com/redt1de/pnmap/internal/nmapxml"
+)
+
+// WriteNdiff writes a diff in the XML format of nmap's ndiff --xml, runA and
+// runB being the run metadata of the baseline and the new scan. Ports are
+// written with their state and service, scripts and OS matches are left out.
+func WriteNdiff(out io.Writer, runA, runB nmap.Run, hosts []Host) error {
+	w := &ndiffWriter{w: bufio.NewWriter(out)}
+	w.str(xml.Header)
+	w.start("nmapdiff", "version", "1")
+	w.start("scandiff")
+	if a, b := ndiffRun(runA), ndiffRun(runB); a != b {
+		w.start("a")
+		w.empty("nmaprun", a[:]...)
+		w.end("a")
+		w.start("b")
+		w.empty("nmaprun", b[:]...)
+		w.end("b")
+	}
+	for _, h := range hosts {
+		w.hostDiff(h)
+	}
+	w.end("scandiff")
+	w.end("nmapdiff")
+	if w.err != nil {
+		return w.err
+	}
+	return w.w.Flush()
+}
+
+// ndiffRun returns the attributes ndiff writes for a run.
+func ndiffRun(r nmap.Run) [8]string {
+	start := ""
+	if t := time.Time(r.Start); !t.IsZero() && t.Unix() > 0 {
+		start = strconv.FormatInt(t.Unix(), 10)
+	}
+	return [8]string{"scanner", r.Scanner, "args", r.Args, "start", start, "version", r.Version}
+}
+
+// ndiffWriter writes XML elements one at a time, each on its own line and
+// indented by its depth, keeping the first error. The opening tag of an
+// element is only closed once something is written inside it, so that an
+// element left empty is written as <name/>, the way nmap writes them.
+type ndiffWriter struct {
+	w     *bufio.Writer
+	depth int
+	// open is set while the opening tag of the element last started is
+	// still to be closed.
+	open bool
+	err  error
+}
+
+// start opens an element, attrs being name and value pairs. Attributes with
+// an empty value are left out.
+func (w *ndiffWriter) start(name string, attrs ...string) {
+	w.content()
+	w.str(strings.Repeat("  ", w.depth) + "<" + name)
+	for i := 0; i+1 < len(attrs); i += 2 {
+		if attrs[i+1] != "" {
+			w.str(" " + attrs[i] + `="` + nmapxml.Escape(attrs[i+1]) + `"`)
+		}
+	}
+	w.open = true
+	w.depth++
+}
+
+func (w *ndiffWriter) end(name string) {
+	w.depth--
+	if w.open {
+		w.open = false
+		w.str("/>\n")
+		return
+	}
+	w.str(strings.Repeat("  ", w.depth) + "</" + name + ">\n")
+}
+
+// empty writes an element with no content.
+func (w *ndiffWriter) empty(name string, attrs ...string) {
+	w.start(name, attrs...)
+	w.end(name)
+}
+
+// content closes the opening tag of the element last started, as something
+// is about to be written inside it.
+func (w *ndiffWriter) content() {
+	if w.open {
+		w.open = false
+		w.str(">\n")
+	}
+}
+
+func (w *ndiffWriter) str(s string) {
+	if w.err != nil {
+		return
+	}
+	_, w.err = w.w.WriteString(s)
+}
+
+// hostDiff writes a host that differs. A host listed in only one of the
+// scans is written whole, within <a> or <b>, any other host is written with
+// what differs about it within <a> and <b>.
+func (w *ndiffWriter) hostDiff(d Host) {
+	w.start("hostdiff")
+	defer w.end("hostdiff")
+	if d.A == nil || d.B == nil {
+		side, h := "a", d.A
+		if d.A == nil {
+			side, h = "b", d.B
+		}
+		w.start(side)
+		w.host(h)
+		w.end(side)
+		return
+	}
+
+	w.start("host")
+	if d.A.Status.State == d.B.Status.State {
+		w.empty("status", "state", d.A.Status.State)
+	} else {
+		w.start("a")
+		w.empty("status", "state", d.A.Status.State)
+		w.end("a")
+		w.start("b")
+		w.empty("status", "state", d.B.Status.State)
+		w.end("b")
+	}
+
+	addrs := func(h *nmap.Host) []pair {
+		var out []pair
+		for _, a := range h.Addresses {
+			out = append(out, pair{a.Addr, a.AddrType})
+		}
+		return out
+	}
+	w.sides(addrs(d.A), addrs(d.B), func(v pair) {
+		w.empty("address", "addr", v[0], "addrtype", v[1])
+	})
+
+	names := func(h *nmap.Host) []pair {
+		var out []pair
+		for _, hn := range h.Hostnames {
+			out = append(out, pair{hn.Name, hn.Type})
+		}
+		return out
+	}
+	if len(d.A.Hostnames)+len(d.B.Hostnames) > 0 {
+		w.start("hostnames")
+		w.sides(names(d.A), names(d.B), func(v pair) {
+			w.empty("hostname", "name", v[0], "type", v[1])
+		})
+		w.end("hostnames")
+	}
+
+	if len(d.Ports) > 0 {
+		w.start("ports")
+		for _, p := range d.Ports {
+			w.portDiff(p)
+		}
+		w.end("ports")
+	}
+	w.end("host")
+}
+
+// pair is an address or a hostname along with its type.
+type pair [2]string
+
+// sides writes the pairs both a and b hold, then within <a> and <b> those
+// only one of them does.
+func (w *ndiffWriter) sides(a, b []pair, write func(v pair)) {
+	for _, v := range a {
+		if hasPair(b, v) {
+			write(v)
+		}
+	}
+	w.only("a", a, b, write)
+	w.only("b", b, a, write)
+}
+
+// only writes within side the pairs of x that y does not hold.
+func (w *ndiffWriter) only(side string, x, y []pair, write func(v pair)) {
+	var only []pair
+	for _, v := range x {
+		if !hasPair(y, v) {
+			only = append(only, v)
+		}
+	}
+	if len(only) == 0 {
+		return
+	}
+	w.start(side)
+	for _, v := range only {
+		write(v)
+	}
+	w.end(side)
+}
+
+func hasPair(list []pair, v pair) bool {
+	for _, p := range list {
+		if p == v {
+			return true
+		}
+	}
+	return false
+}
+
+// host writes a host whole.
+func (w *ndiffWriter) host(h *nmap.Host) {
+	w.start("host")
+	w.empty("status", "state", h.Status.State)
+	for _, a := range h.Addresses {
+		w.empty("address", "addr", a.Addr, "addrtype", a.AddrType)
+	}
+	if len(h.Hostnames) > 0 {
+		w.start("hostnames")
+		for _, hn := range h.Hostnames {
+			w.empty("hostname", "name", hn.Name, "type", hn.Type)
+		}
+		w.end("hostnames")
+	}
+	if len(h.Ports) > 0 {
+		w.start("ports")
+		for i := range h.Ports {
+			w.port(&h.Ports[i], 0, "")
+		}
+		w.end("ports")
+	}
+	w.end("host")
+}
+
+// portDiff writes a port that differs. A port whose state is the same in
+// both scans is written once, with its services within <a> and <b>.
+func (w *ndiffWriter) portDiff(p Port) {
+	w.start("portdiff")
+	defer w.end("portdiff")
+	if p.A != nil && p.B != nil && p.A.State.State == p.B.State.State {
+		w.start("port", "portid", strconv.Itoa(int(p.ID)), "protocol", p.Protocol)
+		w.empty("state", "state", p.A.State.State)
+		w.start("a")
+		w.service(p.A.Service)
+		w.end("a")
+		w.start("b")
+		w.service(p.B.Service)
+		w.end("b")
+		w.end("port")
+		return
+	}
+	w.start("a")
+	w.port(p.A, p.ID, p.Protocol)
+	w.end("a")
+	w.start("b")
+	w.port(p.B, p.ID, p.Protocol)
+	w.end("b")
+}
+
+// port writes a port with its state and service. A port that is not listed
+// is written bare, as id and proto, the way ndiff writes a port it knows no
+// state of.
+func (w *ndiffWriter) port(p *nmap.Port, id uint16, proto string) {
+	if p == nil {
+		w.empty("port", "portid", strconv.Itoa(int(id)), "protocol", proto)
+		return
+	}
+	w.start("port", "portid", strconv.Itoa(int(p.ID)), "protocol", p.Protocol)
+	w.empty("state", "state", p.State.State)
+	w.service(p.Service)
+	w.end("port")
+}
+
+// service writes a service, unless nothing is known of it.
+func (w *ndiffWriter) service(s nmap.Service) {
+	if s.Name == "" && s.Product == "" && s.Version == "" && s.ExtraInfo == "" && s.Tunnel == "" {
+		return
+	}
+	w.empty("service", "name", s.Name, "product", s.Product, "version", s.Version, "extrainfo", s.ExtraInfo, "tunnel", s.Tunnel)
+}
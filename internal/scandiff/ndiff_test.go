@@ -0,0 +1,86 @@
+package scandiff
+
+import (
+	"bytes"
+	"strings"
+	"testing"
+
+	nmap "github.com/Ullaakut/nmap/v3"
+)
+
+func TestWriteNdiff(t *testing.T) {
+	port := func(id uint16, service, product string) nmap.Port {
+		return nmap.Port{ID: id, Protocol: "tcp", State: nmap.State{State: "open"}, Service: nmap.Service{Name: service, Product: product}}
+	}
+	a := map[string]nmap.Host{
+		"10.0.0.1": {
+			Addresses: []nmap.Address{{Addr: "10.0.0.1", AddrType: "ipv4"}},
+			Hostnames: []nmap.Hostname{{Name: "a&b.example.com", Type: "PTR"}},
+			Status:    nmap.Status{State: "up"},
+			Ports:     []nmap.Port{port(22, "ssh", ""), port(80, "http", "Apache httpd")},
+		},
+	}
+	b := map[string]nmap.Host{
+		"10.0.0.1": {
+			Addresses: []nmap.Address{{Addr: "10.0.0.1", AddrType: "ipv4"}},
+			Hostnames: []nmap.Hostname{{Name: "a&b.example.com", Type: "PTR"}},
+			Status:    nmap.Status{State: "up"},
+			Ports:     []nmap.Port{port(80, "http", `nginx "1.24"`)},
+		},
+	}
+	run := nmap.Run{Scanner: "nmap", Args: "nmap --top-ports 10", Version: "7.94"}
+
+	var out bytes.Buffer
+	if err := WriteNdiff(&out, run, run, Compare(a, b)); err != nil {
+		t.Fatal(err)
+	}
+	// elements with no content are written self-closing, as nmap does
+	want := `<?xml version="1.0" encoding="UTF-8"?>
+<nmapdiff version="1">
+  <scandiff>
+    <hostdiff>
+      <host>
+        <status state="up"/>
+        <address addr="10.0.0.1" addrtype="ipv4"/>
+        <hostnames>
+          <hostname name="a&amp;b.example.com" type="PTR"/>
+        </hostnames>
+        <ports>
+          <portdiff>
+            <a>
+              <port portid="22" protocol="tcp">
+                <state state="open"/>
+                <service name="ssh"/>
+              </port>
+            </a>
+            <b>
+              <port portid="22" protocol="tcp"/>
+            </b>
+          </portdiff>
+          <portdiff>
+            <port portid="80" protocol="tcp">
+              <state state="open"/>
+              <a>
+                <service name="http" product="Apache httpd"/>
+              </a>
+              <b>
+                <service name="http" product="nginx &quot;1.24&quot;"/>
+              </b>
+            </port>
+          </portdiff>
+        </ports>
+      </host>
+    </hostdiff>
+  </scandiff>
+</nmapdiff>
+`
+	if got := out.String(); got != want {
+		g, w := strings.Split(got, "\n"), strings.Split(want, "\n")
+		for i := 0; i < len(g) && i < len(w); i++ {
+			if g[i] != w[i] {
+				t.Fatalf("line %d:\n got: %s\nwant: %s", i+1, g[i], w[i])
+			}
+		}
+		t.Fatalf("got %d lines, want %d", len(g), len(w))
+	}
+}
@@ -0,0 +1,283 @@
+// Package scandiff compares the hosts of two scans, a baseline and a newer
+// one, and tells which hosts appeared or disappeared, which ports were opened
+// or closed and which services changed.
+package scandiff
+
+import (
+	"bytes"
+	"net"
+	"sort"
+	"strconv"
+
+	nmap "github.com/Ullaakut/nmap/v3"
+	"github.com/redt1de/pnmap/internal/merge"
+)
+
+// Change is what changed about a host or a port.
+type Change string
+
+const (
+	// Appeared is a host that is up in the new scan only.
+	Appeared Change = "appeared"
+	// Disappeared is a host that was up in the baseline only.
+	Disappeared Change = "disappeared"
+	// Changed is a host up in both scans whose ports differ.
+	Changed Change = "changed"
+
+	// Opened is a port that is open in the new scan only.
+	Opened Change = "opened"
+	// Closed is a port that was open in the baseline only.
+	Closed Change = "closed"
+	// ServiceChanged is a port open in both scans whose service differs.
+	ServiceChanged Change = "service"
+)
+
+// Host is a host that differs between the scans.
+type Host struct {
+	Key       string   `json:"host"`
+	Hostnames []string `json:"hostnames,omitempty"`
+	Change    Change   `json:"change"`
+	Ports     []Port   `json:"ports,omitempty"`
+
+	// A and B are the records of the host in the baseline and the new scan,
+	// nil where it is not listed.
+	A *nmap.Host `json:"-"`
+	B *nmap.Host `json:"-"`
+}
+
+// Port is a port that differs between the scans.
+type Port struct {
+	ID       uint16   `json:"port"`
+	Protocol string   `json:"protocol"`
+	Change   Change   `json:"change"`
+	Before   *Service `json:"before,omitempty"`
+	After    *Service `json:"after,omitempty"`
+
+	// A and B are the records of the port, nil where it is not listed.
+	A *nmap.Port `json:"-"`
+	B *nmap.Port `json:"-"`
+}
+
+// Name names the port the way nmap lists it, such as 80/tcp.
+func (p Port) Name() string {
+	return strconv.Itoa(int(p.ID)) + "/" + p.Protocol
+}
+
+// Service is the state of a port and the service found on it.
+type Service struct {
+	State     string `json:"state"`
+	Name      string `json:"name,omitempty"`
+	Product   string `json:"product,omitempty"`
+	Version   string `json:"version,omitempty"`
+	ExtraInfo string `json:"extrainfo,omitempty"`
+	Tunnel    string `json:"tunnel,omitempty"`
+}
+
+func newService(p *nmap.Port) *Service {
+	if p == nil {
+		return nil
+	}
+	return &Service{
+		State:     p.State.State,
+		Name:      p.Service.Name,
+		Product:   p.Service.Product,
+		Version:   p.Service.Version,
+		ExtraInfo: p.Service.ExtraInfo,
+		Tunnel:    p.Service.Tunnel,
+	}
+}
+
+// Summary counts the hosts that differ.
+type Summary struct {
+	Appeared    int `json:"appeared"`
+	Disappeared int `json:"disappeared"`
+	Changed     int `json:"changed"`
+}
+
+// Summarize counts the hosts of a diff by change.
+func Summarize(hosts []Host) Summary {
+	var s Summary
+	for _, h := range hosts {
+		switch h.Change {
+		case Appeared:
+			s.Appeared++
+		case Disappeared:
+			s.Disappeared++
+		default:
+			s.Changed++
+		}
+	}
+	return s
+}
+
+// Compare compares the hosts of a baseline and a new scan, keyed the way
+// hostid.Keyer keys them, and returns those that differ, ordered by address.
+//
+// Only hosts that are up and ports that are open are compared: a host that
+// is down is taken for one that is gone, and a port that is closed or
+// filtered for one that is closed. A service is only taken to have changed
+// when a field of it is known in both scans and differs, so that a scan run
+// without version detection does not show every service as changed.
+func Compare(a, b map[string]nmap.Host) []Host {
+	var keys []string
+	for k := range a {
+		keys = append(keys, k)
+	}
+	for k := range b {
+		if _, ok := a[k]; !ok {
+			keys = append(keys, k)
+		}
+	}
+	sortAddrs(keys)
+
+	var out []Host
+	for _, k := range keys {
+		ha, inA := a[k]
+		hb, inB := b[k]
+		d := Host{Key: k}
+		if inA {
+			d.A = &ha
+		}
+		if inB {
+			d.B = &hb
+		}
+		upA, upB := isUp(d.A), isUp(d.B)
+		switch {
+		case upA && upB:
+			d.Change = Changed
+		case upB:
+			d.Change = Appeared
+		case upA:
+			d.Change = Disappeared
+		default:
+			continue
+		}
+		if upA {
+			d.Hostnames = hostnames(d.A, d.Hostnames)
+		}
+		if upB {
+			d.Hostnames = hostnames(d.B, d.Hostnames)
+		}
+		d.Ports = comparePorts(d.A, d.B)
+		if d.Change == Changed && len(d.Ports) == 0 {
+			continue
+		}
+		out = append(out, d)
+	}
+	return out
+}
+
+// comparePorts compares the ports of the records of a host, either of which
+// may be nil.
+func comparePorts(a, b *nmap.Host) []Port {
+	ports := make(map[string]*Port)
+	var all []nmap.Port
+	if isUp(a) {
+		for i := range a.Ports {
+			p := &a.Ports[i]
+			ports[merge.PortKey(*p)] = &Port{ID: p.ID, Protocol: p.Protocol, A: p}
+			all = append(all, *p)
+		}
+	}
+	if isUp(b) {
+		for i := range b.Ports {
+			p := &b.Ports[i]
+			d, ok := ports[merge.PortKey(*p)]
+			if !ok {
+				d = &Port{ID: p.ID, Protocol: p.Protocol}
+				ports[merge.PortKey(*p)] = d
+				all = append(all, *p)
+			}
+			d.B = p
+		}
+	}
+	merge.SortPorts(all)
+
+	var out []Port
+	for _, p := range all {
+		d := ports[merge.PortKey(p)]
+		openA, openB := isOpen(d.A), isOpen(d.B)
+		switch {
+		case openA && openB:
+			if !serviceChanged(d.A.Service, d.B.Service) {
+				continue
+			}
+			d.Change = ServiceChanged
+		case openB:
+			d.Change = Opened
+		case openA:
+			d.Change = Closed
+		default:
+			continue
+		}
+		d.Before, d.After = newService(d.A), newService(d.B)
+		out = append(out, *d)
+	}
+	return out
+}
+
+// serviceChanged reports whether any field of a service that is known in
+// both scans differs.
+func serviceChanged(a, b nmap.Service) bool {
+	fields := [][2]string{
+		{a.Name, b.Name},
+		{a.Product, b.Product},
+		{a.Version, b.Version},
+		{a.ExtraInfo, b.ExtraInfo},
+		{a.Tunnel, b.Tunnel},
+	}
+	for _, f := range fields {
+		if f[0] != "" && f[1] != "" && f[0] != f[1] {
+			return true
+		}
+	}
+	return false
+}
+
+func isUp(h *nmap.Host) bool {
+	return h != nil && h.Status.State == "up"
+}
+
+func isOpen(p *nmap.Port) bool {
+	return p != nil && p.State.State == "open"
+}
+
+// hostnames adds the hostnames of a host to names, leaving out those it
+// already holds.
+func hostnames(h *nmap.Host, names []string) []string {
+	for _, hn := range h.Hostnames {
+		dup := hn.Name == ""
+		for _, n := range names {
+			if n == hn.Name {
+				dup = true
+			}
+		}
+		if !dup {
+			names = append(names, hn.Name)
+		}
+	}
+	return names
+}
+
+// sortAddrs sorts host keys by address, IPv4 before IPv6. Keys that are not
+// addresses go last, in the order of their text.
+func sortAddrs(keys []string) {
+	rank := func(k string) (int, []byte) {
+		ip := net.ParseIP(k)
+		switch {
+		case ip == nil:
+			return 2, []byte(k)
+		case ip.To4() != nil:
+			return 0, ip.To4()
+		}
+		return 1, ip.To16()
+	}
+	sort.Slice(keys, func(i, j int) bool {
+		ri, bi := rank(keys[i])
+		rj, bj := rank(keys[j])
+		if ri != rj {
+			return ri < rj
+		}
+		return bytes.Compare(bi, bj) < 0
+	})
+}
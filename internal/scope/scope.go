@@ -0,0 +1,312 @@
+// Package scope reads the scope of an engagement and tells which hosts,
+// hostnames and ports of a scan fall outside it.
+//
+// A scope file lists one rule per line, with comments starting at #:
+//
+//	10.0.0.0/24                 # in scope
+//	10.1.0.1-50   T:80,443      # in scope, but only these ports
+//	*.example.com               # hostnames below example.com
+//	!10.0.0.1                   # excluded
+//	!vpn.example.com
+//
+// The target of a rule is written the way nmap takes it, see targets.Parse,
+// and a rule starting with ! excludes what it matches. The optional ports are
+// written the way nmap's -p takes them, ports before any T:, U: or S: apply to
+// every protocol.
+//
+// A host is in scope if any of its IP addresses or in scope hostnames is
+// matched by a rule, and none of its addresses is excluded. Only the hostnames
+// a host was scanned as, those nmap marks as user, count here: a reverse DNS
+// name in scope does not bring an address into scope. When the scope
+// lists hostnames, a hostname is in scope only if a rule matches it, and a
+// hostname that is excluded is out of scope either way. A host matched by a
+// rule limiting ports has only those ports in scope, unless another rule
+// matching it has no limit.
+package scope
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"os"
+	"strconv"
+	"strings"
+
+	nmap "github.com/Ullaakut/nmap/v3"
+	"github.com/redt1de/pnmap/internal/targets"
+)
+
+// Rule is a single line of a scope file.
+type Rule struct {
+	Target  *targets.Entry
+	Exclude bool
+	// Ports limits the ports in scope, nil if all are.
+	Ports *Ports
+}
+
+// Scope is the scope of an engagement.
+type Scope struct {
+	Rules []*Rule
+	// names is set when any rule brings hostnames into scope.
+	names bool
+}
+
+// Load reads a scope file.
+func Load(path string) (*Scope, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	return Read(f, path)
+}
+
+// Read reads a scope. name locates its rules in messages.
+func Read(r io.Reader, name string) (*Scope, error) {
+	s := &Scope{}
+	scanner := bufio.NewScanner(r)
+	n := 0
+	for scanner.Scan() {
+		n++
+		line := scanner.Text()
+		if i := strings.IndexByte(line, '#'); i >= 0 {
+			line = line[:i]
+		}
+		fields := strings.Fields(line)
+		if len(fields) == 0 {
+			continue
+		}
+		if len(fields) > 2 {
+			return nil, fmt.Errorf("%s:%d: expected a target and optional ports, found %q", name, n, strings.TrimSpace(line))
+		}
+		rule := &Rule{}
+		text := fields[0]
+		if strings.HasPrefix(text, "!") {
+			rule.Exclude = true
+			text = text[1:]
+		}
+		t, err := targets.Parse(text)
+		if err != nil {
+			return nil, fmt.Errorf("%s:%d: %v", name, n, err)
+		}
+		t.Source, t.Line = name, n
+		rule.Target = t
+		if len(fields) == 2 {
+			if rule.Exclude {
+				return nil, fmt.Errorf("%s:%d: exclusions take no ports", name, n)
+			}
+			if rule.Ports, err = ParsePorts(fields[1]); err != nil {
+				return nil, fmt.Errorf("%s:%d: %v", name, n, err)
+			}
+		}
+		if t.IsName() && !rule.Exclude {
+			s.names = true
+		}
+		s.Rules = append(s.Rules, rule)
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, err
+	}
+	return s, nil
+}
+
+// Verdict is how a host measures up against the scope.
+type Verdict struct {
+	// In is set when the host is in scope. The hostnames and ports listed
+	// below are out of scope either way.
+	In bool
+	// Why says why a host is out of scope.
+	Why       string
+	Hostnames []string
+	Ports     []nmap.Port
+}
+
+// Clean reports whether everything about the host is in scope.
+func (v Verdict) Clean() bool {
+	return v.In && len(v.Hostnames) == 0 && len(v.Ports) == 0
+}
+
+// Check measures a host against the scope.
+func (s *Scope) Check(h nmap.Host) Verdict {
+	var v Verdict
+	ips := targets.IPs(h)
+	for _, r := range s.Rules {
+		if !r.Exclude {
+			continue
+		}
+		for _, ip := range ips {
+			if r.Target.MatchIP(ip) {
+				v.Why = "excluded by " + r.Target.String()
+			}
+		}
+	}
+
+	// hostnames are judged first, as only those in scope bring the host
+	// into scope. Only the names a host was scanned as do, a PTR record is
+	// whatever the owner of the address chose to publish.
+	var names []string
+	for _, n := range targets.Names(h) {
+		if !s.nameIn(n) {
+			v.Hostnames = append(v.Hostnames, n)
+		}
+	}
+	for _, hn := range h.Hostnames {
+		if n := targets.NormalizeName(hn.Name); hn.Type == "user" && n != "" && s.nameIn(n) {
+			names = append(names, n)
+		}
+	}
+
+	var matched []*Rule
+	for _, r := range s.Rules {
+		if !r.Exclude && r.Target.MatchAny(ips, names) {
+			matched = append(matched, r)
+		}
+	}
+	if v.Why != "" {
+		return v
+	}
+	if len(matched) == 0 {
+		v.Why = "not matched by any rule"
+		return v
+	}
+	v.In = true
+
+	var limits []*Ports
+	for _, r := range matched {
+		if r.Ports == nil {
+			return v
+		}
+		limits = append(limits, r.Ports)
+	}
+	for _, p := range h.Ports {
+		allowed := false
+		for _, l := range limits {
+			if l.Contains(p.Protocol, p.ID) {
+				allowed = true
+				break
+			}
+		}
+		if !allowed {
+			v.Ports = append(v.Ports, p)
+		}
+	}
+	return v
+}
+
+// nameIn reports whether a hostname is in scope.
+func (s *Scope) nameIn(name string) bool {
+	in := !s.names
+	for _, r := range s.Rules {
+		if !r.Target.IsName() || !r.Target.MatchName(name) {
+			continue
+		}
+		if r.Exclude {
+			return false
+		}
+		in = true
+	}
+	return in
+}
+
+// Trim returns the host with the hostnames and ports of v that are out of
+// scope removed.
+func Trim(h nmap.Host, v Verdict) nmap.Host {
+	if len(v.Hostnames) > 0 {
+		out := make(map[string]bool)
+		for _, n := range v.Hostnames {
+			out[n] = true
+		}
+		var keep []nmap.Hostname
+		for _, hn := range h.Hostnames {
+			if !out[targets.NormalizeName(hn.Name)] {
+				keep = append(keep, hn)
+			}
+		}
+		h.Hostnames = keep
+	}
+	if len(v.Ports) > 0 {
+		out := make(map[string]bool)
+		for _, p := range v.Ports {
+			out[portKey(p.Protocol, p.ID)] = true
+		}
+		var keep []nmap.Port
+		for _, p := range h.Ports {
+			if !out[portKey(p.Protocol, p.ID)] {
+				keep = append(keep, p)
+			}
+		}
+		h.Ports = keep
+	}
+	return h
+}
+
+func portKey(proto string, id uint16) string {
+	return strconv.Itoa(int(id)) + "/" + proto
+}
+
+// Ports is a set of ports, per protocol, written the way nmap's -p takes it.
+type Ports struct {
+	// ranges are keyed by protocol, "" holding those of any protocol.
+	ranges map[string][][2]uint16
+}
+
+// protocols maps the protocol prefixes of nmap's -p.
+var protocols = map[string]string{"T": "tcp", "U": "udp", "S": "sctp"}
+
+// ParsePorts parses a port list such as 22,80-90,U:53,161.
+func ParsePorts(s string) (*Ports, error) {
+	ps := &Ports{ranges: make(map[string][][2]uint16)}
+	proto := ""
+	for _, part := range strings.Split(s, ",") {
+		if pfx, rest, ok := strings.Cut(part, ":"); ok {
+			p, known := protocols[strings.ToUpper(pfx)]
+			if !known {
+				return nil, fmt.Errorf("unknown protocol %q in ports %q, use T:, U: or S:", pfx, s)
+			}
+			proto, part = p, rest
+		}
+		if part == "" {
+			return nil, fmt.Errorf("bad ports %q: empty entry", s)
+		}
+		from, to, isRange := strings.Cut(part, "-")
+		lo, err := port(from, 1)
+		if err != nil {
+			return nil, fmt.Errorf("bad ports %q: %v", s, err)
+		}
+		hi := lo
+		if isRange {
+			if hi, err = port(to, 65535); err != nil {
+				return nil, fmt.Errorf("bad ports %q: %v", s, err)
+			}
+		}
+		if lo > hi {
+			return nil, fmt.Errorf("bad ports %q: %d is above %d", s, lo, hi)
+		}
+		ps.ranges[proto] = append(ps.ranges[proto], [2]uint16{lo, hi})
+	}
+	return ps, nil
+}
+
+// port parses a port number, def standing in for an open end of a range.
+func port(s string, def uint16) (uint16, error) {
+	if s == "" {
+		return def, nil
+	}
+	n, err := strconv.Atoi(s)
+	if err != nil || n < 0 || n > 65535 {
+		return 0, fmt.Errorf("%q is not a port", s)
+	}
+	return uint16(n), nil
+}
+
+// Contains reports whether the set holds a port.
+func (ps *Ports) Contains(proto string, id uint16) bool {
+	for _, p := range []string{"", proto} {
+		for _, r := range ps.ranges[p] {
+			if id >= r[0] && id <= r[1] {
+				return true
+			}
+		}
+	}
+	return false
+}
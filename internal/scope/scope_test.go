@@ -0,0 +1,155 @@
+package scope
+
+import (
+	"fmt"
+	"strings"
+	"testing"
+
+	nmap "github.com/Ullaakut/nmap/v3"
+)
+
+func host(addr string, names ...nmap.Hostname) nmap.Host {
+	return nmap.Host{
+		Addresses: []nmap.Address{{Addr: addr, AddrType: "ipv4"}},
+		Hostnames: names,
+		Ports: []nmap.Port{
+			{ID: 22, Protocol: "tcp"},
+			{ID: 80, Protocol: "tcp"},
+			{ID: 53, Protocol: "udp"},
+		},
+	}
+}
+
+func ptr(name string) nmap.Hostname  { return nmap.Hostname{Name: name, Type: "PTR"} }
+func user(name string) nmap.Hostname { return nmap.Hostname{Name: name, Type: "user"} }
+
+// TestCheck measures hosts against a scope with exclusions, port limits and
+// hostnames.
+func TestCheck(t *testing.T) {
+	s, err := Read(strings.NewReader(`
+10.0.0.0/24
+!10.0.0.1
+10.1.0.1-50   T:80,U:53
+10.1.0.5      22
+*.example.com
+!vpn.example.com
+`), "scope")
+	if err != nil {
+		t.Fatal(err)
+	}
+	tests := []struct {
+		name  string
+		host  nmap.Host
+		in    bool
+		why   string
+		names []string
+		ports []uint16
+	}{
+		{"in range", host("10.0.0.5"), true, "", nil, nil},
+		{"excluded", host("10.0.0.1"), false, "excluded by 10.0.0.1 (scope:3)", nil, nil},
+		{"no rule", host("10.2.0.1"), false, "not matched by any rule", nil, nil},
+
+		// a rule limiting ports leaves the others out, unless another
+		// rule matching the host adds them
+		{"port limit", host("10.1.0.9"), true, "", nil, []uint16{22}},
+		{"two port limits", host("10.1.0.5"), true, "", nil, nil},
+
+		// a PTR name in scope does not bring its address in, the name the
+		// host was scanned as does
+		{"ptr in scope", host("192.0.2.7", ptr("web.example.com")), false, "not matched by any rule", nil, nil},
+		{"user name in scope", host("192.0.2.7", user("WEB.example.com.")), true, "", nil, nil},
+		{"user name excluded", host("192.0.2.7", user("vpn.example.com")), false, "not matched by any rule", []string{"vpn.example.com"}, nil},
+
+		// the scope lists hostnames, so one it does not match is out, as is
+		// an excluded one on a host in scope
+		{"ptr out of scope", host("10.0.0.5", ptr("mail.other.com")), true, "", []string{"mail.other.com"}, nil},
+		{"ptr excluded", host("10.0.0.5", ptr("vpn.example.com"), ptr("www.example.com")), true, "", []string{"vpn.example.com"}, nil},
+	}
+	for _, tt := range tests {
+		v := s.Check(tt.host)
+		var ports []uint16
+		for _, p := range v.Ports {
+			ports = append(ports, p.ID)
+		}
+		if v.In != tt.in || v.Why != tt.why || fmt.Sprint(v.Hostnames) != fmt.Sprint(tt.names) || fmt.Sprint(ports) != fmt.Sprint(tt.ports) {
+			t.Errorf("%s: got in %v why %q hostnames %v ports %v, want in %v why %q hostnames %v ports %v",
+				tt.name, v.In, v.Why, v.Hostnames, ports, tt.in, tt.why, tt.names, tt.ports)
+		}
+	}
+}
+
+// TestCheckNoNames keeps every hostname when the scope lists none.
+func TestCheckNoNames(t *testing.T) {
+	s, err := Read(strings.NewReader("10.0.0.0/24\n"), "scope")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if v := s.Check(host("10.0.0.5", ptr("anything.other.com"))); !v.Clean() {
+		t.Errorf("verdict %+v, want clean", v)
+	}
+}
+
+func TestTrim(t *testing.T) {
+	h := host("10.0.0.5", ptr("Mail.Other.com."), ptr("www.example.com"))
+	v := Verdict{In: true, Hostnames: []string{"mail.other.com"}, Ports: []nmap.Port{{ID: 22, Protocol: "tcp"}}}
+	got := Trim(h, v)
+	if len(got.Hostnames) != 1 || got.Hostnames[0].Name != "www.example.com" {
+		t.Errorf("hostnames %v", got.Hostnames)
+	}
+	if len(got.Ports) != 2 || got.Ports[0].ID != 80 || got.Ports[1].ID != 53 {
+		t.Errorf("ports %v", got.Ports)
+	}
+}
+
+func TestParsePorts(t *testing.T) {
+	tests := []struct {
+		ports string
+		in    []string
+		out   []string
+	}{
+		{"22,80-90", []string{"22/tcp", "85/udp"}, []string{"21/tcp", "91/tcp"}},
+		{"T:80,443,U:53", []string{"80/tcp", "443/tcp", "53/udp"}, []string{"443/udp", "53/tcp"}},
+		{"-1024", []string{"1/tcp", "1024/udp"}, []string{"1025/tcp"}},
+		{"s:60000-", []string{"65535/sctp"}, []string{"60000/tcp"}},
+	}
+	for _, tt := range tests {
+		ps, err := ParsePorts(tt.ports)
+		if err != nil {
+			t.Errorf("%s: %v", tt.ports, err)
+			continue
+		}
+		for want, list := range map[bool][]string{true: tt.in, false: tt.out} {
+			for _, p := range list {
+				var id uint16
+				var proto string
+				fmt.Sscanf(strings.Replace(p, "/", " ", 1), "%d %s", &id, &proto)
+				if ps.Contains(proto, id) != want {
+					t.Errorf("%s: Contains(%s) is %v", tt.ports, p, !want)
+				}
+			}
+		}
+	}
+
+	for _, bad := range []string{"X:80", "80-22", "http", "70000", "22,"} {
+		if _, err := ParsePorts(bad); err == nil {
+			t.Errorf("%q parsed", bad)
+		}
+	}
+}
+
+func TestReadErrors(t *testing.T) {
+	tests := []struct {
+		in   string
+		want string
+	}{
+		{"10.0.0.1 22 80\n", `scope:1: expected a target and optional ports, found "10.0.0.1 22 80"`},
+		{"# header\n!10.0.0.1 22\n", "scope:2: exclusions take no ports"},
+		{"10.0.0.300\n", "scope:1: "},
+	}
+	for _, tt := range tests {
+		_, err := Read(strings.NewReader(tt.in), "scope")
+		if err == nil || !strings.HasPrefix(err.Error(), tt.want) {
+			t.Errorf("%q: got error %v, want %q", tt.in, err, tt.want)
+		}
+	}
+}
@@ -0,0 +1,348 @@
+// Package targets reads lists of hosts, such as the include and exclude
+// lists of an engagement, written the way nmap takes them with -iL: IP
+// addresses, CIDRs, octet ranges and hostnames, separated by whitespace, with
+// comments starting at #.
+package targets
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"net"
+	"os"
+	"strconv"
+	"strings"
+
+	nmap "github.com/Ullaakut/nmap/v3"
+)
+
+// Entry is a single entry of a list.
+type Entry struct {
+	Text string
+	// Source and Line locate the entry, for messages.
+	Source string
+	Line   int
+
+	// an entry matches either addresses or names
+	ip   func(ip net.IP) bool
+	name func(name string) bool
+	hits int
+}
+
+func (e *Entry) String() string {
+	return fmt.Sprintf("%s (%s:%d)", e.Text, e.Source, e.Line)
+}
+
+// List is a list of hosts.
+type List struct {
+	Entries []*Entry
+}
+
+// Load reads a list from a file.
+func Load(path string) (*List, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	return Read(f, path)
+}
+
+// Read reads a list. name locates its entries in messages.
+func Read(r io.Reader, name string) (*List, error) {
+	l := &List{}
+	scanner := bufio.NewScanner(r)
+	n := 0
+	for scanner.Scan() {
+		n++
+		line := scanner.Text()
+		if i := strings.IndexByte(line, '#'); i >= 0 {
+			line = line[:i]
+		}
+		// strings.Fields drops the \r of Windows line endings along with
+		// any other whitespace
+		for _, text := range strings.Fields(line) {
+			e, err := Parse(text)
+			if err != nil {
+				return nil, fmt.Errorf("%s:%d: %v", name, n, err)
+			}
+			e.Source, e.Line = name, n
+			l.Entries = append(l.Entries, e)
+		}
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, err
+	}
+	return l, nil
+}
+
+// Match reports whether any entry matches the host, by any of its IP
+// addresses or hostnames, and counts the hit against every entry that does.
+func (l *List) Match(h nmap.Host) bool {
+	ips, names := IPs(h), Names(h)
+	matched := false
+	for _, e := range l.Entries {
+		if e.MatchAny(ips, names) {
+			matched = true
+		}
+	}
+	return matched
+}
+
+// IPs returns every IP address of a host, such as the several of a host
+// merged from records sharing a MAC address.
+func IPs(h nmap.Host) []net.IP {
+	var ips []net.IP
+	for _, a := range h.Addresses {
+		if a.AddrType != "ipv4" && a.AddrType != "ipv6" {
+			continue
+		}
+		if ip := net.ParseIP(a.Addr); ip != nil {
+			ips = append(ips, ip)
+		}
+	}
+	return ips
+}
+
+// Names returns the hostnames of a host, in the form entries match them.
+func Names(h nmap.Host) []string {
+	var names []string
+	for _, hn := range h.Hostnames {
+		if hn.Name != "" {
+			names = append(names, NormalizeName(hn.Name))
+		}
+	}
+	return names
+}
+
+// NormalizeName lowercases a hostname and drops any trailing dot.
+func NormalizeName(name string) string {
+	return strings.ToLower(strings.TrimSuffix(name, "."))
+}
+
+// IsName reports whether the entry matches hostnames rather than addresses.
+func (e *Entry) IsName() bool {
+	return e.name != nil
+}
+
+// MatchIP reports whether the entry matches an address, counting the hit.
+func (e *Entry) MatchIP(ip net.IP) bool {
+	if e.ip != nil && e.ip(ip) {
+		e.hits++
+		return true
+	}
+	return false
+}
+
+// MatchName reports whether the entry matches a hostname as returned by
+// NormalizeName, counting the hit.
+func (e *Entry) MatchName(name string) bool {
+	if e.name != nil && e.name(name) {
+		e.hits++
+		return true
+	}
+	return false
+}
+
+// MatchAny reports whether the entry matches any of ips or names.
+func (e *Entry) MatchAny(ips []net.IP, names []string) bool {
+	for _, ip := range ips {
+		if e.MatchIP(ip) {
+			return true
+		}
+	}
+	for _, n := range names {
+		if e.MatchName(n) {
+			return true
+		}
+	}
+	return false
+}
+
+// Unmatched returns the entries that have not matched any host so far.
+func (l *List) Unmatched() []*Entry {
+	var out []*Entry
+	for _, e := range l.Entries {
+		if e.hits == 0 {
+			out = append(out, e)
+		}
+	}
+	return out
+}
+
+// Parse parses a single entry: an IPv4 or IPv6 address, a CIDR, an nmap
+// octet range such as 10.0.0-3.1,5,10-20 or 10.0.*.1, a range of addresses
+// such as 10.0.0.1-10.0.0.50, or a hostname, which may start with *. to
+// match any name below a domain.
+func Parse(text string) (*Entry, error) {
+	e := &Entry{Text: text}
+
+	if ip := net.ParseIP(text); ip != nil {
+		e.ip = ip.Equal
+		return e, nil
+	}
+
+	if strings.Contains(text, "/") {
+		_, cidr, err := net.ParseCIDR(text)
+		if err != nil {
+			return nil, fmt.Errorf("bad CIDR %q, CIDRs of hostnames are not supported", text)
+		}
+		e.ip = cidr.Contains
+		return e, nil
+	}
+
+	if from, to, ok := strings.Cut(text, "-"); ok {
+		lo, hi := net.ParseIP(from), net.ParseIP(to)
+		if lo != nil && hi != nil {
+			if (lo.To4() == nil) != (hi.To4() == nil) {
+				return nil, fmt.Errorf("range %q mixes IPv4 and IPv6", text)
+			}
+			e.ip = func(a net.IP) bool {
+				return (a.To4() == nil) == (lo.To4() == nil) && compareIP(a, lo) >= 0 && compareIP(a, hi) <= 0
+			}
+			return e, nil
+		}
+	}
+
+	if octets, ok, err := parseOctets(text); ok {
+		if err != nil {
+			return nil, err
+		}
+		e.ip = func(a net.IP) bool {
+			a4 := a.To4()
+			if a4 == nil {
+				return false
+			}
+			for i, set := range octets {
+				if !set[a4[i]] {
+					return false
+				}
+			}
+			return true
+		}
+		return e, nil
+	}
+
+	if !isHostname(text) {
+		return nil, fmt.Errorf("%q is not an address, CIDR, range or hostname", text)
+	}
+	name := NormalizeName(text)
+	if strings.HasPrefix(name, "*.") {
+		suffix := name[1:]
+		e.name = func(n string) bool {
+			return strings.HasSuffix(n, suffix)
+		}
+		return e, nil
+	}
+	e.name = func(n string) bool {
+		return n == name
+	}
+	return e, nil
+}
+
+// compareIP compares two addresses of the same family.
+func compareIP(a, b net.IP) int {
+	if a4, b4 := a.To4(), b.To4(); a4 != nil && b4 != nil {
+		a, b = a4, b4
+	} else {
+		a, b = a.To16(), b.To16()
+	}
+	for i := range a {
+		if a[i] != b[i] {
+			if a[i] < b[i] {
+				return -1
+			}
+			return 1
+		}
+	}
+	return 0
+}
+
+// parseOctets parses an nmap octet range. ok is false if text does not look
+// like one at all, such as a hostname.
+func parseOctets(text string) (octets [4][256]bool, ok bool, err error) {
+	parts := strings.Split(text, ".")
+	if len(parts) != 4 {
+		return octets, false, nil
+	}
+	for _, p := range parts {
+		if p == "" || strings.Trim(p, "0123456789,-*") != "" {
+			return octets, false, nil
+		}
+	}
+	for i, p := range parts {
+		for _, r := range strings.Split(p, ",") {
+			lo, hi, err := octetRange(r)
+			if err != nil {
+				return octets, true, fmt.Errorf("bad octet range %q in %q: %v", r, text, err)
+			}
+			for v := lo; v <= hi; v++ {
+				octets[i][v] = true
+			}
+		}
+	}
+	return octets, true, nil
+}
+
+// octetRange parses a single range of an octet: *, n, n-m, -m or n-.
+func octetRange(r string) (int, int, error) {
+	if r == "*" {
+		return 0, 255, nil
+	}
+	if r == "" {
+		return 0, 0, fmt.Errorf("empty range")
+	}
+	from, to, isRange := strings.Cut(r, "-")
+	lo, hi := 0, 255
+	var err error
+	if from != "" {
+		if lo, err = octet(from); err != nil {
+			return 0, 0, err
+		}
+	}
+	if !isRange {
+		return lo, lo, nil
+	}
+	if to != "" {
+		if hi, err = octet(to); err != nil {
+			return 0, 0, err
+		}
+	}
+	if lo > hi {
+		return 0, 0, fmt.Errorf("%d is above %d", lo, hi)
+	}
+	return lo, hi, nil
+}
+
+func octet(s string) (int, error) {
+	n, err := strconv.Atoi(s)
+	if err != nil || n < 0 || n > 255 {
+		return 0, fmt.Errorf("%q is not an octet", s)
+	}
+	return n, nil
+}
+
+// isHostname reports whether s can be a hostname, allowing a leading *.
+func isHostname(s string) bool {
+	s = strings.TrimPrefix(strings.TrimSuffix(s, "."), "*.")
+	if s == "" || len(s) > 253 {
+		return false
+	}
+	letter := false
+	for _, label := range strings.Split(s, ".") {
+		if label == "" || label[0] == '-' || label[len(label)-1] == '-' {
+			return false
+		}
+		for _, c := range label {
+			switch {
+			case c >= 'a' && c <= 'z', c >= 'A' && c <= 'Z', c == '_':
+				letter = true
+			case c >= '0' && c <= '9', c == '-':
+			default:
+				return false
+			}
+		}
+	}
+	// an all numeric name would be a mistyped address
+	return letter
+}
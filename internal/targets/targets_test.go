@@ -0,0 +1,169 @@
+package targets
+
+import (
+	"net"
+	"strings"
+	"testing"
+
+	nmap "github.com/Ullaakut/nmap/v3"
+)
+
+func TestParse(t *testing.T) {
+	tests := []struct {
+		entry string
+		in    []string
+		out   []string
+	}{
+		{"10.0.0.5", []string{"10.0.0.5", "::ffff:10.0.0.5"}, []string{"10.0.0.4", "10.0.0.50"}},
+		{"2001:db8::1", []string{"2001:db8:0:0::1"}, []string{"2001:db8::2"}},
+
+		{"10.0.0.0/30", []string{"10.0.0.0", "10.0.0.3"}, []string{"10.0.0.4", "2001:db8::1"}},
+		{"10.0.0.5/32", []string{"10.0.0.5"}, []string{"10.0.0.4"}},
+		{"2001:db8::/32", []string{"2001:db8::1", "2001:db8:ffff::1"}, []string{"2001:db9::1", "10.0.0.1"}},
+		{"2001:db8:0:1::/64", []string{"2001:db8:0:1::5"}, []string{"2001:db8:0:2::5"}},
+
+		// nmap octet ranges
+		{"10.0.0-3.1", []string{"10.0.0.1", "10.0.3.1"}, []string{"10.0.4.1", "10.0.0.2"}},
+		{"10.0.0.1,5,10-20", []string{"10.0.0.1", "10.0.0.5", "10.0.0.10", "10.0.0.20"}, []string{"10.0.0.2", "10.0.0.21"}},
+		{"10.0.*.1", []string{"10.0.0.1", "10.0.255.1"}, []string{"10.1.0.1", "10.0.0.2"}},
+		{"10.0.0.-3", []string{"10.0.0.0", "10.0.0.3"}, []string{"10.0.0.4"}},
+		{"10.0.0.250-", []string{"10.0.0.250", "10.0.0.255"}, []string{"10.0.0.249"}},
+		{"10.0.0.*", []string{"::ffff:10.0.0.7"}, []string{"2001:db8::1"}},
+
+		// ranges of addresses
+		{"10.0.0.250-10.0.1.5", []string{"10.0.0.250", "10.0.0.255", "10.0.1.0", "10.0.1.5"}, []string{"10.0.0.249", "10.0.1.6"}},
+		{"2001:db8::1-2001:db8::ff", []string{"2001:db8::1", "2001:db8::80"}, []string{"2001:db8::100", "10.0.0.1"}},
+	}
+	for _, tt := range tests {
+		e, err := Parse(tt.entry)
+		if err != nil {
+			t.Errorf("%s: %v", tt.entry, err)
+			continue
+		}
+		if e.IsName() {
+			t.Errorf("%s: parsed as a hostname", tt.entry)
+		}
+		for _, a := range tt.in {
+			if !e.MatchIP(net.ParseIP(a)) {
+				t.Errorf("%s does not match %s", tt.entry, a)
+			}
+		}
+		for _, a := range tt.out {
+			if e.MatchIP(net.ParseIP(a)) {
+				t.Errorf("%s matches %s", tt.entry, a)
+			}
+		}
+	}
+}
+
+func TestParseNames(t *testing.T) {
+	tests := []struct {
+		entry string
+		in    []string
+		out   []string
+	}{
+		{"web.example.com", []string{"web.example.com"}, []string{"example.com", "www.web.example.com"}},
+		{"WEB.Example.com.", []string{"web.example.com"}, []string{"web.example.co"}},
+		{"*.example.com", []string{"web.example.com", "a.b.example.com"}, []string{"example.com", "badexample.com", "example.com.evil"}},
+		{"db-1", []string{"db-1"}, []string{"db-12"}},
+		{"_ldap._tcp.example.com", []string{"_ldap._tcp.example.com"}, nil},
+	}
+	for _, tt := range tests {
+		e, err := Parse(tt.entry)
+		if err != nil {
+			t.Errorf("%s: %v", tt.entry, err)
+			continue
+		}
+		if !e.IsName() {
+			t.Errorf("%s: not parsed as a hostname", tt.entry)
+		}
+		for _, n := range tt.in {
+			if !e.MatchName(NormalizeName(n)) {
+				t.Errorf("%s does not match %s", tt.entry, n)
+			}
+		}
+		for _, n := range tt.out {
+			if e.MatchName(NormalizeName(n)) {
+				t.Errorf("%s matches %s", tt.entry, n)
+			}
+		}
+	}
+}
+
+func TestParseErrors(t *testing.T) {
+	tests := []string{
+		"10.0.0.0/33",
+		"web.example.com/24",
+		"10.0.0.1-2001:db8::1",
+		"10.0.0.300",
+		"10.0.0.5-3",
+		"10.0.0.1,,2",
+		"10.0.0",
+		"-web.example.com",
+		"web..example.com",
+		"web_ex@mple.com",
+		"*.",
+	}
+	for _, text := range tests {
+		if _, err := Parse(text); err == nil {
+			t.Errorf("%q parsed", text)
+		}
+	}
+}
+
+func TestRead(t *testing.T) {
+	in := "# engagement targets\r\n" +
+		"10.0.0.0/24 10.0.1.5\r\n" +
+		"\r\n" +
+		"web.example.com   # the portal\r\n" +
+		"\t*.corp.example.com\r\n"
+	l, err := Read(strings.NewReader(in), "targets.txt")
+	if err != nil {
+		t.Fatal(err)
+	}
+	var got []string
+	for _, e := range l.Entries {
+		got = append(got, e.String())
+	}
+	want := []string{
+		"10.0.0.0/24 (targets.txt:2)",
+		"10.0.1.5 (targets.txt:2)",
+		"web.example.com (targets.txt:4)",
+		"*.corp.example.com (targets.txt:5)",
+	}
+	if strings.Join(got, "\n") != strings.Join(want, "\n") {
+		t.Errorf("entries %q, want %q", got, want)
+	}
+
+	_, err = Read(strings.NewReader("10.0.0.1\r\n10.0.0.300\r\n"), "targets.txt")
+	if err == nil || !strings.HasPrefix(err.Error(), "targets.txt:2: ") {
+		t.Errorf("got error %v, want one at targets.txt:2", err)
+	}
+}
+
+// TestMatch matches hosts by any of their addresses and hostnames, and
+// tells which entries never matched.
+func TestMatch(t *testing.T) {
+	l, err := Read(strings.NewReader("10.0.0.5\n2001:db8::/32\n*.example.com\n192.168.0.1\n"), "list")
+	if err != nil {
+		t.Fatal(err)
+	}
+	tests := []struct {
+		host nmap.Host
+		want bool
+	}{
+		{nmap.Host{Addresses: []nmap.Address{{Addr: "10.0.0.5", AddrType: "ipv4"}}}, true},
+		// the second address of a host merged from two records
+		{nmap.Host{Addresses: []nmap.Address{{Addr: "10.0.0.9", AddrType: "ipv4"}, {Addr: "00:50:56:AA:BB:CC", AddrType: "mac"}, {Addr: "2001:db8::9", AddrType: "ipv6"}}}, true},
+		{nmap.Host{Addresses: []nmap.Address{{Addr: "10.0.0.9", AddrType: "ipv4"}}, Hostnames: []nmap.Hostname{{Name: "WWW.Example.com."}}}, true},
+		{nmap.Host{Addresses: []nmap.Address{{Addr: "10.0.0.9", AddrType: "ipv4"}}, Hostnames: []nmap.Hostname{{Name: "example.com"}}}, false},
+	}
+	for _, tt := range tests {
+		if got := l.Match(tt.host); got != tt.want {
+			t.Errorf("Match(%+v) = %v, want %v", tt.host, got, tt.want)
+		}
+	}
+	if u := l.Unmatched(); len(u) != 1 || u[0].Text != "192.168.0.1" {
+		t.Errorf("unmatched %v", u)
+	}
+}